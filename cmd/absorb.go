@@ -1,46 +1,126 @@
 package cmd
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"github.com/ms-henglu/graft/internal/absorb"
 	"github.com/ms-henglu/graft/internal/log"
+	"github.com/ms-henglu/graft/internal/manifest"
 	"github.com/spf13/cobra"
 )
 
 func NewAbsorbCmd() *cobra.Command {
 	var outputFile string
 	var providersSchemaFile string
+	var format string
+	var interactive bool
+	var ignoreFile string
+	var include []string
+	var exclude []string
+	var overwrite bool
+	var mode string
+	var ignoreChanges []string
+	var runPlan bool
 
 	cmd := &cobra.Command{
-		Use:   "absorb <plan.json>",
+		Use:   "absorb [plan.json | tfplan]",
 		Short: "Absorb drift from Terraform plan into graft manifest",
 		Long: `Absorb drift from a Terraform plan JSON file into an absorb.graft.hcl.
+Use --format json (or an --output ending in .json) to write absorb.graft.json instead.
 
-This command analyzes the plan JSON to identify resources with "update" actions
-(drift) and generates override blocks to match the current remote state.
+The plan can also be a binary plan saved with 'terraform plan -out', which is
+converted with 'terraform show -json'. With --run-plan, no plan file is needed:
+graft runs 'terraform plan -refresh-only' in the current directory itself.
+
+This command analyzes the plan JSON to identify resources changed outside of
+Terraform (drift) and generates override blocks to match the current remote
+state. The plan's resource_drift is used, so pending config edits aren't
+absorbed; plans from Terraform before 0.15.4, which has no resource_drift, fall
+back to resources with "update" actions.
 
 When a providers schema file is provided (via --providers-schema or -p), the
 command uses schema information to improve the accuracy of the generated manifest.
 If no providers schema file is provided, the command will attempt to run 
 'terraform providers schema -json' in the current directory.
 
+With --interactive (-i), each drifted attribute and nested block is shown with
+its configured and cloud value, and can be accepted, rejected or edited before
+the manifest is generated. Items rejected with "ignore" are added to the ignore
+file (default: .graftabsorbignore), and drift listed there is never absorbed.
+Each line of the ignore file is an address pattern, optionally followed by an
+attribute path pattern, e.g. "azurerm_* tags.hidden-*". Patterns use * to match
+any text.
+
+By default, the cloud values are pinned in the manifest. With --mode ignore, the
+drifted attributes are added to lifecycle ignore_changes instead, on top of the
+ignore_changes of the module. --ignore-changes does the same for the attributes
+matching a pattern only, e.g. --ignore-changes tags.
+
+If the output file already exists, the drift is merged into it: new resources
+and attributes are added, changed attributes are updated, and everything else in
+the file, including comments and hand edits, is kept. Use --overwrite to replace
+the file instead.
+
+--include and --exclude limit absorb to resources whose address (with or without
+the instance key) or type matches a pattern, e.g. --exclude 'module.legacy.*'.
+
 Workflow:
   1. terraform plan -out=tfplan
-  2. terraform show -json tfplan > plan.json
-  3. graft absorb plan.json
-  4. graft build
-  5. terraform plan (should show zero changes)`,
-		Args: cobra.ExactArgs(1),
+  2. graft absorb tfplan (or graft absorb --run-plan)
+  3. graft build
+  4. terraform plan (should show zero changes)`,
+		Args: cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			planFile := args[0]
+			if runPlan == (len(args) == 1) {
+				return fmt.Errorf("specify either a plan file or --run-plan")
+			}
 
-			// Validate the plan file exists
-			if _, err := os.Stat(planFile); os.IsNotExist(err) {
-				return fmt.Errorf("plan file not found: %s", planFile)
+			// Infer JSON output from the file extension unless --format is set
+			if !cmd.Flags().Changed("format") && strings.HasSuffix(outputFile, ".json") {
+				format = "json"
+			}
+			if format != "hcl" && format != "json" {
+				return fmt.Errorf("invalid format %q: must be hcl or json", format)
+			}
+			if mode != "pin" && mode != "ignore" {
+				return fmt.Errorf("invalid mode %q: must be pin or ignore", mode)
+			}
+
+			var planFile string
+			if runPlan {
+				log.Section("Running terraform plan -refresh-only...")
+				binaryPlan, err := runRefreshPlan()
+				if err != nil {
+					return err
+				}
+				defer func() { _ = os.Remove(binaryPlan) }()
+				planFile = binaryPlan
+			} else {
+				planFile = args[0]
+				// Validate the plan file exists
+				if _, err := os.Stat(planFile); os.IsNotExist(err) {
+					return fmt.Errorf("plan file not found: %s", planFile)
+				}
+			}
+
+			binary, err := isBinaryPlan(planFile)
+			if err != nil {
+				return fmt.Errorf("failed to read plan file: %w", err)
+			}
+			if binary {
+				log.Section("Converting binary plan to JSON...")
+				jsonPlan, err := showPlan(planFile)
+				if err != nil {
+					return err
+				}
+				defer func() { _ = os.Remove(jsonPlan) }()
+				planFile = jsonPlan
 			}
 
 			// Validate the providers schema file exists if specified
@@ -61,8 +141,14 @@ Workflow:
 				}
 			}
 
+			ignoreRules, err := absorb.LoadIgnoreFile(ignoreFile)
+			if err != nil {
+				return err
+			}
+			filter := &absorb.Filter{Include: include, Exclude: exclude, Rules: ignoreRules}
+
 			log.Section("Reading Terraform plan JSON...")
-			driftChanges, err := absorb.ParsePlanFile(planFile)
+			driftChanges, err := absorb.ParsePlanFile(planFile, filter)
 			if err != nil {
 				return fmt.Errorf("failed to parse plan file: %w", err)
 			}
@@ -76,6 +162,35 @@ Workflow:
 			for _, change := range driftChanges {
 				log.Item(change.Address)
 			}
+			for _, change := range driftChanges {
+				if len(change.ReplaceAttrs) > 0 {
+					log.Warn(fmt.Sprintf("%s: drift in %s forces replacement, review the absorbed values before applying", change.Address, strings.Join(change.ReplaceAttrs, ", ")))
+				}
+			}
+
+			if interactive {
+				log.Section("Reviewing drift...")
+				var ignored []absorb.IgnoreRule
+				driftChanges, ignored, err = absorb.Review(driftChanges, os.Stdin, os.Stdout)
+				if err != nil {
+					return err
+				}
+				if err := absorb.AppendIgnoreFile(ignoreFile, ignored); err != nil {
+					return err
+				}
+				if len(ignored) > 0 {
+					log.Item(fmt.Sprintf("Added %d item(s) to %s", len(ignored), ignoreFile))
+				}
+				if len(driftChanges) == 0 {
+					log.Hint("No drift accepted. Nothing to absorb.")
+					return nil
+				}
+			}
+
+			if mode == "ignore" {
+				ignoreChanges = []string{"*"}
+			}
+			driftChanges = absorb.IgnoreChanges(driftChanges, ignoreChanges)
 
 			log.Section("Generating manifest...")
 			manifestContent, err := absorb.GenerateManifest(driftChanges, providersSchemaFile)
@@ -91,13 +206,37 @@ Workflow:
 
 			filename := outputFile
 			if filename == "" {
-				filename = "absorb.graft.hcl"
+				filename = "absorb.graft." + format
 			}
 			savePath := filename
 			if !filepath.IsAbs(savePath) {
 				savePath = filepath.Join(cwd, savePath)
 			}
 
+			existing, err := os.ReadFile(savePath)
+			switch {
+			case err == nil && !overwrite:
+				log.Section(fmt.Sprintf("Merging into existing %s...", filename))
+				var changes []manifest.MergeChange
+				manifestContent, changes, err = manifest.MergeInto(existing, savePath, manifestContent)
+				if err != nil {
+					return fmt.Errorf("failed to merge manifest: %w", err)
+				}
+				if len(changes) == 0 {
+					log.Hint("The manifest already contains this drift.")
+				}
+				for _, change := range changes {
+					log.Item(formatMergeChange(change))
+				}
+			case err != nil && !os.IsNotExist(err):
+				return fmt.Errorf("failed to read manifest: %w", err)
+			case format == "json":
+				manifestContent, err = manifest.ConvertToJSON(manifestContent, "absorb.graft.hcl")
+				if err != nil {
+					return fmt.Errorf("failed to convert manifest to JSON: %w", err)
+				}
+			}
+
 			if err := os.WriteFile(savePath, manifestContent, 0644); err != nil {
 				return fmt.Errorf("failed to write manifest: %w", err)
 			}
@@ -109,33 +248,107 @@ Workflow:
 	}
 
 	cmd.Flags().StringVarP(&outputFile, "output", "o", "", "Output file path (default: absorb.graft.hcl)")
+	cmd.Flags().BoolVar(&runPlan, "run-plan", false, "Run 'terraform plan -refresh-only' instead of reading a plan file")
 	cmd.Flags().StringVarP(&providersSchemaFile, "providers-schema", "p", "", "Path to providers schema JSON file (from 'terraform providers schema -json')")
+	cmd.Flags().BoolVarP(&interactive, "interactive", "i", false, "Review each drifted attribute and nested block before absorbing it")
+	cmd.Flags().StringVar(&ignoreFile, "ignore-file", absorb.DefaultIgnoreFile, "File listing drift that is never absorbed")
+	cmd.Flags().StringArrayVar(&include, "include", nil, "Only absorb resources matching this address or type pattern (repeatable)")
+	cmd.Flags().StringArrayVar(&exclude, "exclude", nil, "Don't absorb resources matching this address or type pattern (repeatable)")
+	cmd.Flags().StringVar(&mode, "mode", "pin", "How to absorb drift: pin (set the cloud values) or ignore (add the attributes to lifecycle ignore_changes)")
+	cmd.Flags().StringArrayVar(&ignoreChanges, "ignore-changes", nil, "Absorb drifted attributes matching this pattern as lifecycle ignore_changes (repeatable)")
+	cmd.Flags().BoolVar(&overwrite, "overwrite", false, "Replace the output file instead of merging the drift into it")
+	cmd.Flags().StringVar(&format, "format", "hcl", "Manifest syntax to write: hcl or json (inferred from --output when it ends in .json)")
 
 	return cmd
 }
 
+// formatMergeChange describes what merging the drift changed in one block,
+// e.g. "azurerm_subnet.web: added service_endpoints; updated tags"
+func formatMergeChange(change manifest.MergeChange) string {
+	var parts []string
+	if len(change.Added) > 0 {
+		parts = append(parts, "added "+strings.Join(change.Added, ", "))
+	}
+	if len(change.Updated) > 0 {
+		parts = append(parts, "updated "+strings.Join(change.Updated, ", "))
+	}
+	return change.Address + ": " + strings.Join(parts, "; ")
+}
+
 // fetchProvidersSchema runs 'terraform providers schema -json' and writes the
 // output to a temporary file. Returns the path to the temp file or an error.
 func fetchProvidersSchema() (string, error) {
-	cmd := exec.Command("terraform", "providers", "schema", "-json")
-	output, err := cmd.Output()
+	output, err := runTerraform("providers", "schema", "-json")
+	if err != nil {
+		return "", err
+	}
+	return writeTempFile("graft-providers-schema-*.json", output)
+}
+
+// runRefreshPlan runs 'terraform plan -refresh-only' and saves the plan to a
+// temporary file. Returns the path to the binary plan file or an error.
+func runRefreshPlan() (string, error) {
+	planFile, err := writeTempFile("graft-refresh-*.tfplan", nil)
+	if err != nil {
+		return "", err
+	}
+	if _, err := runTerraform("plan", "-refresh-only", "-input=false", "-out="+planFile); err != nil {
+		_ = os.Remove(planFile)
+		return "", err
+	}
+	return planFile, nil
+}
+
+// showPlan runs 'terraform show -json' on a binary plan file and writes the
+// output to a temporary file. Returns the path to the temp file or an error.
+func showPlan(planFile string) (string, error) {
+	output, err := runTerraform("show", "-json", planFile)
+	if err != nil {
+		return "", err
+	}
+	return writeTempFile("graft-plan-*.json", output)
+}
+
+// isBinaryPlan reports whether path is a plan saved with 'terraform plan -out',
+// which is a zip archive, rather than the output of 'terraform show -json'
+func isBinaryPlan(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = f.Close() }()
+
+	header := make([]byte, 4)
+	n, _ := io.ReadFull(f, header)
+	return bytes.Equal(header[:n], []byte("PK\x03\x04")), nil
+}
+
+// runTerraform runs terraform with args in the current directory and returns
+// its standard output
+func runTerraform(args ...string) ([]byte, error) {
+	// e.g. "terraform plan -refresh-only failed"
+	name := "terraform " + strings.Join(args[:min(2, len(args))], " ")
+	output, err := exec.Command("terraform", args...).Output()
 	if err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
-			return "", fmt.Errorf("terraform providers schema failed: %s", string(exitErr.Stderr))
+			return nil, fmt.Errorf("%s failed: %s", name, string(exitErr.Stderr))
 		}
-		return "", fmt.Errorf("terraform providers schema failed: %w", err)
+		return nil, fmt.Errorf("%s failed: %w", name, err)
 	}
+	return output, nil
+}
 
-	tmpFile, err := os.CreateTemp("", "graft-providers-schema-*.json")
+func writeTempFile(pattern string, data []byte) (string, error) {
+	tmpFile, err := os.CreateTemp("", pattern)
 	if err != nil {
 		return "", fmt.Errorf("failed to create temp file: %w", err)
 	}
 	tmpPath := tmpFile.Name()
 	_ = tmpFile.Close()
 
-	if err := os.WriteFile(tmpPath, output, 0644); err != nil {
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
 		_ = os.Remove(tmpPath)
-		return "", fmt.Errorf("failed to write schema: %w", err)
+		return "", fmt.Errorf("failed to write %s: %w", tmpPath, err)
 	}
 
 	return tmpPath, nil
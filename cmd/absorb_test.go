@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+const fakeTerraform = `#!/bin/sh
+echo "$@" >> "$FAKE_TERRAFORM_LOG"
+case "$1" in
+  providers)
+    echo "no configuration" >&2
+    exit 1
+    ;;
+  plan)
+    for arg in "$@"; do
+      case "$arg" in
+        -out=*) printf 'PK\003\004' > "${arg#-out=}" ;;
+      esac
+    done
+    ;;
+  show)
+    if [ ! -f "$FAKE_PLAN_JSON" ]; then
+      echo "plan file is corrupt" >&2
+      exit 1
+    fi
+    cat "$FAKE_PLAN_JSON"
+    ;;
+esac
+`
+
+const fakePlanJSON = `{
+  "format_version": "1.0",
+  "resource_drift": [
+    {
+      "address": "azurerm_resource_group.main",
+      "mode": "managed",
+      "type": "azurerm_resource_group",
+      "name": "main",
+      "provider_name": "registry.terraform.io/hashicorp/azurerm",
+      "change": {
+        "actions": ["update"],
+        "before": { "location": "eastus" },
+        "after": { "location": "westus" }
+      }
+    }
+  ]
+}`
+
+func TestAbsorbCmd_Terraform(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake terraform binary is a shell script")
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		files    map[string]string
+		planJSON bool
+		commands []string // expected terraform invocations, by prefix
+		err      string
+	}{
+		{
+			name:     "binary plan file",
+			args:     []string{"tfplan"},
+			files:    map[string]string{"tfplan": "PK\x03\x04"},
+			planJSON: true,
+			commands: []string{"show -json tfplan", "providers schema -json"},
+		},
+		{
+			name:     "plan JSON file",
+			args:     []string{"plan.json"},
+			files:    map[string]string{"plan.json": fakePlanJSON},
+			commands: []string{"providers schema -json"},
+		},
+		{
+			name:     "run plan",
+			args:     []string{"--run-plan"},
+			planJSON: true,
+			commands: []string{"plan -refresh-only -input=false -out=", "show -json ", "providers schema -json"},
+		},
+		{
+			name:     "terraform show fails",
+			args:     []string{"tfplan"},
+			files:    map[string]string{"tfplan": "PK\x03\x04"},
+			commands: []string{"show -json tfplan"},
+			err:      "terraform show -json failed: plan file is corrupt",
+		},
+		{
+			name: "plan file and run plan",
+			args: []string{"--run-plan", "plan.json"},
+			err:  "specify either a plan file or --run-plan",
+		},
+		{
+			name: "no plan file",
+			err:  "specify either a plan file or --run-plan",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			binDir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(binDir, "terraform"), []byte(fakeTerraform), 0755); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+			logFile := filepath.Join(binDir, "terraform.log")
+			t.Setenv("FAKE_TERRAFORM_LOG", logFile)
+			planJSON := filepath.Join(binDir, "plan.json")
+			if tt.planJSON {
+				if err := os.WriteFile(planJSON, []byte(fakePlanJSON), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			t.Setenv("FAKE_PLAN_JSON", planJSON)
+
+			workDir := t.TempDir()
+			t.Chdir(workDir)
+			for name, content := range tt.files {
+				if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cmd := NewAbsorbCmd()
+			cmd.SetArgs(tt.args)
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+			err := cmd.Execute()
+
+			log, _ := os.ReadFile(logFile)
+			var commands []string
+			if len(log) > 0 {
+				commands = strings.Split(strings.TrimSpace(string(log)), "\n")
+			}
+			if len(commands) != len(tt.commands) {
+				t.Fatalf("expected terraform to run %q, got %q", tt.commands, commands)
+			}
+			for i, prefix := range tt.commands {
+				if !strings.HasPrefix(commands[i], prefix) {
+					t.Errorf("expected terraform command %d to start with %q, got %q", i, prefix, commands[i])
+				}
+			}
+
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("absorb failed: %v", err)
+			}
+
+			manifest, err := os.ReadFile(filepath.Join(workDir, "absorb.graft.hcl"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(manifest), `resource "azurerm_resource_group" "main"`) {
+				t.Errorf("expected the drift to be absorbed, got:\n%s", manifest)
+			}
+		})
+	}
+}
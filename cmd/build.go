@@ -3,6 +3,8 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/ms-henglu/graft/internal/log"
 	"github.com/ms-henglu/graft/internal/manifest"
@@ -12,7 +14,9 @@ import (
 )
 
 func NewBuildCmd() *cobra.Command {
-	var manifestFile string
+	var manifestFiles []string
+	var manifestDirs []string
+	var mergeConflicts string
 
 	cmd := &cobra.Command{
 		Use:   "build",
@@ -23,38 +27,55 @@ func NewBuildCmd() *cobra.Command {
 				return err
 			}
 
-			var m *manifest.Manifest
+			policy, err := manifest.ParseConflictPolicy(mergeConflicts)
+			if err != nil {
+				return err
+			}
 
-			// Check if -m flag was explicitly set
-			if cmd.Flags().Changed("manifest") {
-				// Use the specified manifest file
-				log.Section("Reading " + manifestFile + "...")
-				m, err = manifest.Parse(manifestFile)
-				if err != nil {
-					return err
+			var manifests []string
+
+			switch {
+			case len(manifestFiles) > 0:
+				// Use the specified manifest files, in the order given
+				manifests = manifestFiles
+			case len(manifestDirs) > 0:
+				// Recursively discover *.graft.hcl files in the given directories
+				for _, dir := range manifestDirs {
+					found, err := manifest.DiscoverManifestsRecursive(dir)
+					if err != nil {
+						return err
+					}
+					manifests = append(manifests, found...)
 				}
-			} else {
-				// Discover all *.graft.hcl files in current directory
-				manifests, err := manifest.DiscoverManifests(cwd)
+			default:
+				// Discover all *.graft.hcl files in current directory and graft.d
+				manifests, err = manifest.DiscoverManifests(cwd)
 				if err != nil {
 					return err
 				}
+			}
 
-				if len(manifests) == 0 {
-					// no graft manifests found, recommend the scaffold command
-					log.Hint("No graft manifests found in the current directory.\nYou can create one by running 'graft scaffold' command.")
-					return nil
-				}
+			if len(manifests) == 0 {
+				// no graft manifests found, recommend the scaffold command
+				log.Hint("No graft manifests found in the current directory.\nYou can create one by running 'graft scaffold' command.")
+				return nil
+			}
 
-				// Parse and merge all discovered graft manifests
+			// Parse and merge all graft manifests
+			if len(manifests) == 1 {
+				log.Section("Reading " + displayPath(cwd, manifests[0]) + "...")
+			} else {
 				log.Section(fmt.Sprintf("Reading %d graft manifests...", len(manifests)))
-
-				m, err = manifest.ParseMultiple(manifests)
-				if err != nil {
-					return err
+				for _, path := range manifests {
+					log.Item(displayPath(cwd, path))
 				}
 			}
 
+			m, err := manifest.ParseMultiple(manifests, policy)
+			if err != nil {
+				return err
+			}
+
 			log.Section("Vendoring modules...")
 			vendorMap, err := vendors.VendorModules(cwd, m)
 			if err != nil {
@@ -79,6 +100,16 @@ func NewBuildCmd() *cobra.Command {
 		},
 	}
 
-	cmd.Flags().StringVarP(&manifestFile, "manifest", "m", "manifest.graft.hcl", "Path to graft manifest (if not specified, all *.graft.hcl files in current directory will be used)")
+	cmd.Flags().StringArrayVarP(&manifestFiles, "manifest", "m", nil, "Path to graft manifest, can be repeated (if not specified, all *.graft.hcl and *.graft.json files in the current directory and graft.d will be used)")
+	cmd.Flags().StringArrayVar(&manifestDirs, "manifest-dir", nil, "Directory to search recursively for *.graft.hcl and *.graft.json files, can be repeated")
+	cmd.Flags().StringVar(&mergeConflicts, "merge-conflicts", string(manifest.ConflictPolicyWarn), "How to handle attributes set to different values by multiple manifests: error, warn or override")
 	return cmd
 }
+
+// displayPath returns path relative to cwd when possible, for shorter log output.
+func displayPath(cwd, path string) string {
+	if rel, err := filepath.Rel(cwd, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ms-henglu/graft/internal/log"
+	"github.com/ms-henglu/graft/internal/manifest"
+	"github.com/ms-henglu/graft/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+func NewFmtCmd() *cobra.Command {
+	var check bool
+	var diff bool
+	var recursive bool
+
+	cmd := &cobra.Command{
+		Use:   "fmt [PATH...]",
+		Short: "Rewrites graft manifests to a canonical format",
+		Long: `Rewrites *.graft.hcl files to a canonical format and style.
+
+Besides applying the standard HCL formatting, fmt sorts the blocks inside
+override blocks by type and labels, places module blocks after override blocks
+in name order, and moves _graft blocks to the end of their parent block.
+
+PATH can be a manifest file or a directory (default: current directory).`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				args = []string{"."}
+			}
+
+			files, err := collectFmtTargets(args, recursive)
+			if err != nil {
+				return err
+			}
+
+			var unformatted []string
+			for _, file := range files {
+				src, err := os.ReadFile(file)
+				if err != nil {
+					return fmt.Errorf("failed to read %s: %w", file, err)
+				}
+
+				formatted, err := manifest.Format(src, file)
+				if err != nil {
+					return fmt.Errorf("failed to format %s: %w", file, err)
+				}
+
+				if string(formatted) == string(src) {
+					continue
+				}
+				unformatted = append(unformatted, file)
+
+				if diff {
+					name := diffPath(file)
+					fmt.Print(utils.UnifiedDiff("old/"+name, "new/"+name, string(src), string(formatted)))
+				}
+				if check {
+					continue
+				}
+				if err := os.WriteFile(file, formatted, 0644); err != nil {
+					return fmt.Errorf("failed to write %s: %w", file, err)
+				}
+			}
+
+			if len(unformatted) == 0 {
+				return nil
+			}
+
+			if check {
+				log.Warn("The following graft manifests are not formatted:")
+				for _, file := range unformatted {
+					log.Item(file)
+				}
+				return fmt.Errorf("%d graft manifest(s) are not formatted; run 'graft fmt' to fix", len(unformatted))
+			}
+
+			log.Section(fmt.Sprintf("Formatted %d graft manifest(s)...", len(unformatted)))
+			for _, file := range unformatted {
+				log.Item(file)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&check, "check", false, "Check whether manifests are formatted without modifying them; exits non-zero if any are not")
+	cmd.Flags().BoolVar(&diff, "diff", false, "Display diffs of formatting changes")
+	cmd.Flags().BoolVar(&recursive, "recursive", false, "Also process manifests in subdirectories")
+
+	return cmd
+}
+
+// collectFmtTargets expands the given paths into a sorted list of *.graft.hcl files.
+// Files given explicitly are always included; directories are searched for
+// *.graft.hcl files, recursively when requested.
+func collectFmtTargets(paths []string, recursive bool) ([]string, error) {
+	seen := make(map[string]bool)
+	var files []string
+	add := func(file string) {
+		if !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to access %s: %w", path, err)
+		}
+
+		if !info.IsDir() {
+			add(path)
+			continue
+		}
+
+		var found []string
+		if recursive {
+			found, err = manifest.DiscoverManifestsRecursive(path)
+		} else {
+			found, err = filepath.Glob(filepath.Join(path, "*.graft.hcl"))
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range found {
+			// JSON manifests are machine-generated and not formatted here
+			if strings.HasSuffix(file, ".graft.hcl") {
+				add(file)
+			}
+		}
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// diffPath returns the path of a file for the diff headers: relative to the
+// current directory when the file is below it, and without a leading slash
+// otherwise, so that "old/" and "new/" can be prepended
+func diffPath(file string) string {
+	if filepath.IsAbs(file) {
+		if cwd, err := os.Getwd(); err == nil {
+			if rel, err := filepath.Rel(cwd, file); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				file = rel
+			}
+		}
+	}
+	file = strings.TrimPrefix(file, filepath.VolumeName(file))
+	return strings.TrimLeft(filepath.ToSlash(file), "/")
+}
@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiffPath(t *testing.T) {
+	cwd := t.TempDir()
+	t.Chdir(cwd)
+	outside := filepath.Join(filepath.Dir(cwd), "other.graft.hcl")
+	outsideName := filepath.ToSlash(strings.TrimPrefix(outside, filepath.VolumeName(outside)))[1:]
+
+	testcases := []struct {
+		file     string
+		expected string
+	}{
+		{file: "main.graft.hcl", expected: "main.graft.hcl"},
+		{file: filepath.Join("graft.d", "a.graft.hcl"), expected: "graft.d/a.graft.hcl"},
+		{file: filepath.Join(cwd, "graft.d", "a.graft.hcl"), expected: "graft.d/a.graft.hcl"},
+		{file: outside, expected: outsideName},
+	}
+
+	for _, tc := range testcases {
+		if got := diffPath(tc.file); got != tc.expected {
+			t.Errorf("diffPath(%q) = %q, want %q", tc.file, got, tc.expected)
+		}
+	}
+}
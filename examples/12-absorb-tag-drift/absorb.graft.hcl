@@ -4,13 +4,12 @@
 override {
   # Absorb drift for: azurerm_resource_group.test
   resource "azurerm_resource_group" "test" {
-    tags = {
+    tags = merge(graft.source, {
       Creator     = "user@example.com"
       DateCreated = "2026-02-06T13:39:05Z"
       environment = "production"
       owner       = "drifttest"
-      project     = "graft"
-    }
+    })
   }
 
 }
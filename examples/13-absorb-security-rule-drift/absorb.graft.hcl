@@ -4,14 +4,13 @@
 override {
   # Absorb drift for: azurerm_resource_group.test
   resource "azurerm_resource_group" "test" {
-    tags = {
+    tags = merge(graft.source, {
       Creator     = "user@example.com"
       DateCreated = "2026-02-06T14:50:54Z"
       environment = "production"
       owner       = "drifttest"
-      project     = "graft"
       team        = "platform"
-    }
+    })
   }
 
 }
@@ -40,11 +39,10 @@ module "network" {
         private_endpoint_network_policies             = "Disabled"
         private_link_service_network_policies_enabled = true
       }
-      tags = {
+      tags = merge(graft.source, {
         environment = "production"
-        layer       = "network"
         owner       = "drifttest"
-      }
+      })
       _graft {
         remove = ["subnet"]
       }
@@ -77,12 +75,11 @@ module "network" {
           source_address_prefix      = "10.0.0.0/8"
           source_port_range          = "*"
         }
-        tags = {
+        tags = merge(graft.source, {
           compliance  = "required"
           environment = "production"
-          layer       = "security"
           owner       = "drifttest"
-        }
+        })
         _graft {
           remove = ["security_rule"]
         }
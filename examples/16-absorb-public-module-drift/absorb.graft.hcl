@@ -4,13 +4,12 @@
 override {
   # Absorb drift for: azurerm_resource_group.example
   resource "azurerm_resource_group" "example" {
-    tags = {
+    tags = merge(graft.source, {
       Creator     = "admin@contoso.com"
       DateCreated = "2026-01-15T09:22:31Z"
       environment = "production"
       owner       = "platform-team"
-      project     = "graft"
-    }
+    })
   }
 
 }
@@ -18,11 +17,10 @@ module "network" {
   override {
     # Absorb drift for: module.network.azurerm_virtual_network.vnet
     resource "azurerm_virtual_network" "vnet" {
-      tags = {
+      tags = merge(graft.source, {
         environment = "production"
-        managed_by  = "terraform"
         owner       = "platform-team"
-      }
+      })
     }
 
     # Absorb drift for: module.network.azurerm_subnet.subnet_for_each["web-subnet"]
@@ -4,14 +4,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 
 	tfjson "github.com/hashicorp/terraform-json"
 )
 
 // ParsePlanFile reads and parses a Terraform plan JSON file and returns the
-// changes found in the plan that need to be absorbed.
-func ParsePlanFile(planPath string) ([]DriftChange, error) {
+// changes found in the plan that need to be absorbed. Resources and attributes
+// skipped by filter are left out; a nil filter keeps everything.
+func ParsePlanFile(planPath string, filter *Filter) ([]DriftChange, error) {
 	data, err := os.ReadFile(planPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read plan file: %w", err)
@@ -23,46 +25,121 @@ func ParsePlanFile(planPath string) ([]DriftChange, error) {
 	}
 
 	result := make([]DriftChange, 0)
-
-	for _, rc := range plan.ResourceChanges {
-		if !rc.Change.Actions.Update() {
-			continue
-		}
-
-		modulePath := parseModulePath(rc.ModuleAddress)
-		if rc.Mode != tfjson.ManagedResourceMode {
-			continue
+	exprs := configExpressions(plan.Config)
+
+	// resource_drift lists the changes made outside of Terraform, found when
+	// refreshing the state, while resource_changes also contains the changes
+	// the configuration is about to make. Use the drift when Terraform reports
+	// it, so that pending config edits aren't absorbed. It's left out of the
+	// plan when there is no drift, so an empty list means nothing to absorb.
+	// Refresh-only plans only have drift.
+	if reportsResourceDrift(&plan) {
+		// Drift is always an update, resource_changes tells whether undoing it
+		// replaces the resource
+		replaced := replacedResources(plan.ResourceChanges)
+		for _, rc := range plan.ResourceDrift {
+			// In resource_drift:
+			//   before = prior state, what the config produced at the last apply
+			//   after  = current cloud state
+			if change, ok := newDriftChange(rc, rc.Change.After, rc.Change.Before, exprs, filter); ok {
+				change.ReplaceAttrs = forcedAttrs(change.ChangedAttrs, replaced[rc.Address])
+				result = append(result, change)
+			}
 		}
+		return result, nil
+	}
 
+	for _, rc := range plan.ResourceChanges {
 		// In resource_changes:
 		//   before = current cloud state (after drift is applied)
 		//   after  = desired config (what Terraform wants to apply)
 		// We want to absorb the "before" values (cloud reality) into the config,
 		// so that the plan becomes clean.
-		cloudState := ctyValueToMap(rc.Change.Before)
-		desiredConfig := ctyValueToMap(rc.Change.After)
-
-		// Find attributes that differ and capture the cloud state values
-		changedAttrs := findDriftedAttributes(desiredConfig, cloudState)
-		if len(changedAttrs) == 0 {
-			continue
+		if change, ok := newDriftChange(rc, rc.Change.Before, rc.Change.After, exprs, filter); ok {
+			result = append(result, change)
 		}
+	}
 
-		change := DriftChange{
-			Address:      rc.Address,
-			ModulePath:   modulePath,
-			ResourceType: rc.Type,
-			ResourceName: rc.Name,
-			ProviderName: rc.ProviderName,
-			Mode:         string(rc.Mode),
-			Index:        rc.Index,
-			ChangedAttrs: changedAttrs,
-			BeforeAttrs:  desiredConfig,
+	return result, nil
+}
+
+// reportsResourceDrift returns whether the plan comes from a Terraform version
+// that reports resource_drift, 0.15.4 and later. Plans without a Terraform
+// version are judged by their format version, which became 0.2 in 0.15.4.
+func reportsResourceDrift(plan *tfjson.Plan) bool {
+	if plan.TerraformVersion != "" {
+		return versionAtLeast(plan.TerraformVersion, 0, 15, 4)
+	}
+	return versionAtLeast(plan.FormatVersion, 0, 2)
+}
+
+// versionAtLeast returns whether a dotted version such as "1.5.0" or
+// "1.6.0-beta1" is at least the given version
+func versionAtLeast(version string, min ...int) bool {
+	version, _, _ = strings.Cut(version, "-")
+	parts := strings.Split(version, ".")
+	for i, m := range min {
+		n := 0
+		if i < len(parts) {
+			var err error
+			if n, err = strconv.Atoi(parts[i]); err != nil {
+				return false
+			}
+		}
+		if n != m {
+			return n > m
 		}
-		result = append(result, change)
 	}
+	return true
+}
 
-	return result, nil
+// newDriftChange returns the drift of an updated or replaced managed resource
+// between its cloud state and config. exprs are the config expressions of all
+// resources, from configExpressions. It returns false if there is no drift to
+// absorb.
+func newDriftChange(rc *tfjson.ResourceChange, cloud, config interface{}, exprs map[string]map[string]*tfjson.Expression, filter *Filter) (DriftChange, bool) {
+	if rc.Change == nil || !(rc.Change.Actions.Update() || rc.Change.Actions.Replace()) {
+		return DriftChange{}, false
+	}
+	if rc.Mode != tfjson.ManagedResourceMode {
+		return DriftChange{}, false
+	}
+	if filter.skipsResource(rc.Address, rc.Type) {
+		return DriftChange{}, false
+	}
+
+	cloudState := ctyValueToMap(cloud)
+	desiredConfig := ctyValueToMap(config)
+
+	// The planned values of a replacement leave out everything computed by the
+	// new resource, so only the paths that force the replacement are compared
+	var replaceAttrs []string
+	if rc.Change.Actions.Replace() {
+		replaceAttrs = replacePaths(rc.Change.ReplacePaths)
+		cloudState = selectKeys(cloudState, replaceAttrs)
+		desiredConfig = selectKeys(desiredConfig, replaceAttrs)
+	}
+
+	// Find attributes that differ and capture the cloud state values
+	changedAttrs := findDriftedAttributes(desiredConfig, cloudState, filter.ignoredAttributes(rc.Address, rc.Type))
+	if len(changedAttrs) == 0 {
+		return DriftChange{}, false
+	}
+
+	modulePath := parseModulePath(rc.ModuleAddress)
+	return DriftChange{
+		Address:      rc.Address,
+		ModulePath:   modulePath,
+		ResourceType: rc.Type,
+		ResourceName: rc.Name,
+		ProviderName: rc.ProviderName,
+		Mode:         string(rc.Mode),
+		Index:        rc.Index,
+		ChangedAttrs: changedAttrs,
+		BeforeAttrs:  desiredConfig,
+		References:   expressionReferences(exprs[configAddress(modulePath, rc.Type, rc.Name)], changedAttrs),
+		ReplaceAttrs: forcedAttrs(changedAttrs, replaceAttrs),
+	}, true
 }
 
 // ctyValueToMap converts a value (from tfjson) to map[string]interface{}.
@@ -104,8 +181,12 @@ func parseModulePath(moduleAddr string) []string {
 }
 
 // findDriftedAttributes compares before and after states and returns the 'after' values
-// for attributes that have drifted.
-func findDriftedAttributes(before, after map[string]interface{}) map[string]interface{} {
+// for attributes that have drifted. An attribute that is set before but null after
+// was removed remotely and is returned with a nil value; whether it can be nulled
+// in config is decided with the provider schema when the block is rendered.
+// Attribute paths for which ignored returns true are left out of both states
+// before comparing them, so drift only in ignored paths isn't reported.
+func findDriftedAttributes(before, after map[string]interface{}, ignored func(path string) bool) map[string]interface{} {
 	if after == nil {
 		return nil
 	}
@@ -113,19 +194,25 @@ func findDriftedAttributes(before, after map[string]interface{}) map[string]inte
 	changed := make(map[string]interface{})
 
 	for key, afterVal := range after {
-		// Known limitation: if an attribute was non-nil before and nil after,
-		// that drift (attribute removal) is silently skipped. A future
-		// improvement could emit an explicit null to represent the removal.
-		if afterVal == nil {
-			continue
-		}
-
 		// Skip the timeouts block (it's special)
 		if key == "timeouts" {
 			continue
 		}
 
 		beforeVal, exists := before[key]
+		if ignored != nil {
+			if ignored(key) {
+				continue
+			}
+			beforeVal = pruneIgnored(beforeVal, key, ignored)
+			afterVal = pruneIgnored(afterVal, key, ignored)
+		}
+		if afterVal == nil {
+			if exists && beforeVal != nil {
+				changed[key] = nil
+			}
+			continue
+		}
 
 		// Capture the 'after' value if it differs from 'before'
 		if !exists || !deepEqual(beforeVal, afterVal) {
@@ -3,8 +3,11 @@ package absorb
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+
+	tfjson "github.com/hashicorp/terraform-json"
 )
 
 func TestParseModulePath(t *testing.T) {
@@ -57,6 +60,7 @@ func TestFindDriftedAttributes(t *testing.T) {
 		name     string
 		before   map[string]interface{}
 		after    map[string]interface{}
+		ignored  []string
 		expected map[string]interface{}
 	}{
 		{
@@ -89,6 +93,60 @@ func TestFindDriftedAttributes(t *testing.T) {
 				"tags": map[string]interface{}{"Env": "Dev"},
 			},
 		},
+		{
+			name:     "attribute removed remotely is returned as nil",
+			before:   map[string]interface{}{"description": "managed by terraform", "name": "test"},
+			after:    map[string]interface{}{"description": nil, "name": "test"},
+			expected: map[string]interface{}{"description": nil},
+		},
+		{
+			name:     "attribute null on both sides",
+			before:   map[string]interface{}{"description": nil},
+			after:    map[string]interface{}{"description": nil},
+			expected: map[string]interface{}{},
+		},
+		{
+			name:     "ignored attribute",
+			before:   map[string]interface{}{"last_modified": "2026-01-01", "name": "a"},
+			after:    map[string]interface{}{"last_modified": "2026-02-01", "name": "b"},
+			ignored:  []string{"last_*"},
+			expected: map[string]interface{}{"name": "b"},
+		},
+		{
+			name: "drift only in ignored map keys",
+			before: map[string]interface{}{
+				"tags": map[string]interface{}{"env": "dev"},
+			},
+			after: map[string]interface{}{
+				"tags": map[string]interface{}{"env": "dev", "hidden-link": "x"},
+			},
+			ignored:  []string{"tags.hidden-*"},
+			expected: map[string]interface{}{},
+		},
+		{
+			name: "ignored map keys are left out of the drift",
+			before: map[string]interface{}{
+				"tags": map[string]interface{}{"env": "dev"},
+			},
+			after: map[string]interface{}{
+				"tags": map[string]interface{}{"env": "prod", "hidden-link": "x"},
+			},
+			ignored: []string{"tags.hidden-*"},
+			expected: map[string]interface{}{
+				"tags": map[string]interface{}{"env": "prod"},
+			},
+		},
+		{
+			name: "ignored attribute in nested blocks",
+			before: map[string]interface{}{
+				"blob_properties": []interface{}{map[string]interface{}{"versioning_enabled": true, "last_access_time": "a"}},
+			},
+			after: map[string]interface{}{
+				"blob_properties": []interface{}{map[string]interface{}{"versioning_enabled": true, "last_access_time": "b"}},
+			},
+			ignored:  []string{"blob_properties.last_access_time"},
+			expected: map[string]interface{}{},
+		},
 		{
 			name: "skips timeouts",
 			before: map[string]interface{}{
@@ -107,7 +165,15 @@ func TestFindDriftedAttributes(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := findDriftedAttributes(tt.before, tt.after)
+			var ignored func(path string) bool
+			if len(tt.ignored) > 0 {
+				filter := &Filter{}
+				for _, pattern := range tt.ignored {
+					filter.Rules = append(filter.Rules, IgnoreRule{Address: "*", Attribute: pattern})
+				}
+				ignored = filter.ignoredAttributes("azurerm_storage_account.sa", "azurerm_storage_account")
+			}
+			result := findDriftedAttributes(tt.before, tt.after, ignored)
 			if tt.expected == nil {
 				if result != nil {
 					t.Errorf("expected nil, got %v", result)
@@ -128,9 +194,11 @@ func TestFindDriftedAttributes(t *testing.T) {
 }
 
 func TestParsePlanFile(t *testing.T) {
+	// Terraform before 0.15.4 doesn't report resource_drift, so the drift is
+	// read from resource_changes
 	planJSON := `{
-		"format_version": "1.0",
-		"terraform_version": "1.5.0",
+		"format_version": "0.1",
+		"terraform_version": "0.15.3",
 		"resource_changes": [
 			{
 				"address": "module.network.azurerm_virtual_network.vnet",
@@ -167,7 +235,7 @@ func TestParsePlanFile(t *testing.T) {
 		t.Fatalf("Failed to write plan file: %v", err)
 	}
 
-	result, err := ParsePlanFile(planFile)
+	result, err := ParsePlanFile(planFile, nil)
 	if err != nil {
 		t.Fatalf("ParsePlanFile failed: %v", err)
 	}
@@ -203,11 +271,151 @@ func TestParsePlanFile(t *testing.T) {
 	}
 }
 
+func TestParsePlanFileResourceDrift(t *testing.T) {
+	// The rule's name was changed in config on purpose, its tags and the
+	// storage account's replication were changed outside of Terraform
+	planJSON := `{
+		"format_version": "1.2",
+		"terraform_version": "1.9.0",
+		"resource_drift": [
+			{
+				"address": "azurerm_resource_group.main",
+				"mode": "managed",
+				"type": "azurerm_resource_group",
+				"name": "main",
+				"provider_name": "registry.terraform.io/hashicorp/azurerm",
+				"change": {
+					"actions": ["update"],
+					"before": { "name": "rg", "tags": { "env": "dev" } },
+					"after": { "name": "rg", "tags": { "env": "dev", "CreatedOn": "2024-06-01" } }
+				}
+			},
+			{
+				"address": "module.storage.azurerm_storage_account.logs",
+				"module_address": "module.storage",
+				"mode": "managed",
+				"type": "azurerm_storage_account",
+				"name": "logs",
+				"provider_name": "registry.terraform.io/hashicorp/azurerm",
+				"change": {
+					"actions": ["delete"],
+					"before": { "name": "logs" },
+					"after": null
+				}
+			}
+		],
+		"resource_changes": [
+			{
+				"address": "azurerm_resource_group.main",
+				"mode": "managed",
+				"type": "azurerm_resource_group",
+				"name": "main",
+				"provider_name": "registry.terraform.io/hashicorp/azurerm",
+				"change": {
+					"actions": ["update"],
+					"before": { "name": "rg", "tags": { "env": "dev", "CreatedOn": "2024-06-01" } },
+					"after": { "name": "rg-renamed", "tags": { "env": "dev" } }
+				}
+			}
+		]
+	}`
+
+	tmpDir := t.TempDir()
+	planFile := filepath.Join(tmpDir, "plan.json")
+	if err := os.WriteFile(planFile, []byte(planJSON), 0644); err != nil {
+		t.Fatalf("Failed to write plan file: %v", err)
+	}
+
+	result, err := ParsePlanFile(planFile, nil)
+	if err != nil {
+		t.Fatalf("ParsePlanFile failed: %v", err)
+	}
+
+	// Only the drift is absorbed: not the pending rename, and not the
+	// resource deleted outside of Terraform
+	if len(result) != 1 {
+		t.Fatalf("expected 1 change, got %d", len(result))
+	}
+	change := result[0]
+	if change.Address != "azurerm_resource_group.main" {
+		t.Errorf("expected address 'azurerm_resource_group.main', got '%s'", change.Address)
+	}
+	expected := map[string]interface{}{
+		"tags": map[string]interface{}{"env": "dev", "CreatedOn": "2024-06-01"},
+	}
+	if !reflect.DeepEqual(change.ChangedAttrs, expected) {
+		t.Errorf("expected changed attributes %v, got %v", expected, change.ChangedAttrs)
+	}
+	if change.BeforeAttrs["name"] != "rg" {
+		t.Errorf("expected the prior state as config, got %v", change.BeforeAttrs)
+	}
+}
+
+func TestParsePlanFileNoResourceDrift(t *testing.T) {
+	// Terraform leaves resource_drift out when nothing drifted, so the pending
+	// rename in resource_changes isn't absorbed
+	planJSON := `{
+		"format_version": "1.2",
+		"terraform_version": "1.9.0",
+		"resource_changes": [
+			{
+				"address": "azurerm_resource_group.main",
+				"mode": "managed",
+				"type": "azurerm_resource_group",
+				"name": "main",
+				"provider_name": "registry.terraform.io/hashicorp/azurerm",
+				"change": {
+					"actions": ["update"],
+					"before": { "name": "rg", "tags": { "env": "dev" } },
+					"after": { "name": "rg-renamed", "tags": { "env": "dev" } }
+				}
+			}
+		]
+	}`
+
+	tmpDir := t.TempDir()
+	planFile := filepath.Join(tmpDir, "plan.json")
+	if err := os.WriteFile(planFile, []byte(planJSON), 0644); err != nil {
+		t.Fatalf("Failed to write plan file: %v", err)
+	}
+
+	result, err := ParsePlanFile(planFile, nil)
+	if err != nil {
+		t.Fatalf("ParsePlanFile failed: %v", err)
+	}
+	if len(result) != 0 {
+		t.Errorf("expected no changes, got %v", result)
+	}
+}
+
+func TestReportsResourceDrift(t *testing.T) {
+	tests := []struct {
+		terraformVersion string
+		formatVersion    string
+		expected         bool
+	}{
+		{terraformVersion: "1.9.0", formatVersion: "1.2", expected: true},
+		{terraformVersion: "0.15.4", formatVersion: "0.2", expected: true},
+		{terraformVersion: "1.10.0-beta1", formatVersion: "1.2", expected: true},
+		{terraformVersion: "0.15.3", formatVersion: "0.1", expected: false},
+		{terraformVersion: "0.14.11", formatVersion: "0.1", expected: false},
+		{formatVersion: "1.0", expected: true},
+		{formatVersion: "0.1", expected: false},
+	}
+
+	for _, tt := range tests {
+		plan := &tfjson.Plan{TerraformVersion: tt.terraformVersion, FormatVersion: tt.formatVersion}
+		if got := reportsResourceDrift(plan); got != tt.expected {
+			t.Errorf("reportsResourceDrift(%q, %q) = %v, expected %v", tt.terraformVersion, tt.formatVersion, got, tt.expected)
+		}
+	}
+}
+
 func TestParsePlanFileRetainsAllAttributes(t *testing.T) {
 	// ParsePlanFile should NOT filter computed attributes — that's the manifest's job
 	planJSON := `{
-		"format_version": "1.0",
-		"terraform_version": "1.5.0",
+		"format_version": "0.1",
+		"terraform_version": "0.15.3",
 		"resource_changes": [
 			{
 				"address": "module.network.azurerm_virtual_network.main",
@@ -243,7 +451,7 @@ func TestParsePlanFileRetainsAllAttributes(t *testing.T) {
 		t.Fatalf("Failed to write plan file: %v", err)
 	}
 
-	result, err := ParsePlanFile(planFile)
+	result, err := ParsePlanFile(planFile, nil)
 	if err != nil {
 		t.Fatalf("ParsePlanFile failed: %v", err)
 	}
@@ -278,7 +486,7 @@ func TestParsePlanFileRetainsAllAttributes(t *testing.T) {
 }
 
 func TestParsePlanFileNotFound(t *testing.T) {
-	_, err := ParsePlanFile("/nonexistent/plan.json")
+	_, err := ParsePlanFile("/nonexistent/plan.json", nil)
 	if err == nil {
 		t.Error("expected error for non-existent file")
 	}
@@ -294,7 +502,7 @@ func TestParsePlanFileInvalidJSON(t *testing.T) {
 		t.Fatalf("Failed to write plan file: %v", err)
 	}
 
-	_, err := ParsePlanFile(planFile)
+	_, err := ParsePlanFile(planFile, nil)
 	if err == nil {
 		t.Error("expected error for invalid JSON")
 	}
@@ -305,8 +513,8 @@ func TestParsePlanFileInvalidJSON(t *testing.T) {
 
 func TestParsePlanFileCountIndexed(t *testing.T) {
 	planJSON := `{
-		"format_version": "1.0",
-		"terraform_version": "1.5.0",
+		"format_version": "0.1",
+		"terraform_version": "0.15.3",
 		"resource_changes": [
 			{
 				"address": "azurerm_resource_group.main[0]",
@@ -343,7 +551,7 @@ func TestParsePlanFileCountIndexed(t *testing.T) {
 		t.Fatalf("Failed to write plan file: %v", err)
 	}
 
-	result, err := ParsePlanFile(planFile)
+	result, err := ParsePlanFile(planFile, nil)
 	if err != nil {
 		t.Fatalf("ParsePlanFile failed: %v", err)
 	}
@@ -380,8 +588,8 @@ func TestParsePlanFileCountIndexed(t *testing.T) {
 
 func TestParsePlanFileForEachIndexed(t *testing.T) {
 	planJSON := `{
-		"format_version": "1.0",
-		"terraform_version": "1.5.0",
+		"format_version": "0.1",
+		"terraform_version": "0.15.3",
 		"resource_changes": [
 			{
 				"address": "azurerm_resource_group.main[\"web\"]",
@@ -418,7 +626,7 @@ func TestParsePlanFileForEachIndexed(t *testing.T) {
 		t.Fatalf("Failed to write plan file: %v", err)
 	}
 
-	result, err := ParsePlanFile(planFile)
+	result, err := ParsePlanFile(planFile, nil)
 	if err != nil {
 		t.Fatalf("ParsePlanFile failed: %v", err)
 	}
@@ -3,6 +3,7 @@ package absorb
 import (
 	"fmt"
 	"sort"
+	"strings"
 
 	"github.com/hashicorp/hcl/v2/hclwrite"
 	tfjson "github.com/hashicorp/terraform-json"
@@ -22,6 +23,9 @@ type DriftChange struct {
 	ChangedAttrs    map[string]interface{}
 	BeforeAttrs     map[string]interface{}
 	FullBlockValues map[string]interface{} // full (pre-deepDiff) block values for dynamic blocks
+	IgnoreAttrs     []string               // drifted keys absorbed as lifecycle ignore_changes
+	References      map[string][]string    // what the config expressions of drifted keys refer to
+	ReplaceAttrs    []string               // drifted keys that force the resource to be replaced
 }
 
 // IsIndexed returns true if the resource uses count or for_each.
@@ -69,29 +73,51 @@ func (change DriftChange) resourceKey() string {
 }
 
 func (change DriftChange) ToBlock(schema *tfjson.SchemaBlock) *hclwrite.Block {
+	change, ignorePaths := change.withoutIgnoredAttrs(schema)
+
+	// Attributes and blocks removed remotely are only absorbed with a schema,
+	// which tells whether they are optional
+	nullAttrs, removedBlocks := findRemovals(change.BeforeAttrs, change.ChangedAttrs, schema, "")
+
 	// Filter computed attributes when schema is available
-	result := filterComputedAttrs(change.ChangedAttrs, schema, "")
+	result := filterComputedAttrs(withoutRemovedAttrs(change.ChangedAttrs), schema, "")
 	attrs, _ := result.(map[string]interface{})
-	if len(attrs) == 0 {
-		return nil
-	}
 
 	// Narrow to minimal diff using before state and schema
-	if change.BeforeAttrs != nil && schema != nil {
+	if len(attrs) > 0 && change.BeforeAttrs != nil && schema != nil {
 		attrs = deepDiffBlock(change.BeforeAttrs, attrs, schema)
-		if len(attrs) == 0 {
-			return nil
-		}
+	}
+	if len(attrs) == 0 && len(nullAttrs) == 0 && len(removedBlocks) == 0 && len(ignorePaths) == 0 {
+		return nil
 	}
 
 	resBlock := hclwrite.NewBlock("resource", []string{change.ResourceType, change.ResourceName})
 	resBody := resBlock.Body()
 
 	// Collect block-type attributes that need _graft removal
-	var removals []string
+	removals := removedBlocks
+
+	// Top-level nulls are sorted in with the other attributes
+	keys := make(map[string]bool)
+	for key := range attrs {
+		keys[key] = true
+	}
+	var nestedNulls []string
+	for _, path := range nullAttrs {
+		if strings.Contains(path, ".") {
+			nestedNulls = append(nestedNulls, path)
+		} else {
+			keys[path] = true
+		}
+	}
 
-	for _, key := range utils.SortedKeys(attrs) {
-		value := attrs[key]
+	for _, key := range utils.SortedKeys(keys) {
+		value, ok := attrs[key]
+		if !ok {
+			appendReferencesComment(resBody, key, change.References[key])
+			setNullAttribute(resBody, key)
+			continue
+		}
 		if shouldRenderAsBlock(schema, key) {
 			blocks := toBlocks(key, value, schema)
 			for _, b := range blocks {
@@ -104,12 +130,21 @@ func (change DriftChange) ToBlock(schema *tfjson.SchemaBlock) *hclwrite.Block {
 				nestedRemovals := collectNestedRemovals(key, blocks[0])
 				removals = append(removals, nestedRemovals...)
 			}
+		} else if tokens, ok := change.mapDiffTokens(schema, key, value); ok {
+			resBody.SetAttributeRaw(key, tokens)
 		} else {
+			appendReferencesComment(resBody, key, change.References[key])
 			ctyVal := utils.ToCtyValue(value)
 			resBody.SetAttributeValue(key, ctyVal)
 		}
 	}
 
+	for _, path := range nestedNulls {
+		setNullAttribute(resBody, path)
+	}
+
+	appendIgnoreChanges(resBody, ignorePaths)
+
 	if len(removals) > 0 {
 		sort.Strings(removals)
 		graftBlock := resBody.AppendNewBlock("_graft", nil)
@@ -124,6 +159,20 @@ func (change DriftChange) ToBlock(schema *tfjson.SchemaBlock) *hclwrite.Block {
 	return resBlock
 }
 
+// mapDiffTokens returns merge(graft.source, {...}) for a map attribute whose
+// drift only adds or changes keys, e.g. a tag added by a policy, so that the
+// other keys stay as the config sets them. Without a schema an object value
+// may be an object attribute, so it's left to the full literal.
+func (change DriftChange) mapDiffTokens(schema *tfjson.SchemaBlock, key string, value interface{}) (hclwrite.Tokens, bool) {
+	if schema == nil {
+		return nil, false
+	}
+	if attr := schema.Attributes[key]; attr == nil || !attr.AttributeType.IsMapType() {
+		return nil, false
+	}
+	return mapMergeTokens(change.BeforeAttrs[key], value)
+}
+
 // collectNestedRemovals inspects the child blocks of a single HCL block to find
 // block types that appear more than once, returning their dotted paths (e.g.
 // "backend_http_settings.connection_draining"). It recurses into block types
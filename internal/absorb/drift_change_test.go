@@ -954,6 +954,81 @@ func TestToBlockWithBeforeAttrs(t *testing.T) {
     disk_size_gb = 30
   }
 }
+`,
+		},
+		{
+			name: "map with added and changed keys merges into graft.source",
+			change: DriftChange{
+				ResourceType: "azurerm_resource_group",
+				ResourceName: "main",
+				BeforeAttrs: map[string]interface{}{
+					"tags": map[string]interface{}{"env": "dev", "owner": "team"},
+				},
+				ChangedAttrs: map[string]interface{}{
+					"tags": map[string]interface{}{"env": "dev", "owner": "ops", "Creator": "user@example.com"},
+				},
+			},
+			schema: &tfjson.SchemaBlock{
+				Attributes: map[string]*tfjson.SchemaAttribute{
+					"tags": {Optional: true, AttributeType: cty.Map(cty.String)},
+				},
+			},
+			expected: `resource "azurerm_resource_group" "main" {
+  tags = merge(graft.source, {
+    Creator = "user@example.com"
+    owner   = "ops"
+  })
+}
+`,
+		},
+		{
+			name: "map with removed keys is emitted in full",
+			change: DriftChange{
+				ResourceType: "azurerm_resource_group",
+				ResourceName: "main",
+				BeforeAttrs: map[string]interface{}{
+					"tags": map[string]interface{}{"env": "dev", "owner": "team"},
+				},
+				ChangedAttrs: map[string]interface{}{
+					"tags": map[string]interface{}{"env": "dev", "Creator": "user@example.com"},
+				},
+			},
+			schema: &tfjson.SchemaBlock{
+				Attributes: map[string]*tfjson.SchemaAttribute{
+					"tags": {Optional: true, AttributeType: cty.Map(cty.String)},
+				},
+			},
+			expected: `resource "azurerm_resource_group" "main" {
+  tags = {
+    Creator = "user@example.com"
+    env     = "dev"
+  }
+}
+`,
+		},
+		{
+			name: "object attribute isn't merged",
+			change: DriftChange{
+				ResourceType: "azurerm_example",
+				ResourceName: "main",
+				BeforeAttrs: map[string]interface{}{
+					"settings": map[string]interface{}{"a": "1"},
+				},
+				ChangedAttrs: map[string]interface{}{
+					"settings": map[string]interface{}{"a": "1", "b": "2"},
+				},
+			},
+			schema: &tfjson.SchemaBlock{
+				Attributes: map[string]*tfjson.SchemaAttribute{
+					"settings": {Optional: true, AttributeType: cty.Object(map[string]cty.Type{"a": cty.String, "b": cty.String})},
+				},
+			},
+			expected: `resource "azurerm_example" "main" {
+  settings = {
+    a = "1"
+    b = "2"
+  }
+}
 `,
 		},
 	}
@@ -0,0 +1,137 @@
+package absorb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/ms-henglu/graft/internal/utils"
+)
+
+// configExpressions indexes the attribute expressions of the managed resources
+// in the plan's configuration section by resource address without instance
+// keys, e.g. "module.network.azurerm_subnet.web".
+func configExpressions(config *tfjson.Config) map[string]map[string]*tfjson.Expression {
+	result := make(map[string]map[string]*tfjson.Expression)
+	if config == nil {
+		return result
+	}
+
+	var visit func(module *tfjson.ConfigModule, prefix string)
+	visit = func(module *tfjson.ConfigModule, prefix string) {
+		if module == nil {
+			return
+		}
+		for _, resource := range module.Resources {
+			if resource.Mode == tfjson.ManagedResourceMode {
+				result[prefix+resource.Type+"."+resource.Name] = resource.Expressions
+			}
+		}
+		for name, call := range module.ModuleCalls {
+			if call != nil {
+				visit(call.Module, prefix+"module."+name+".")
+			}
+		}
+	}
+	visit(config.RootModule, "")
+	return result
+}
+
+// configAddress returns the address of a resource in configExpressions
+func configAddress(modulePath []string, resourceType, resourceName string) string {
+	var sb strings.Builder
+	for _, name := range modulePath {
+		sb.WriteString("module." + name + ".")
+	}
+	sb.WriteString(resourceType + "." + resourceName)
+	return sb.String()
+}
+
+// expressionReferences returns what the config expressions of the drifted
+// attributes refer to, for the attributes that aren't constants. References to
+// an object are left out when a reference to one of its attributes is listed,
+// e.g. "azurerm_resource_group.main" next to "azurerm_resource_group.main.name".
+func expressionReferences(exprs map[string]*tfjson.Expression, attrs map[string]interface{}) map[string][]string {
+	result := make(map[string][]string)
+	for key := range attrs {
+		expr := exprs[key]
+		if expr == nil || expr.ExpressionData == nil || len(expr.References) == 0 {
+			continue
+		}
+
+		var refs []string
+		for _, ref := range expr.References {
+			covered := false
+			for _, other := range expr.References {
+				if strings.HasPrefix(other, ref+".") || strings.HasPrefix(other, ref+"[") {
+					covered = true
+					break
+				}
+			}
+			if !covered {
+				refs = append(refs, ref)
+			}
+		}
+		sort.Strings(refs)
+		result[key] = refs
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
+
+// appendReferencesComment flags an attribute whose config is an expression,
+// since the absorbed literal replaces it
+func appendReferencesComment(body *hclwrite.Body, key string, refs []string) {
+	if len(refs) == 0 {
+		return
+	}
+	body.AppendUnstructuredTokens(hclwrite.Tokens{
+		{Type: hclsyntax.TokenComment, Bytes: []byte(fmt.Sprintf("# In config, %s refers to %s; the cloud value below replaces it\n", key, strings.Join(refs, ", ")))},
+	})
+}
+
+// mapMergeTokens returns merge(graft.source, {...}) with the keys of a map
+// that were added or changed remotely. It returns false if config or cloud
+// isn't a map, or if keys were removed, which merge() can't express.
+func mapMergeTokens(config, cloud interface{}) (hclwrite.Tokens, bool) {
+	configMap, ok := config.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	cloudMap, ok := cloud.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+
+	changed := make(map[string]interface{})
+	for key, val := range cloudMap {
+		if configVal, exists := configMap[key]; !exists || !deepEqual(configVal, val) {
+			changed[key] = val
+		}
+	}
+	for key := range configMap {
+		if _, exists := cloudMap[key]; !exists {
+			return nil, false
+		}
+	}
+	if len(changed) == 0 {
+		return nil, false
+	}
+
+	tokens := hclwrite.Tokens{
+		{Type: hclsyntax.TokenIdent, Bytes: []byte("merge")},
+		{Type: hclsyntax.TokenOParen, Bytes: []byte("(")},
+		{Type: hclsyntax.TokenIdent, Bytes: []byte("graft")},
+		{Type: hclsyntax.TokenDot, Bytes: []byte(".")},
+		{Type: hclsyntax.TokenIdent, Bytes: []byte("source")},
+		{Type: hclsyntax.TokenComma, Bytes: []byte(",")},
+	}
+	tokens = append(tokens, hclwrite.TokensForValue(utils.ToCtyValue(changed))...)
+	tokens = append(tokens, &hclwrite.Token{Type: hclsyntax.TokenCParen, Bytes: []byte(")")})
+	return tokens, true
+}
@@ -0,0 +1,185 @@
+package absorb
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestParsePlanFileConfigReferences(t *testing.T) {
+	planJSON := `{
+		"format_version": "1.2",
+		"resource_drift": [
+			{
+				"address": "module.network.azurerm_virtual_network.vnet",
+				"module_address": "module.network",
+				"mode": "managed",
+				"type": "azurerm_virtual_network",
+				"name": "vnet",
+				"provider_name": "registry.terraform.io/hashicorp/azurerm",
+				"change": {
+					"actions": ["update"],
+					"before": { "location": "westus", "name": "vnet", "dns_servers": [], "tags": { "env": "dev" } },
+					"after": { "location": "eastus", "name": "vnet2", "dns_servers": ["10.0.0.4"], "tags": { "env": "dev", "owner": "ops" } }
+				}
+			}
+		],
+		"configuration": {
+			"root_module": {
+				"module_calls": {
+					"network": {
+						"source": "./network",
+						"module": {
+							"resources": [
+								{
+									"address": "azurerm_virtual_network.vnet",
+									"mode": "managed",
+									"type": "azurerm_virtual_network",
+									"name": "vnet",
+									"expressions": {
+										"location": { "references": ["azurerm_resource_group.main.location", "azurerm_resource_group.main"] },
+										"name": { "constant_value": "vnet" },
+										"tags": { "references": ["var.tags"] }
+									}
+								}
+							]
+						}
+					}
+				}
+			}
+		}
+	}`
+
+	planFile := filepath.Join(t.TempDir(), "plan.json")
+	if err := os.WriteFile(planFile, []byte(planJSON), 0644); err != nil {
+		t.Fatalf("Failed to write plan file: %v", err)
+	}
+
+	result, err := ParsePlanFile(planFile, nil)
+	if err != nil {
+		t.Fatalf("ParsePlanFile failed: %v", err)
+	}
+	if len(result) != 1 {
+		t.Fatalf("expected 1 change, got %d", len(result))
+	}
+
+	expected := map[string][]string{
+		"location": {"azurerm_resource_group.main.location"},
+		"tags":     {"var.tags"},
+	}
+	if !reflect.DeepEqual(result[0].References, expected) {
+		t.Errorf("expected references %v, got %v", expected, result[0].References)
+	}
+}
+
+func TestToBlockWithConfigReferences(t *testing.T) {
+	tests := []struct {
+		name     string
+		change   DriftChange
+		schema   *tfjson.SchemaBlock
+		expected string
+	}{
+		{
+			name: "expressions are flagged and maps are merged",
+			change: DriftChange{
+				ResourceType: "azurerm_virtual_network",
+				ResourceName: "vnet",
+				BeforeAttrs: map[string]interface{}{
+					"location": "westus",
+					"tags":     map[string]interface{}{"env": "dev"},
+				},
+				ChangedAttrs: map[string]interface{}{
+					"location": "eastus",
+					"tags":     map[string]interface{}{"env": "dev", "owner": "ops"},
+				},
+				References: map[string][]string{
+					"location": {"var.location"},
+					"tags":     {"local.tags", "var.tags"},
+				},
+			},
+			schema: &tfjson.SchemaBlock{
+				Attributes: map[string]*tfjson.SchemaAttribute{
+					"location": {Required: true, AttributeType: cty.String},
+					"tags":     {Optional: true, AttributeType: cty.Map(cty.String)},
+				},
+			},
+			expected: `resource "azurerm_virtual_network" "vnet" {
+  # In config, location refers to var.location; the cloud value below replaces it
+  location = "eastus"
+  tags = merge(graft.source, {
+    owner = "ops"
+  })
+}
+`,
+		},
+		{
+			name: "without a schema maps aren't merged",
+			change: DriftChange{
+				ResourceType: "azurerm_virtual_network",
+				ResourceName: "vnet",
+				BeforeAttrs: map[string]interface{}{
+					"tags": map[string]interface{}{"env": "dev"},
+				},
+				ChangedAttrs: map[string]interface{}{
+					"tags": map[string]interface{}{"env": "dev", "owner": "ops"},
+				},
+				References: map[string][]string{
+					"tags": {"var.tags"},
+				},
+			},
+			expected: `resource "azurerm_virtual_network" "vnet" {
+  # In config, tags refers to var.tags; the cloud value below replaces it
+  tags = {
+    env   = "dev"
+    owner = "ops"
+  }
+}
+`,
+		},
+		{
+			name: "keys removed from a referenced map",
+			change: DriftChange{
+				ResourceType: "azurerm_virtual_network",
+				ResourceName: "vnet",
+				BeforeAttrs: map[string]interface{}{
+					"tags": map[string]interface{}{"env": "dev", "team": "net"},
+				},
+				ChangedAttrs: map[string]interface{}{
+					"tags": map[string]interface{}{"env": "prod"},
+				},
+				References: map[string][]string{
+					"tags": {"var.tags"},
+				},
+			},
+			expected: `resource "azurerm_virtual_network" "vnet" {
+  # In config, tags refers to var.tags; the cloud value below replaces it
+  tags = {
+    env = "prod"
+  }
+}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block := tt.change.ToBlock(tt.schema)
+			if block == nil {
+				t.Fatal("expected non-nil block, got nil")
+			}
+
+			f := hclwrite.NewEmptyFile()
+			f.Body().AppendBlock(block)
+			result := string(hclwrite.Format(f.Bytes()))
+
+			if result != tt.expected {
+				t.Errorf("unexpected output:\n got:\n%s\nwant:\n%s", result, tt.expected)
+			}
+		})
+	}
+}
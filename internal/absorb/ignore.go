@@ -0,0 +1,227 @@
+package absorb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// DefaultIgnoreFile is the file that lists drift graft absorb doesn't offer again
+const DefaultIgnoreFile = ".graftabsorbignore"
+
+// IgnoreRule skips the drift of a resource, or of one of its attributes or
+// nested blocks when Attribute is set.
+type IgnoreRule struct {
+	Address   string
+	Attribute string
+}
+
+func (r IgnoreRule) String() string {
+	if r.Attribute == "" {
+		return r.Address
+	}
+	return r.Address + " " + r.Attribute
+}
+
+// LoadIgnoreFile reads ignore rules, one per line in the form
+// "<resource address> [attribute]". Blank lines and lines starting with # are
+// skipped. A missing file has no rules.
+func LoadIgnoreFile(path string) ([]IgnoreRule, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ignore file: %w", err)
+	}
+
+	var rules []IgnoreRule
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rules = append(rules, parseIgnoreRule(line))
+	}
+	return rules, nil
+}
+
+// parseIgnoreRule splits a line into the address and attribute. for_each keys
+// may contain spaces, so the attribute is the last field only if it can't be
+// part of an address.
+func parseIgnoreRule(line string) IgnoreRule {
+	i := strings.LastIndexAny(line, " \t")
+	if i < 0 {
+		return IgnoreRule{Address: line}
+	}
+	attribute := line[i+1:]
+	if strings.ContainsAny(attribute, `"]`) {
+		return IgnoreRule{Address: line}
+	}
+	return IgnoreRule{Address: strings.TrimSpace(line[:i]), Attribute: attribute}
+}
+
+// AppendIgnoreFile adds rules to the ignore file, creating it if needed
+func AppendIgnoreFile(path string, rules []IgnoreRule) error {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	_, err := os.Stat(path)
+	isNew := errors.Is(err, fs.ErrNotExist)
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open ignore file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	var sb strings.Builder
+	if isNew {
+		sb.WriteString("# Drift that graft absorb doesn't offer again\n")
+		sb.WriteString("# <resource address> [attribute]\n")
+	}
+	for _, rule := range rules {
+		sb.WriteString(rule.String() + "\n")
+	}
+	if _, err := f.WriteString(sb.String()); err != nil {
+		return fmt.Errorf("failed to write ignore file: %w", err)
+	}
+	return nil
+}
+
+// Filter selects the drift that graft absorb captures. Include and Exclude are
+// address patterns, and Rules come from the ignore file. Patterns may use * to
+// match any text, including dots. An address pattern matches the resource
+// address with or without its instance key, or the resource type, e.g.
+// "module.network.*", "azurerm_subnet.web[*]" or "azurerm_*". Attribute
+// patterns are dot-separated paths as in isAttrPathComputedOnly, where map keys
+// are part of the path, e.g. "tags.hidden-*".
+type Filter struct {
+	Include []string
+	Exclude []string
+	Rules   []IgnoreRule
+}
+
+// skipsResource returns true if the resource is excluded, isn't included, or
+// is ignored as a whole
+func (f *Filter) skipsResource(address, resourceType string) bool {
+	if f == nil {
+		return false
+	}
+	if len(f.Include) > 0 && !matchesAnyAddress(f.Include, address, resourceType) {
+		return true
+	}
+	if matchesAnyAddress(f.Exclude, address, resourceType) {
+		return true
+	}
+	for _, rule := range f.Rules {
+		if rule.Attribute == "" && matchesAddress(rule.Address, address, resourceType) {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoredAttributes returns a function that reports whether an attribute path
+// of the resource is ignored, or nil if no rule applies to the resource
+func (f *Filter) ignoredAttributes(address, resourceType string) func(path string) bool {
+	if f == nil {
+		return nil
+	}
+	var patterns []string
+	for _, rule := range f.Rules {
+		if rule.Attribute != "" && matchesAddress(rule.Address, address, resourceType) {
+			patterns = append(patterns, rule.Attribute)
+		}
+	}
+	if len(patterns) == 0 {
+		return nil
+	}
+	return func(path string) bool {
+		for _, pattern := range patterns {
+			if matchGlob(pattern, path) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func matchesAnyAddress(patterns []string, address, resourceType string) bool {
+	for _, pattern := range patterns {
+		if matchesAddress(pattern, address, resourceType) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAddress(pattern, address, resourceType string) bool {
+	return matchGlob(pattern, address) || matchGlob(pattern, trimInstanceKey(address)) || matchGlob(pattern, resourceType)
+}
+
+// trimInstanceKey removes a trailing [index] or ["key"] from an address
+func trimInstanceKey(address string) string {
+	if !strings.HasSuffix(address, "]") {
+		return address
+	}
+	if i := strings.LastIndex(address, "["); i > 0 {
+		return address[:i]
+	}
+	return address
+}
+
+// matchGlob matches s against a pattern in which * matches any text
+func matchGlob(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, last)
+}
+
+// pruneIgnored removes the values at ignored attribute paths from val. attrPath
+// is the path of val; list items share the path of the list, like nested blocks
+// in filterComputedAttrs.
+func pruneIgnored(val interface{}, attrPath string, ignored func(path string) bool) interface{} {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			childPath := key
+			if attrPath != "" {
+				childPath = attrPath + "." + key
+			}
+			if ignored(childPath) {
+				continue
+			}
+			out[key] = pruneIgnored(value, childPath, ignored)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = pruneIgnored(item, attrPath, ignored)
+		}
+		return out
+	default:
+		return val
+	}
+}
@@ -0,0 +1,136 @@
+package absorb
+
+import (
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/ms-henglu/graft/internal/utils"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// IgnoreChanges marks the drifted attributes and nested blocks that match one
+// of the patterns to be absorbed as lifecycle ignore_changes instead of their
+// cloud value. Patterns use * like ignore rules, so "*" selects all drift.
+func IgnoreChanges(changes []DriftChange, patterns []string) []DriftChange {
+	if len(patterns) == 0 {
+		return changes
+	}
+
+	out := make([]DriftChange, 0, len(changes))
+	for _, change := range changes {
+		var keys []string
+		for _, key := range utils.SortedKeys(change.ChangedAttrs) {
+			for _, pattern := range patterns {
+				if matchGlob(pattern, key) {
+					keys = append(keys, key)
+					break
+				}
+			}
+		}
+		change.IgnoreAttrs = keys
+		out = append(out, change)
+	}
+	return out
+}
+
+// withoutIgnoredAttrs splits the attributes in IgnoreAttrs off the change and
+// returns the ignore_changes elements for them. Computed-only attributes are
+// left out, Terraform reports them as redundant. Drift in a map attribute is
+// narrowed to the changed keys, so that e.g. a tag stamped by a policy is
+// ignored without ignoring the other tags.
+func (change DriftChange) withoutIgnoredAttrs(schema *tfjson.SchemaBlock) (DriftChange, []hclwrite.Tokens) {
+	if len(change.IgnoreAttrs) == 0 {
+		return change, nil
+	}
+
+	attrs := make(map[string]interface{}, len(change.ChangedAttrs))
+	for key, val := range change.ChangedAttrs {
+		attrs[key] = val
+	}
+
+	var paths []hclwrite.Tokens
+	for _, key := range change.IgnoreAttrs {
+		cloudVal, ok := attrs[key]
+		if !ok {
+			continue
+		}
+		delete(attrs, key)
+		if schema != nil && isAttrPathComputedOnly(schema, key) {
+			continue
+		}
+
+		mapKeys := changedMapKeys(schema, key, change.BeforeAttrs[key], cloudVal)
+		if len(mapKeys) == 0 {
+			paths = append(paths, hclwrite.TokensForIdentifier(key))
+			continue
+		}
+		for _, mapKey := range mapKeys {
+			tokens := hclwrite.TokensForIdentifier(key)
+			tokens = append(tokens, &hclwrite.Token{Type: hclsyntax.TokenOBrack, Bytes: []byte("[")})
+			tokens = append(tokens, hclwrite.TokensForValue(cty.StringVal(mapKey))...)
+			tokens = append(tokens, &hclwrite.Token{Type: hclsyntax.TokenCBrack, Bytes: []byte("]")})
+			paths = append(paths, tokens)
+		}
+	}
+
+	change.ChangedAttrs = attrs
+	return change, paths
+}
+
+// changedMapKeys returns the sorted keys that differ between two values of a
+// map attribute, or nil if the attribute isn't a map in the schema or didn't
+// exist before
+func changedMapKeys(schema *tfjson.SchemaBlock, name string, before, after interface{}) []string {
+	if schema == nil || schema.Attributes[name] == nil || !schema.Attributes[name].AttributeType.IsMapType() {
+		return nil
+	}
+	beforeMap, ok := before.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	afterMap, ok := after.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	keys := make(map[string]bool)
+	for key, val := range afterMap {
+		if !deepEqual(beforeMap[key], val) {
+			keys[key] = true
+		}
+	}
+	for key := range beforeMap {
+		if _, ok := afterMap[key]; !ok {
+			keys[key] = true
+		}
+	}
+	return utils.SortedKeys(keys)
+}
+
+// appendIgnoreChanges adds a lifecycle block that appends paths to the
+// ignore_changes of the source block:
+//
+//	lifecycle {
+//	  ignore_changes = concat(graft.source, [tags["CreatedOn"]])
+//	}
+//
+// The patch stage resolves graft.source and flattens the concat() into a
+// static list, as Terraform requires.
+func appendIgnoreChanges(body *hclwrite.Body, paths []hclwrite.Tokens) {
+	if len(paths) == 0 {
+		return
+	}
+
+	tokens := hclwrite.Tokens{
+		{Type: hclsyntax.TokenIdent, Bytes: []byte("concat")},
+		{Type: hclsyntax.TokenOParen, Bytes: []byte("(")},
+		{Type: hclsyntax.TokenIdent, Bytes: []byte("graft")},
+		{Type: hclsyntax.TokenDot, Bytes: []byte(".")},
+		{Type: hclsyntax.TokenIdent, Bytes: []byte("source")},
+		{Type: hclsyntax.TokenComma, Bytes: []byte(",")},
+	}
+	tokens = append(tokens, hclwrite.TokensForTuple(paths)...)
+	tokens = append(tokens, &hclwrite.Token{Type: hclsyntax.TokenCParen, Bytes: []byte(")")})
+
+	body.AppendNewBlock("lifecycle", nil).Body().SetAttributeRaw("ignore_changes", tokens)
+}
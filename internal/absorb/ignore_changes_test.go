@@ -0,0 +1,173 @@
+package absorb
+
+import (
+	"testing"
+
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestToBlockWithIgnoreChanges(t *testing.T) {
+	schema := &tfjson.SchemaBlock{
+		Attributes: map[string]*tfjson.SchemaAttribute{
+			"location":    {Required: true, AttributeType: cty.String},
+			"sku":         {Optional: true, AttributeType: cty.String},
+			"etag":        {Computed: true, AttributeType: cty.String},
+			"tags":        {Optional: true, AttributeType: cty.Map(cty.String)},
+			"dns_servers": {Optional: true, AttributeType: cty.List(cty.String)},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		change   DriftChange
+		patterns []string
+		expected string
+	}{
+		{
+			name: "all drift is ignored",
+			change: DriftChange{
+				ResourceType: "azurerm_virtual_network",
+				ResourceName: "main",
+				BeforeAttrs: map[string]interface{}{
+					"sku":  "Basic",
+					"tags": map[string]interface{}{"env": "prod", "CreatedOn": "2024-01-01"},
+				},
+				ChangedAttrs: map[string]interface{}{
+					"sku":  "Standard",
+					"etag": "abc",
+					"tags": map[string]interface{}{"env": "prod", "CreatedOn": "2024-06-01", "Owner": "ops"},
+				},
+			},
+			patterns: []string{"*"},
+			expected: `resource "azurerm_virtual_network" "main" {
+  lifecycle {
+    ignore_changes = concat(graft.source, [sku, tags["CreatedOn"], tags["Owner"]])
+  }
+}
+`,
+		},
+		{
+			name: "selected attributes are ignored, the rest is pinned",
+			change: DriftChange{
+				ResourceType: "azurerm_virtual_network",
+				ResourceName: "main",
+				BeforeAttrs: map[string]interface{}{
+					"dns_servers": []interface{}{"10.0.0.4"},
+				},
+				ChangedAttrs: map[string]interface{}{
+					"dns_servers": []interface{}{"10.0.0.5"},
+					"tags":        map[string]interface{}{"CreatedOn": "2024-06-01"},
+				},
+			},
+			patterns: []string{"tags"},
+			expected: `resource "azurerm_virtual_network" "main" {
+  dns_servers = ["10.0.0.5"]
+  lifecycle {
+    ignore_changes = concat(graft.source, [tags])
+  }
+}
+`,
+		},
+		{
+			name: "removed attributes are ignored too",
+			change: DriftChange{
+				ResourceType: "azurerm_virtual_network",
+				ResourceName: "main",
+				BeforeAttrs: map[string]interface{}{
+					"sku": "Basic",
+				},
+				ChangedAttrs: map[string]interface{}{
+					"sku": nil,
+				},
+			},
+			patterns: []string{"sku"},
+			expected: `resource "azurerm_virtual_network" "main" {
+  lifecycle {
+    ignore_changes = concat(graft.source, [sku])
+  }
+}
+`,
+		},
+		{
+			name: "only computed drift",
+			change: DriftChange{
+				ResourceType: "azurerm_virtual_network",
+				ResourceName: "main",
+				ChangedAttrs: map[string]interface{}{
+					"etag": "abc",
+				},
+			},
+			patterns: []string{"*"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			change := IgnoreChanges([]DriftChange{tt.change}, tt.patterns)[0]
+			block := change.ToBlock(schema)
+			if tt.expected == "" {
+				if block != nil {
+					t.Errorf("expected nil block, got non-nil")
+				}
+				return
+			}
+			if block == nil {
+				t.Fatal("expected non-nil block, got nil")
+			}
+
+			f := hclwrite.NewEmptyFile()
+			f.Body().AppendBlock(block)
+			result := string(hclwrite.Format(f.Bytes()))
+
+			if result != tt.expected {
+				t.Errorf("unexpected output:\n got:\n%s\nwant:\n%s", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIndexedToBlockWithIgnoreChanges(t *testing.T) {
+	schema := &tfjson.SchemaBlock{
+		Attributes: map[string]*tfjson.SchemaAttribute{
+			"tags": {Optional: true, AttributeType: cty.Map(cty.String)},
+		},
+	}
+	changes := IgnoreChanges([]DriftChange{
+		{
+			ResourceType: "azurerm_subnet",
+			ResourceName: "main",
+			Index:        float64(0),
+			BeforeAttrs:  map[string]interface{}{"tags": map[string]interface{}{"b": "1"}},
+			ChangedAttrs: map[string]interface{}{"tags": map[string]interface{}{"b": "2"}},
+		},
+		{
+			ResourceType: "azurerm_subnet",
+			ResourceName: "main",
+			Index:        float64(1),
+			BeforeAttrs:  map[string]interface{}{"tags": map[string]interface{}{"a": "1", "b": "2"}},
+			ChangedAttrs: map[string]interface{}{"tags": map[string]interface{}{"a": "2", "b": "3"}},
+		},
+	}, []string{"tags"})
+
+	group := &IndexedDriftChange{ResourceKey: "azurerm_subnet.main", ResourceType: "azurerm_subnet", Changes: changes}
+	block := group.ToBlock(schema)
+	if block == nil {
+		t.Fatal("expected non-nil block, got nil")
+	}
+
+	f := hclwrite.NewEmptyFile()
+	f.Body().AppendBlock(block)
+	result := string(hclwrite.Format(f.Bytes()))
+
+	expected := `resource "azurerm_subnet" "main" {
+  lifecycle {
+    ignore_changes = concat(graft.source, [tags["a"], tags["b"]])
+  }
+}
+`
+	if result != expected {
+		t.Errorf("unexpected output:\n got:\n%s\nwant:\n%s", result, expected)
+	}
+}
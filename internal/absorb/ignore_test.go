@@ -0,0 +1,149 @@
+package absorb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseIgnoreRule(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected IgnoreRule
+	}{
+		{"azurerm_resource_group.main", IgnoreRule{Address: "azurerm_resource_group.main"}},
+		{"azurerm_resource_group.main tags", IgnoreRule{Address: "azurerm_resource_group.main", Attribute: "tags"}},
+		{`azurerm_resource_group.main["my rg"]`, IgnoreRule{Address: `azurerm_resource_group.main["my rg"]`}},
+		{`azurerm_resource_group.main["my rg"]  tags`, IgnoreRule{Address: `azurerm_resource_group.main["my rg"]`, Attribute: "tags"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			if got := parseIgnoreRule(tt.line); got != tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestIgnoreFileRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DefaultIgnoreFile)
+
+	rules, err := LoadIgnoreFile(path)
+	if err != nil || rules != nil {
+		t.Fatalf("expected no rules for a missing file, got %v, %v", rules, err)
+	}
+
+	if err := AppendIgnoreFile(path, []IgnoreRule{{Address: "azurerm_subnet.web", Attribute: "service_endpoints"}}); err != nil {
+		t.Fatalf("AppendIgnoreFile failed: %v", err)
+	}
+	if err := AppendIgnoreFile(path, []IgnoreRule{{Address: `azurerm_resource_group.main["a b"]`}}); err != nil {
+		t.Fatalf("AppendIgnoreFile failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `# Drift that graft absorb doesn't offer again
+# <resource address> [attribute]
+azurerm_subnet.web service_endpoints
+azurerm_resource_group.main["a b"]
+`
+	if string(data) != expected {
+		t.Errorf("unexpected ignore file:\n%s\nexpected:\n%s", data, expected)
+	}
+
+	rules, err = LoadIgnoreFile(path)
+	if err != nil {
+		t.Fatalf("LoadIgnoreFile failed: %v", err)
+	}
+	if len(rules) != 2 || rules[0].Attribute != "service_endpoints" || rules[1].Address != `azurerm_resource_group.main["a b"]` {
+		t.Errorf("unexpected rules: %+v", rules)
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		match   bool
+	}{
+		{"tags", "tags", true},
+		{"tags", "tags.env", false},
+		{"tags.hidden-*", "tags.hidden-link", true},
+		{"tags.hidden-*", "tags.env", false},
+		{"*last_modified*", "blob_properties.last_modified_time", true},
+		{"azurerm_*", "azurerm_subnet", true},
+		{"module.network.*", "module.network.azurerm_subnet.web", true},
+		{"module.network.*", "azurerm_subnet.web", false},
+		{`azurerm_subnet.web["a"]`, `azurerm_subnet.web["a"]`, true},
+		{"a*b*c", "abc", true},
+		{"a*b*c", "acb", false},
+		{"ab*ba", "aba", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.s, func(t *testing.T) {
+			if got := matchGlob(tt.pattern, tt.s); got != tt.match {
+				t.Errorf("matchGlob(%q, %q) = %v, expected %v", tt.pattern, tt.s, got, tt.match)
+			}
+		})
+	}
+}
+
+func TestFilterSkipsResource(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  *Filter
+		address string
+		skipped bool
+	}{
+		{
+			name:    "nil filter",
+			address: "azurerm_subnet.web",
+		},
+		{
+			name:    "not included",
+			filter:  &Filter{Include: []string{"module.network.*"}},
+			address: "azurerm_subnet.web",
+			skipped: true,
+		},
+		{
+			name:    "included",
+			filter:  &Filter{Include: []string{"module.network.*"}},
+			address: "module.network.azurerm_subnet.web",
+		},
+		{
+			name:    "excluded by type",
+			filter:  &Filter{Exclude: []string{"azurerm_subnet"}},
+			address: `module.network.azurerm_subnet.web["a"]`,
+			skipped: true,
+		},
+		{
+			name:    "excluded without instance key",
+			filter:  &Filter{Exclude: []string{"azurerm_subnet.web"}},
+			address: "azurerm_subnet.web[0]",
+			skipped: true,
+		},
+		{
+			name:    "ignored as a whole",
+			filter:  &Filter{Rules: []IgnoreRule{{Address: "azurerm_subnet.*"}}},
+			address: "azurerm_subnet.web",
+			skipped: true,
+		},
+		{
+			name:    "attribute rules don't skip the resource",
+			filter:  &Filter{Rules: []IgnoreRule{{Address: "azurerm_subnet.*", Attribute: "tags"}}},
+			address: "azurerm_subnet.web",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.skipsResource(tt.address, "azurerm_subnet"); got != tt.skipped {
+				t.Errorf("expected skipped = %v, got %v", tt.skipped, got)
+			}
+		})
+	}
+}
@@ -31,8 +31,21 @@ func (c *IndexedDriftChange) ToBlock(schema *tfjson.SchemaBlock) *hclwrite.Block
 	// blocks need the complete block content to replace the original static blocks.
 	var processed []DriftChange
 
+	// ignore_changes applies to all instances, so the paths are combined
+	var ignorePaths []hclwrite.Tokens
+	seenPaths := make(map[string]bool)
+
 	for _, change := range c.Changes {
-		result := filterComputedAttrs(change.ChangedAttrs, schema, "")
+		change, paths := change.withoutIgnoredAttrs(schema)
+		for _, path := range paths {
+			if !seenPaths[string(path.Bytes())] {
+				seenPaths[string(path.Bytes())] = true
+				ignorePaths = append(ignorePaths, path)
+			}
+		}
+
+		// Removals aren't absorbed per instance yet
+		result := filterComputedAttrs(withoutRemovedAttrs(change.ChangedAttrs), schema, "")
 		attrs, _ := result.(map[string]interface{})
 		if len(attrs) == 0 {
 			continue
@@ -57,7 +70,7 @@ func (c *IndexedDriftChange) ToBlock(schema *tfjson.SchemaBlock) *hclwrite.Block
 		change.ChangedAttrs = attrs
 		processed = append(processed, change)
 	}
-	if len(processed) == 0 {
+	if len(processed) == 0 && len(ignorePaths) == 0 {
 		return nil
 	}
 
@@ -85,6 +98,7 @@ func (c *IndexedDriftChange) ToBlock(schema *tfjson.SchemaBlock) *hclwrite.Block
 
 	// Render attribute drift using lookup()
 	for _, key := range attrKeys {
+		appendReferencesComment(resBody, key, instanceReferences(processed, key))
 		tokens := buildLookupTokens(processed, key, indexRef)
 		resBody.SetAttributeRaw(key, tokens)
 	}
@@ -99,6 +113,11 @@ func (c *IndexedDriftChange) ToBlock(schema *tfjson.SchemaBlock) *hclwrite.Block
 		}
 	}
 
+	sort.Slice(ignorePaths, func(i, j int) bool {
+		return string(ignorePaths[i].Bytes()) < string(ignorePaths[j].Bytes())
+	})
+	appendIgnoreChanges(resBody, ignorePaths)
+
 	if len(removals) > 0 {
 		sort.Strings(removals)
 		graftBlock := resBody.AppendNewBlock("_graft", nil)
@@ -113,6 +132,17 @@ func (c *IndexedDriftChange) ToBlock(schema *tfjson.SchemaBlock) *hclwrite.Block
 	return resBlock
 }
 
+// instanceReferences combines the config references of key across instances
+func instanceReferences(changes []DriftChange, key string) []string {
+	refs := make(map[string]bool)
+	for _, change := range changes {
+		for _, ref := range change.References[key] {
+			refs[ref] = true
+		}
+	}
+	return utils.SortedKeys(refs)
+}
+
 // buildDynamicBlock generates a dynamic block for per-instance block drift:
 //
 //	dynamic "block_name" {
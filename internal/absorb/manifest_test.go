@@ -445,8 +445,8 @@ func TestGenerateManifestInvalidSchemaJSON(t *testing.T) {
 
 func TestGenerateManifestWithSchemaFile(t *testing.T) {
 	planJSON := `{
-		"format_version": "1.0",
-		"terraform_version": "1.5.0",
+		"format_version": "0.1",
+		"terraform_version": "0.15.3",
 		"resource_changes": [
 			{
 				"address": "module.network.azurerm_virtual_network.main",
@@ -519,7 +519,7 @@ func TestGenerateManifestWithSchemaFile(t *testing.T) {
 		t.Fatalf("Failed to write schemas file: %v", err)
 	}
 
-	result, err := ParsePlanFile(planFile)
+	result, err := ParsePlanFile(planFile, nil)
 	if err != nil {
 		t.Fatalf("ParsePlanFile failed: %v", err)
 	}
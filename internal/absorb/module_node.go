@@ -95,6 +95,7 @@ func (m *ModuleItem) ToHCL(providerSchemas *tfjson.ProviderSchemas) hclwrite.Tok
 			overrideBody.AppendUnstructuredTokens(hclwrite.Tokens{
 				{Type: hclsyntax.TokenComment, Bytes: []byte(fmt.Sprintf("# Absorb drift for: %s\n", change.Address))},
 			})
+			appendReplaceWarning(overrideBody, change.replaceWarnings())
 			overrideBody.AppendBlock(block)
 			overrideBody.AppendNewline()
 		}
@@ -110,12 +111,17 @@ func (m *ModuleItem) ToHCL(providerSchemas *tfjson.ProviderSchemas) hclwrite.Tok
 
 			// Build comment listing all addresses
 			var addresses []string
+			replaced := make(map[string]bool)
 			for _, change := range group.Changes {
 				addresses = append(addresses, change.Address)
+				for _, key := range change.replaceWarnings() {
+					replaced[key] = true
+				}
 			}
 			overrideBody.AppendUnstructuredTokens(hclwrite.Tokens{
 				{Type: hclsyntax.TokenComment, Bytes: []byte(fmt.Sprintf("# Absorb drift for: %s\n", strings.Join(addresses, ", ")))},
 			})
+			appendReplaceWarning(overrideBody, utils.SortedKeys(replaced))
 			overrideBody.AppendBlock(block)
 			overrideBody.AppendNewline()
 		}
@@ -0,0 +1,102 @@
+package absorb
+
+import (
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/ms-henglu/graft/internal/utils"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// findRemovals compares the configured values with the cloud state and returns
+// the dot-separated paths of attributes and nested blocks that are set in config
+// but were removed remotely. Only optional attributes are returned, since
+// nulling a required one would make the configuration invalid, and only blocks
+// that may be omitted. It follows single nested blocks that exist on both sides,
+// e.g. "blob_properties.delete_retention_policy".
+func findRemovals(config, cloud map[string]interface{}, schema *tfjson.SchemaBlock, prefix string) (attrs []string, blocks []string) {
+	if schema == nil {
+		return nil, nil
+	}
+
+	for _, key := range utils.SortedKeys(cloud) {
+		if key == "timeouts" {
+			continue
+		}
+		configVal, exists := config[key]
+		if !exists || isEmptyValue(configVal) {
+			continue
+		}
+		cloudVal := cloud[key]
+
+		if shouldRenderAsBlock(schema, key) {
+			if isEmptyValue(cloudVal) {
+				if isOptionalBlock(schema, key) {
+					blocks = append(blocks, prefix+key)
+				}
+				continue
+			}
+			if isSingleBlock(configVal) && isSingleBlock(cloudVal) {
+				nestedAttrs, nestedBlocks := findRemovals(asSingleBlock(configVal), asSingleBlock(cloudVal), nestedBlockSchema(schema, key), prefix+key+".")
+				attrs = append(attrs, nestedAttrs...)
+				blocks = append(blocks, nestedBlocks...)
+			}
+			continue
+		}
+
+		if cloudVal == nil {
+			if attr := schema.Attributes[key]; attr != nil && attr.Optional && !attr.Required {
+				attrs = append(attrs, prefix+key)
+			}
+		}
+	}
+	return attrs, blocks
+}
+
+// isEmptyValue returns true for null and for empty lists, which is how the plan
+// represents a nested block that doesn't exist
+func isEmptyValue(val interface{}) bool {
+	if val == nil {
+		return true
+	}
+	if arr, ok := val.([]interface{}); ok {
+		return len(arr) == 0
+	}
+	return false
+}
+
+// isOptionalBlock returns true if the block-type key can be omitted from config
+func isOptionalBlock(schema *tfjson.SchemaBlock, name string) bool {
+	if bt, ok := schema.NestedBlocks[name]; ok && bt != nil {
+		return bt.MinItems == 0
+	}
+	attr, ok := schema.Attributes[name]
+	return ok && attr != nil && attr.Optional && !attr.Required
+}
+
+// withoutRemovedAttrs returns attrs without the nil values that
+// findDriftedAttributes records for attributes removed remotely
+func withoutRemovedAttrs(attrs map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(attrs))
+	for key, val := range attrs {
+		if val != nil {
+			out[key] = val
+		}
+	}
+	return out
+}
+
+// setNullAttribute sets the attribute at a dot-separated path to null, adding
+// the nested blocks on the way if the body doesn't have them yet
+func setNullAttribute(body *hclwrite.Body, path string) {
+	segments := strings.Split(path, ".")
+	for _, segment := range segments[:len(segments)-1] {
+		block := body.FirstMatchingBlock(segment, nil)
+		if block == nil {
+			block = body.AppendNewBlock(segment, nil)
+		}
+		body = block.Body()
+	}
+	body.SetAttributeValue(segments[len(segments)-1], cty.NullVal(cty.DynamicPseudoType))
+}
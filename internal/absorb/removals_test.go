@@ -0,0 +1,187 @@
+package absorb
+
+import (
+	"testing"
+
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+func TestToBlockWithRemovals(t *testing.T) {
+	storageSchema := &tfjson.SchemaBlock{
+		Attributes: map[string]*tfjson.SchemaAttribute{
+			"name":            {Required: true},
+			"access_tier":     {Optional: true, Computed: true},
+			"min_tls_version": {Optional: true},
+		},
+		NestedBlocks: map[string]*tfjson.SchemaBlockType{
+			"blob_properties": {
+				NestingMode: tfjson.SchemaNestingModeList,
+				MaxItems:    1,
+				Block: &tfjson.SchemaBlock{
+					Attributes: map[string]*tfjson.SchemaAttribute{
+						"versioning_enabled":  {Optional: true},
+						"change_feed_enabled": {Optional: true},
+					},
+					NestedBlocks: map[string]*tfjson.SchemaBlockType{
+						"delete_retention_policy": {
+							NestingMode: tfjson.SchemaNestingModeList,
+							MaxItems:    1,
+							Block: &tfjson.SchemaBlock{
+								Attributes: map[string]*tfjson.SchemaAttribute{
+									"days": {Optional: true},
+								},
+							},
+						},
+					},
+				},
+			},
+			"network_rules": {
+				NestingMode: tfjson.SchemaNestingModeList,
+				MinItems:    1,
+				MaxItems:    1,
+				Block: &tfjson.SchemaBlock{
+					Attributes: map[string]*tfjson.SchemaAttribute{
+						"default_action": {Required: true},
+					},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		change   DriftChange
+		schema   *tfjson.SchemaBlock
+		expected string
+	}{
+		{
+			name: "optional attribute removed remotely is nulled",
+			change: DriftChange{
+				ResourceType: "azurerm_storage_account",
+				ResourceName: "sa",
+				BeforeAttrs: map[string]interface{}{
+					"name":            "sa",
+					"min_tls_version": "TLS1_2",
+					"access_tier":     "Hot",
+				},
+				ChangedAttrs: map[string]interface{}{
+					"min_tls_version": nil,
+					"access_tier":     "Cool",
+				},
+			},
+			schema: storageSchema,
+			expected: `resource "azurerm_storage_account" "sa" {
+  access_tier     = "Cool"
+  min_tls_version = null
+}
+`,
+		},
+		{
+			name: "required attribute removed remotely is skipped",
+			change: DriftChange{
+				ResourceType: "azurerm_storage_account",
+				ResourceName: "sa",
+				BeforeAttrs:  map[string]interface{}{"name": "sa"},
+				ChangedAttrs: map[string]interface{}{"name": nil},
+			},
+			schema: storageSchema,
+		},
+		{
+			name: "removals are skipped without schema",
+			change: DriftChange{
+				ResourceType: "azurerm_storage_account",
+				ResourceName: "sa",
+				BeforeAttrs:  map[string]interface{}{"min_tls_version": "TLS1_2"},
+				ChangedAttrs: map[string]interface{}{"min_tls_version": nil},
+			},
+		},
+		{
+			name: "nested block removed remotely",
+			change: DriftChange{
+				ResourceType: "azurerm_storage_account",
+				ResourceName: "sa",
+				BeforeAttrs: map[string]interface{}{
+					"blob_properties": []interface{}{
+						map[string]interface{}{
+							"versioning_enabled":  true,
+							"change_feed_enabled": true,
+							"delete_retention_policy": []interface{}{
+								map[string]interface{}{"days": float64(7)},
+							},
+						},
+					},
+				},
+				ChangedAttrs: map[string]interface{}{
+					"blob_properties": []interface{}{
+						map[string]interface{}{
+							"versioning_enabled":      nil,
+							"change_feed_enabled":     false,
+							"delete_retention_policy": []interface{}{},
+						},
+					},
+				},
+			},
+			schema: storageSchema,
+			expected: `resource "azurerm_storage_account" "sa" {
+  blob_properties {
+    change_feed_enabled = false
+    versioning_enabled  = null
+  }
+  _graft {
+    remove = ["blob_properties.delete_retention_policy"]
+  }
+}
+`,
+		},
+		{
+			name: "top-level block removed remotely",
+			change: DriftChange{
+				ResourceType: "azurerm_storage_account",
+				ResourceName: "sa",
+				BeforeAttrs: map[string]interface{}{
+					"blob_properties": []interface{}{
+						map[string]interface{}{"versioning_enabled": true},
+					},
+					"network_rules": []interface{}{
+						map[string]interface{}{"default_action": "Deny"},
+					},
+				},
+				ChangedAttrs: map[string]interface{}{
+					"blob_properties": []interface{}{},
+					"network_rules":   []interface{}{},
+				},
+			},
+			schema: storageSchema,
+			expected: `resource "azurerm_storage_account" "sa" {
+  _graft {
+    remove = ["blob_properties"]
+  }
+}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block := tt.change.ToBlock(tt.schema)
+			if tt.expected == "" {
+				if block != nil {
+					t.Errorf("expected nil block, got non-nil")
+				}
+				return
+			}
+			if block == nil {
+				t.Fatal("expected non-nil block, got nil")
+			}
+
+			f := hclwrite.NewEmptyFile()
+			f.Body().AppendBlock(block)
+			result := string(hclwrite.Format(f.Bytes()))
+
+			if result != tt.expected {
+				t.Errorf("unexpected output:\n got:\n%s\nwant:\n%s", result, tt.expected)
+			}
+		})
+	}
+}
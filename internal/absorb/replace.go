@@ -0,0 +1,100 @@
+package absorb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+// replacePaths returns the sorted top-level attributes and nested blocks in the
+// replace_paths of a planned replacement, e.g. "location" for [["location"]]
+// and "os_disk" for [["os_disk", 0, "name"]]
+func replacePaths(paths []interface{}) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, path := range paths {
+		steps, ok := path.([]interface{})
+		if !ok || len(steps) == 0 {
+			continue
+		}
+		key, ok := steps[0].(string)
+		if !ok || seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// replacedResources returns the replacePaths of the resources that
+// resource_changes plans to replace, keyed by address
+func replacedResources(changes []*tfjson.ResourceChange) map[string][]string {
+	result := make(map[string][]string)
+	for _, rc := range changes {
+		if rc.Change == nil || !rc.Change.Actions.Replace() {
+			continue
+		}
+		result[rc.Address] = replacePaths(rc.Change.ReplacePaths)
+	}
+	return result
+}
+
+// selectKeys returns the entries of m whose keys are listed
+func selectKeys(m map[string]interface{}, keys []string) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	result := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		if val, ok := m[key]; ok {
+			result[key] = val
+		}
+	}
+	return result
+}
+
+// forcedAttrs returns the drifted keys that force the resource to be replaced
+func forcedAttrs(changedAttrs map[string]interface{}, paths []string) []string {
+	var result []string
+	for _, key := range paths {
+		if _, ok := changedAttrs[key]; ok {
+			result = append(result, key)
+		}
+	}
+	return result
+}
+
+// replaceWarnings returns the keys in ReplaceAttrs that are absorbed as their
+// cloud value, leaving out those absorbed as ignore_changes
+func (change DriftChange) replaceWarnings() []string {
+	ignored := make(map[string]bool, len(change.IgnoreAttrs))
+	for _, key := range change.IgnoreAttrs {
+		ignored[key] = true
+	}
+	var result []string
+	for _, key := range forcedAttrs(change.ChangedAttrs, change.ReplaceAttrs) {
+		if !ignored[key] {
+			result = append(result, key)
+		}
+	}
+	return result
+}
+
+// appendReplaceWarning warns in front of a resource block that the absorbed
+// values stop Terraform from replacing the resource, so they deserve a closer
+// look than other drift
+func appendReplaceWarning(body *hclwrite.Body, keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	body.AppendUnstructuredTokens(hclwrite.Tokens{
+		{Type: hclsyntax.TokenComment, Bytes: []byte(fmt.Sprintf("# WARNING: drift in %s forces replacement. Undoing it would destroy and\n", strings.Join(keys, ", ")))},
+		{Type: hclsyntax.TokenComment, Bytes: []byte("# recreate the resource, so the cloud values below are kept. Check them before applying.\n")},
+	})
+}
@@ -0,0 +1,210 @@
+package absorb
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReplacePaths(t *testing.T) {
+	paths := []interface{}{
+		[]interface{}{"os_disk", float64(0), "name"},
+		[]interface{}{"location"},
+		[]interface{}{"os_disk", float64(0), "caching"},
+		[]interface{}{},
+	}
+	expected := []string{"location", "os_disk"}
+	if got := replacePaths(paths); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestParsePlanFileReplace(t *testing.T) {
+	// The location and the VM size were changed outside of Terraform, and
+	// changing them back replaces the resources. The Terraform version has no
+	// resource_drift, so it's read from resource_changes. Data sources are
+	// read, not drifted, so they are skipped.
+	planJSON := `{
+		"format_version": "0.1",
+		"terraform_version": "0.15.3",
+		"resource_changes": [
+			{
+				"address": "azurerm_resource_group.main",
+				"mode": "managed",
+				"type": "azurerm_resource_group",
+				"name": "main",
+				"provider_name": "registry.terraform.io/hashicorp/azurerm",
+				"change": {
+					"actions": ["delete", "create"],
+					"before": { "id": "/rg", "name": "rg", "location": "westus", "tags": { "CreatedOn": "2024-06-01" } },
+					"after": { "name": "rg", "location": "eastus", "tags": {} },
+					"replace_paths": [["location"]]
+				}
+			},
+			{
+				"address": "azurerm_linux_virtual_machine.vm",
+				"mode": "managed",
+				"type": "azurerm_linux_virtual_machine",
+				"name": "vm",
+				"provider_name": "registry.terraform.io/hashicorp/azurerm",
+				"change": {
+					"actions": ["create", "delete"],
+					"before": { "id": "/vm", "size": "Standard_D4s_v3" },
+					"after": { "size": "Standard_D2s_v3" },
+					"replace_paths": [["size"]]
+				}
+			},
+			{
+				"address": "data.azurerm_client_config.current",
+				"mode": "data",
+				"type": "azurerm_client_config",
+				"name": "current",
+				"provider_name": "registry.terraform.io/hashicorp/azurerm",
+				"change": {
+					"actions": ["read"],
+					"before": null,
+					"after": { "tenant_id": "t" }
+				}
+			}
+		]
+	}`
+
+	tmpDir := t.TempDir()
+	planFile := filepath.Join(tmpDir, "plan.json")
+	if err := os.WriteFile(planFile, []byte(planJSON), 0644); err != nil {
+		t.Fatalf("Failed to write plan file: %v", err)
+	}
+
+	result, err := ParsePlanFile(planFile, nil)
+	if err != nil {
+		t.Fatalf("ParsePlanFile failed: %v", err)
+	}
+	if len(result) != 2 {
+		t.Fatalf("expected 2 changes, got %d", len(result))
+	}
+
+	changes := make(map[string]DriftChange)
+	for _, change := range result {
+		changes[change.Address] = change
+	}
+
+	// Only the paths that force the replacement are absorbed, not the id the
+	// new resource doesn't know yet or other differences
+	rg := changes["azurerm_resource_group.main"]
+	if expected := map[string]interface{}{"location": "westus"}; !reflect.DeepEqual(rg.ChangedAttrs, expected) {
+		t.Errorf("expected changed attributes %v, got %v", expected, rg.ChangedAttrs)
+	}
+	if expected := []string{"location"}; !reflect.DeepEqual(rg.ReplaceAttrs, expected) {
+		t.Errorf("expected replace attributes %v, got %v", expected, rg.ReplaceAttrs)
+	}
+
+	vm := changes["azurerm_linux_virtual_machine.vm"]
+	if expected := map[string]interface{}{"size": "Standard_D4s_v3"}; !reflect.DeepEqual(vm.ChangedAttrs, expected) {
+		t.Errorf("expected changed attributes %v, got %v", expected, vm.ChangedAttrs)
+	}
+}
+
+func TestParsePlanFileReplaceResourceDrift(t *testing.T) {
+	planJSON := `{
+		"format_version": "1.2",
+		"terraform_version": "1.9.0",
+		"resource_drift": [
+			{
+				"address": "azurerm_resource_group.main",
+				"mode": "managed",
+				"type": "azurerm_resource_group",
+				"name": "main",
+				"provider_name": "registry.terraform.io/hashicorp/azurerm",
+				"change": {
+					"actions": ["update"],
+					"before": { "location": "eastus", "tags": {} },
+					"after": { "location": "westus", "tags": { "CreatedOn": "2024-06-01" } }
+				}
+			}
+		],
+		"resource_changes": [
+			{
+				"address": "azurerm_resource_group.main",
+				"mode": "managed",
+				"type": "azurerm_resource_group",
+				"name": "main",
+				"provider_name": "registry.terraform.io/hashicorp/azurerm",
+				"change": {
+					"actions": ["delete", "create"],
+					"before": { "location": "westus", "tags": { "CreatedOn": "2024-06-01" } },
+					"after": { "location": "eastus", "tags": {} },
+					"replace_paths": [["location"]]
+				}
+			}
+		]
+	}`
+
+	tmpDir := t.TempDir()
+	planFile := filepath.Join(tmpDir, "plan.json")
+	if err := os.WriteFile(planFile, []byte(planJSON), 0644); err != nil {
+		t.Fatalf("Failed to write plan file: %v", err)
+	}
+
+	result, err := ParsePlanFile(planFile, nil)
+	if err != nil {
+		t.Fatalf("ParsePlanFile failed: %v", err)
+	}
+	if len(result) != 1 {
+		t.Fatalf("expected 1 change, got %d", len(result))
+	}
+	if len(result[0].ChangedAttrs) != 2 {
+		t.Errorf("expected all drift to be absorbed, got %v", result[0].ChangedAttrs)
+	}
+	if expected := []string{"location"}; !reflect.DeepEqual(result[0].ReplaceAttrs, expected) {
+		t.Errorf("expected replace attributes %v, got %v", expected, result[0].ReplaceAttrs)
+	}
+}
+
+func TestGenerateManifestReplaceWarning(t *testing.T) {
+	changes := []DriftChange{
+		{
+			Address:      "azurerm_resource_group.main",
+			ResourceType: "azurerm_resource_group",
+			ResourceName: "main",
+			ChangedAttrs: map[string]interface{}{"location": "westus"},
+			ReplaceAttrs: []string{"location"},
+		},
+		{
+			Address:      `azurerm_linux_virtual_machine.vm["web"]`,
+			ResourceType: "azurerm_linux_virtual_machine",
+			ResourceName: "vm",
+			Index:        "web",
+			ChangedAttrs: map[string]interface{}{"size": "Standard_D4s_v3"},
+			ReplaceAttrs: []string{"size"},
+		},
+		{
+			Address:      "azurerm_storage_account.logs",
+			ResourceType: "azurerm_storage_account",
+			ResourceName: "logs",
+			ChangedAttrs: map[string]interface{}{"account_replication_type": "GRS"},
+			ReplaceAttrs: []string{"account_replication_type"},
+			IgnoreAttrs:  []string{"account_replication_type"},
+		},
+	}
+
+	content, err := GenerateManifest(changes, "")
+	if err != nil {
+		t.Fatalf("GenerateManifest failed: %v", err)
+	}
+	result := string(content)
+
+	for _, expected := range []string{
+		"# Absorb drift for: azurerm_resource_group.main\n  # WARNING: drift in location forces replacement.",
+		"# Absorb drift for: azurerm_linux_virtual_machine.vm[\"web\"]\n  # WARNING: drift in size forces replacement.",
+	} {
+		if !strings.Contains(result, expected) {
+			t.Errorf("expected manifest to contain %q, got:\n%s", expected, result)
+		}
+	}
+	// Ignoring the drift doesn't replace the resource
+	if strings.Contains(result, "drift in account_replication_type") {
+		t.Errorf("expected no warning for ignored drift, got:\n%s", result)
+	}
+}
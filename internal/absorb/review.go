@@ -0,0 +1,185 @@
+package absorb
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/ms-henglu/graft/internal/utils"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+)
+
+// ErrReviewCancelled is returned by Review when the user quits
+var ErrReviewCancelled = errors.New("absorb cancelled")
+
+// reviewDecision is what the user chose for a drifted attribute or nested block
+type reviewDecision int
+
+const (
+	reviewAccept reviewDecision = iota
+	reviewReject
+	reviewIgnore
+)
+
+// Review walks through the changed attributes and nested blocks of each
+// change, shows the configured and the cloud value, and asks whether to absorb
+// them. An item can be accepted, rejected, rejected and ignored in future runs,
+// or edited to absorb a different value. It returns the accepted changes and
+// the ignore rules for the items the user chose to ignore.
+func Review(changes []DriftChange, in io.Reader, out io.Writer) ([]DriftChange, []IgnoreRule, error) {
+	reader := bufio.NewReader(in)
+
+	var accepted []DriftChange
+	var ignored []IgnoreRule
+	var rest *reviewDecision // set by "accept rest" and "reject rest"
+
+	for i, change := range changes {
+		_, _ = fmt.Fprintf(out, "\n[%d/%d] %s\n", i+1, len(changes), change.Address)
+
+		attrs := make(map[string]interface{})
+		for _, key := range utils.SortedKeys(change.ChangedAttrs) {
+			value := change.ChangedAttrs[key]
+			_, _ = fmt.Fprintf(out, "  ~ %s\n", key)
+			_, _ = fmt.Fprintf(out, "      config: %s\n", formatReviewValue(change.BeforeAttrs[key]))
+			_, _ = fmt.Fprintf(out, "      cloud:  %s\n", formatReviewValue(value))
+			if slices.Contains(change.ReplaceAttrs, key) {
+				_, _ = fmt.Fprintln(out, "      ! undoing this drift replaces the resource")
+			}
+
+			decision := reviewAccept
+			if rest != nil {
+				decision = *rest
+			} else {
+				var err error
+				decision, value, rest, err = promptReview(reader, out, value)
+				if err != nil {
+					return nil, nil, err
+				}
+			}
+
+			switch decision {
+			case reviewAccept:
+				attrs[key] = value
+			case reviewIgnore:
+				ignored = append(ignored, IgnoreRule{Address: change.Address, Attribute: key})
+			}
+		}
+
+		if len(attrs) > 0 {
+			change.ChangedAttrs = attrs
+			accepted = append(accepted, change)
+		}
+	}
+	return accepted, ignored, nil
+}
+
+// promptReview asks for the decision on one item until the answer is valid. It
+// returns the value to absorb, which differs from value if the user edited it,
+// and the decision for all remaining items if the user chose one.
+func promptReview(reader *bufio.Reader, out io.Writer, value interface{}) (reviewDecision, interface{}, *reviewDecision, error) {
+	for {
+		_, _ = fmt.Fprint(out, "  Absorb? [a]ccept, [r]eject, [i]gnore, [e]dit, [A]ccept rest, [R]eject rest, [q]uit: ")
+		answer, err := readReviewLine(reader)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+
+		switch answer {
+		case "a":
+			return reviewAccept, value, nil, nil
+		case "r":
+			return reviewReject, value, nil, nil
+		case "i":
+			return reviewIgnore, value, nil, nil
+		case "A":
+			rest := reviewAccept
+			return reviewAccept, value, &rest, nil
+		case "R":
+			rest := reviewReject
+			return reviewReject, value, &rest, nil
+		case "q":
+			return 0, nil, nil, ErrReviewCancelled
+		case "e":
+			edited, ok, err := promptEdit(reader, out)
+			if err != nil {
+				return 0, nil, nil, err
+			}
+			if ok {
+				return reviewAccept, edited, nil, nil
+			}
+		default:
+			_, _ = fmt.Fprintf(out, "  Unknown answer %q\n", answer)
+		}
+	}
+}
+
+// promptEdit reads a value as an HCL expression. An empty line goes back to
+// the previous question.
+func promptEdit(reader *bufio.Reader, out io.Writer) (interface{}, bool, error) {
+	for {
+		_, _ = fmt.Fprint(out, "  New value (HCL expression, empty to go back): ")
+		line, err := readReviewLine(reader)
+		if err != nil {
+			return nil, false, err
+		}
+		if line == "" {
+			return nil, false, nil
+		}
+
+		value, err := parseReviewValue(line)
+		if err != nil {
+			_, _ = fmt.Fprintf(out, "  Invalid value: %s\n", err)
+			continue
+		}
+		return value, true, nil
+	}
+}
+
+func readReviewLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		if err == io.EOF {
+			return "", fmt.Errorf("input ended before the review was complete")
+		}
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// parseReviewValue evaluates a constant HCL expression to the JSON-like values
+// that DriftChange uses
+func parseReviewValue(src string) (interface{}, error) {
+	expr, diags := hclsyntax.ParseExpression([]byte(src), "value", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, errors.New(diags.Error())
+	}
+	val, diags := expr.Value(nil)
+	if diags.HasErrors() {
+		return nil, errors.New(diags.Error())
+	}
+
+	data, err := ctyjson.SimpleJSONValue{Value: val}.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	var result interface{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// formatReviewValue renders a value as HCL, indenting continuation lines to
+// line up with the first one
+func formatReviewValue(value interface{}) string {
+	tokens := hclwrite.TokensForValue(utils.ToCtyValue(value))
+	text := strings.TrimSpace(string(hclwrite.Format(tokens.Bytes())))
+	return strings.ReplaceAll(text, "\n", "\n"+strings.Repeat(" ", len("      config: ")))
+}
@@ -0,0 +1,165 @@
+package absorb
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestReview(t *testing.T) {
+	changes := []DriftChange{
+		{
+			Address:      "azurerm_resource_group.main",
+			BeforeAttrs:  map[string]interface{}{"location": "westeurope", "tags": map[string]interface{}{"env": "dev"}},
+			ChangedAttrs: map[string]interface{}{"location": "eastus", "tags": map[string]interface{}{"env": "prod"}},
+		},
+		{
+			Address:      "module.network.azurerm_subnet.web",
+			BeforeAttrs:  map[string]interface{}{"service_endpoints": []interface{}{"Microsoft.Storage"}},
+			ChangedAttrs: map[string]interface{}{"service_endpoints": []interface{}{"Microsoft.Storage", "Microsoft.Sql"}},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		accepted map[string]map[string]interface{}
+		ignored  []IgnoreRule
+		err      error
+	}{
+		{
+			name:  "accept, reject and ignore",
+			input: "a\nr\ni\n",
+			accepted: map[string]map[string]interface{}{
+				"azurerm_resource_group.main": {"location": "eastus"},
+			},
+			ignored: []IgnoreRule{{Address: "module.network.azurerm_subnet.web", Attribute: "service_endpoints"}},
+		},
+		{
+			name:  "edit a value",
+			input: "x\ne\n{ env = \ne\n{ env = \"staging\" }\nr\nr\n",
+			accepted: map[string]map[string]interface{}{
+				"azurerm_resource_group.main": {"location": map[string]interface{}{"env": "staging"}},
+			},
+		},
+		{
+			name:  "go back from edit",
+			input: "e\n\na\nR\n",
+			accepted: map[string]map[string]interface{}{
+				"azurerm_resource_group.main": {"location": "eastus"},
+			},
+		},
+		{
+			name:  "accept rest",
+			input: "r\nA\n",
+			accepted: map[string]map[string]interface{}{
+				"azurerm_resource_group.main":       {"tags": map[string]interface{}{"env": "prod"}},
+				"module.network.azurerm_subnet.web": {"service_endpoints": []interface{}{"Microsoft.Storage", "Microsoft.Sql"}},
+			},
+		},
+		{
+			name:  "quit",
+			input: "a\nq\n",
+			err:   ErrReviewCancelled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			accepted, ignored, err := Review(changes, strings.NewReader(tt.input), &out)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected error %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Review failed: %v\noutput:\n%s", err, out.String())
+			}
+
+			if len(accepted) != len(tt.accepted) {
+				t.Fatalf("expected %d accepted changes, got %d: %v", len(tt.accepted), len(accepted), accepted)
+			}
+			for _, change := range accepted {
+				expected, ok := tt.accepted[change.Address]
+				if !ok {
+					t.Errorf("unexpected accepted change %s", change.Address)
+					continue
+				}
+				if !deepEqual(change.ChangedAttrs, expected) {
+					t.Errorf("%s: expected %v, got %v", change.Address, expected, change.ChangedAttrs)
+				}
+			}
+
+			if len(ignored) != len(tt.ignored) {
+				t.Fatalf("expected ignored %v, got %v", tt.ignored, ignored)
+			}
+			for i := range ignored {
+				if ignored[i] != tt.ignored[i] {
+					t.Errorf("expected ignored %v, got %v", tt.ignored[i], ignored[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReviewShowsValues(t *testing.T) {
+	changes := []DriftChange{
+		{
+			Address:      "azurerm_resource_group.main",
+			BeforeAttrs:  map[string]interface{}{"location": "westeurope"},
+			ChangedAttrs: map[string]interface{}{"location": "eastus"},
+		},
+	}
+
+	var out bytes.Buffer
+	if _, _, err := Review(changes, strings.NewReader("a\n"), &out); err != nil {
+		t.Fatalf("Review failed: %v", err)
+	}
+
+	for _, want := range []string{
+		"[1/1] azurerm_resource_group.main",
+		"  ~ location",
+		`      config: "westeurope"`,
+		`      cloud:  "eastus"`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestReviewInputEnds(t *testing.T) {
+	changes := []DriftChange{
+		{
+			Address:      "azurerm_resource_group.main",
+			ChangedAttrs: map[string]interface{}{"location": "eastus"},
+		},
+	}
+
+	_, _, err := Review(changes, strings.NewReader(""), &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "input ended") {
+		t.Fatalf("expected input ended error, got %v", err)
+	}
+}
+
+func TestReviewMarksReplacement(t *testing.T) {
+	changes := []DriftChange{
+		{
+			Address:      "azurerm_resource_group.main",
+			BeforeAttrs:  map[string]interface{}{"location": "westeurope", "tags": map[string]interface{}{}},
+			ChangedAttrs: map[string]interface{}{"location": "eastus", "tags": map[string]interface{}{"env": "prod"}},
+			ReplaceAttrs: []string{"location"},
+		},
+	}
+
+	var out bytes.Buffer
+	if _, _, err := Review(changes, strings.NewReader("a\na\n"), &out); err != nil {
+		t.Fatalf("Review failed: %v", err)
+	}
+	if count := strings.Count(out.String(), "undoing this drift replaces the resource"); count != 1 {
+		t.Errorf("expected the location to be marked once, got %d marks:\n%s", count, out.String())
+	}
+}
@@ -2,7 +2,7 @@
 # lookup() expressions with count.index as the selector and graft.source fallback.
 
 command      = "absorb"
-command_args = ["-p", "providers.json", "plan.json"]
+command_args = ["--overwrite", "-p", "providers.json", "plan.json"]
 
 expected "absorb.graft.hcl" {
   content {
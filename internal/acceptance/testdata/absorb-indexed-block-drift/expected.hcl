@@ -6,7 +6,7 @@
 #   - _graft { remove = ["security_rule"] } to replace original static blocks
 
 command      = "absorb"
-command_args = ["-p", "providers.json", "plan.json"]
+command_args = ["--overwrite", "-p", "providers.json", "plan.json"]
 
 expected "absorb.graft.hcl" {
   content {
@@ -13,7 +13,7 @@
 # so the manifest should include _graft { remove = [...] } for both.
 
 command      = "absorb"
-command_args = ["-p", "providers.json", "plan.json"]
+command_args = ["--overwrite", "-p", "providers.json", "plan.json"]
 
 expected "absorb.graft.hcl" {
   content {
@@ -23,14 +23,13 @@ expected "absorb.graft.hcl" {
 override {
   # Absorb drift for: azurerm_resource_group.test
   resource "azurerm_resource_group" "test" {
-    tags = {
+    tags = merge(graft.source, {
       Creator     = "user@example.com"
       DateCreated = "2026-02-06T14:50:54Z"
       environment = "production"
       owner       = "drifttest"
-      project     = "graft"
       team        = "platform"
-    }
+    })
   }
 
 }
@@ -59,11 +58,10 @@ module "network" {
         private_endpoint_network_policies             = "Disabled"
         private_link_service_network_policies_enabled = true
       }
-      tags = {
+      tags = merge(graft.source, {
         environment = "production"
-        layer       = "network"
         owner       = "drifttest"
-      }
+      })
       _graft {
         remove = ["subnet"]
       }
@@ -96,12 +94,11 @@ module "network" {
           source_address_prefix      = "10.0.0.0/8"
           source_port_range          = "*"
         }
-        tags = {
+        tags = merge(graft.source, {
           compliance  = "required"
           environment = "production"
-          layer       = "security"
           owner       = "drifttest"
-        }
+        })
         _graft {
           remove = ["security_rule"]
         }
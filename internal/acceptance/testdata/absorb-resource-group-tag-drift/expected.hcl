@@ -4,7 +4,7 @@
 # Azure policy also added Creator and DateCreated tags automatically.
 
 command      = "absorb"
-command_args = ["-p", "providers.json", "plan.json"]
+command_args = ["--overwrite", "-p", "providers.json", "plan.json"]
 
 expected "absorb.graft.hcl" {
   content {
@@ -14,13 +14,12 @@ expected "absorb.graft.hcl" {
 override {
   # Absorb drift for: azurerm_resource_group.test
   resource "azurerm_resource_group" "test" {
-    tags = {
+    tags = merge(graft.source, {
       Creator     = "user@example.com"
       DateCreated = "2026-02-06T13:39:05Z"
       environment = "production"
       owner       = "drifttest"
-      project     = "graft"
-    }
+    })
   }
 
 }
@@ -1,6 +1,8 @@
 package hcl
 
 import (
+	"strings"
+
 	"github.com/hashicorp/hcl/v2/hclwrite"
 	"github.com/ms-henglu/graft/internal/utils"
 )
@@ -57,3 +59,31 @@ func copyBlockContentsInternal(src, dst *hclwrite.Block) {
 		copyBlockContentsInternal(block, newBlock)
 	}
 }
+
+// additiveBlockTypes are top-level block types that have no identity of their own
+// and that Terraform does not allow in override files. They are always added
+// alongside the existing blocks and never merged with each other.
+var additiveBlockTypes = map[string]bool{
+	"moved":   true,
+	"import":  true,
+	"removed": true,
+}
+
+// IsAdditiveBlock reports whether blocks of the given type are always added
+// rather than merged (moved, import and removed blocks)
+func IsAdditiveBlock(blockType string) bool {
+	return additiveBlockTypes[blockType]
+}
+
+// ProviderAlias returns the literal alias of a provider block, or "" if the block
+// has no alias (the default provider configuration)
+func ProviderAlias(block *hclwrite.Block) string {
+	if block.Type() != "provider" {
+		return ""
+	}
+	attr := block.Body().GetAttribute("alias")
+	if attr == nil {
+		return ""
+	}
+	return strings.Trim(strings.TrimSpace(string(attr.Expr().BuildTokens(nil).Bytes())), `"`)
+}
@@ -0,0 +1,208 @@
+package manifest
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	grafthcl "github.com/ms-henglu/graft/internal/hcl"
+	"github.com/ms-henglu/graft/internal/utils"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// ConflictPolicy controls how ParseMultiple reacts when two manifest files set
+// the same attribute to different values.
+type ConflictPolicy string
+
+const (
+	// ConflictPolicyError fails the merge and reports every conflict.
+	ConflictPolicyError ConflictPolicy = "error"
+	// ConflictPolicyWarn reports every conflict and keeps the last value.
+	ConflictPolicyWarn ConflictPolicy = "warn"
+	// ConflictPolicyOverride silently keeps the last value (last write wins).
+	ConflictPolicyOverride ConflictPolicy = "override"
+)
+
+// ParseConflictPolicy validates a conflict policy name given on the command line.
+func ParseConflictPolicy(name string) (ConflictPolicy, error) {
+	switch policy := ConflictPolicy(name); policy {
+	case ConflictPolicyError, ConflictPolicyWarn, ConflictPolicyOverride:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid merge conflict policy %q: must be one of error, warn, override", name)
+	}
+}
+
+// Location identifies where an attribute is set in a manifest file.
+// Line is 0 when the position is unknown.
+type Location struct {
+	File string
+	Line int
+}
+
+func (l Location) String() string {
+	if l.Line == 0 {
+		return l.File
+	}
+	return fmt.Sprintf("%s:%d", l.File, l.Line)
+}
+
+// Conflict describes an attribute that two manifest files set to different values.
+// Address is a dotted path such as "module.vpc.resource.aws_vpc.this.tags".
+type Conflict struct {
+	Address string
+	Base    Location
+	Other   Location
+}
+
+func (c Conflict) String() string {
+	return fmt.Sprintf("%s is set in both %s and %s", c.Address, c.Base, c.Other)
+}
+
+// setting records the value an attribute was last given while merging.
+type setting struct {
+	location Location
+	value    string
+}
+
+// conflictDetector tracks attribute values across manifest files in merge order
+// and collects the attributes that a later file sets to a different value.
+type conflictDetector struct {
+	settings  map[string]setting
+	conflicts []Conflict
+}
+
+func newConflictDetector() *conflictDetector {
+	return &conflictDetector{settings: make(map[string]setting)}
+}
+
+// add records every attribute set by m. It must be called in the same order
+// the manifests are merged, so that "last write" matches mergeManifests.
+func (d *conflictDetector) add(m *Manifest) {
+	d.addBlocks(m, "", m.RootOverrides)
+	d.addModules(m, "", m.Modules)
+}
+
+func (d *conflictDetector) addModules(m *Manifest, prefix string, modules []Module) {
+	for _, mod := range modules {
+		modPrefix := prefix + "module." + mod.Name + "."
+		if mod.Source != "" {
+			d.set(m, modPrefix+"source", mod.Source)
+		}
+		if mod.Version != "" {
+			d.set(m, modPrefix+"version", mod.Version)
+		}
+		d.addBlocks(m, modPrefix, mod.OverrideBlocks)
+		d.addModules(m, modPrefix, mod.Modules)
+	}
+}
+
+func (d *conflictDetector) addBlocks(m *Manifest, prefix string, blocks []*hclwrite.Block) {
+	for _, block := range blocks {
+		if grafthcl.IsAdditiveBlock(block.Type()) {
+			// moved, import and removed blocks are never merged, so they can't conflict
+			continue
+		}
+		labels := block.Labels()
+		if alias := grafthcl.ProviderAlias(block); alias != "" {
+			labels = append(append([]string(nil), labels...), alias)
+		}
+		blockPrefix := prefix + addressKey(block.Type(), labels) + "."
+		attrs := block.Body().Attributes()
+		for _, name := range utils.SortedKeys(attrs) {
+			d.set(m, blockPrefix+name, normalizeTokens(attrs[name].Expr().BuildTokens(nil)))
+		}
+		d.addBlocks(m, blockPrefix, block.Body().Blocks())
+	}
+}
+
+func (d *conflictDetector) set(m *Manifest, address, value string) {
+	current := setting{
+		location: Location{File: m.path, Line: m.lines[address]},
+		value:    value,
+	}
+	if previous, ok := d.settings[address]; ok &&
+		previous.location.File != current.location.File &&
+		previous.value != value {
+		d.conflicts = append(d.conflicts, Conflict{
+			Address: address,
+			Base:    previous.location,
+			Other:   current.location,
+		})
+	}
+	d.settings[address] = current
+}
+
+// normalizeTokens renders an expression so that formatting differences
+// (whitespace, newlines, comments) do not count as a conflict.
+func normalizeTokens(tokens hclwrite.Tokens) string {
+	var parts []string
+	for _, token := range tokens {
+		switch token.Type {
+		case hclsyntax.TokenNewline, hclsyntax.TokenComment:
+			continue
+		}
+		parts = append(parts, string(token.Bytes))
+	}
+	return strings.Join(parts, " ")
+}
+
+// addressKey returns the address segment for a block, e.g. "resource.aws_vpc.this".
+func addressKey(blockType string, labels []string) string {
+	return strings.Join(append([]string{blockType}, labels...), ".")
+}
+
+// attributeLines maps every attribute address in a manifest to its line number.
+// The addresses use the same format as conflictDetector so that conflicts can
+// point at the exact line in each file.
+func attributeLines(data []byte, path string) map[string]int {
+	lines := make(map[string]int)
+	f, diags := hclsyntax.ParseConfig(data, path, hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return lines
+	}
+	body, ok := f.Body.(*hclsyntax.Body)
+	if !ok {
+		return lines
+	}
+	collectManifestLines(body, "", lines)
+	return lines
+}
+
+func collectManifestLines(body *hclsyntax.Body, prefix string, lines map[string]int) {
+	for _, block := range body.Blocks {
+		switch block.Type {
+		case "override":
+			collectBlockLines(block.Body.Blocks, prefix, lines)
+		case "module":
+			if len(block.Labels) == 0 {
+				continue
+			}
+			modPrefix := prefix + "module." + block.Labels[0] + "."
+			for name, attr := range block.Body.Attributes {
+				lines[modPrefix+name] = attr.SrcRange.Start.Line
+			}
+			collectManifestLines(block.Body, modPrefix, lines)
+		}
+	}
+}
+
+func collectBlockLines(blocks hclsyntax.Blocks, prefix string, lines map[string]int) {
+	for _, block := range blocks {
+		labels := block.Labels
+		if block.Type == "provider" {
+			if attr, ok := block.Body.Attributes["alias"]; ok {
+				if val, diags := attr.Expr.Value(nil); !diags.HasErrors() && val.Type() == cty.String && val.IsKnown() && !val.IsNull() {
+					labels = append(append([]string(nil), labels...), val.AsString())
+				}
+			}
+		}
+		blockPrefix := prefix + addressKey(block.Type, labels) + "."
+		for name, attr := range block.Body.Attributes {
+			lines[blockPrefix+name] = attr.SrcRange.Start.Line
+		}
+		collectBlockLines(block.Body.Blocks, blockPrefix, lines)
+	}
+}
@@ -0,0 +1,249 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeManifests(t *testing.T, files map[string]string) map[string]string {
+	t.Helper()
+	tmpDir := t.TempDir()
+	paths := make(map[string]string)
+	for name, content := range files {
+		path := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+		paths[name] = path
+	}
+	return paths
+}
+
+func TestParseConflictPolicy(t *testing.T) {
+	for _, name := range []string{"error", "warn", "override"} {
+		policy, err := ParseConflictPolicy(name)
+		if err != nil {
+			t.Errorf("ParseConflictPolicy(%q) returned error: %v", name, err)
+		}
+		if string(policy) != name {
+			t.Errorf("ParseConflictPolicy(%q) = %q", name, policy)
+		}
+	}
+
+	if _, err := ParseConflictPolicy("ignore"); err == nil {
+		t.Error("expected error for unknown policy")
+	}
+}
+
+func TestParseMultiple_ConflictPolicy(t *testing.T) {
+	paths := writeManifests(t, map[string]string{
+		"a_team.graft.hcl": `
+module "app" {
+  source = "example/app/azurerm"
+
+  override {
+    resource "azurerm_service_plan" "this" {
+      sku_name = "P1v3"
+      os_type  = "Linux"
+    }
+  }
+}
+`,
+		"b_team.graft.hcl": `
+module "app" {
+  source = "example/app/azurerm"
+
+  override {
+    resource "azurerm_service_plan" "this" {
+      os_type = "Linux" # same value, formatted differently
+
+      sku_name = "S1"
+    }
+  }
+}
+`,
+	})
+	files := []string{paths["a_team.graft.hcl"], paths["b_team.graft.hcl"]}
+
+	t.Run("error", func(t *testing.T) {
+		_, err := ParseMultiple(files, ConflictPolicyError)
+		if err == nil {
+			t.Fatal("expected conflict error")
+		}
+		msg := err.Error()
+		for _, want := range []string{
+			"module.app.resource.azurerm_service_plan.this.sku_name",
+			paths["a_team.graft.hcl"] + ":7",
+			paths["b_team.graft.hcl"] + ":9",
+		} {
+			if !strings.Contains(msg, want) {
+				t.Errorf("expected error to contain %q, got:\n%s", want, msg)
+			}
+		}
+		if strings.Contains(msg, "os_type") {
+			t.Errorf("equal values should not conflict, got:\n%s", msg)
+		}
+		if strings.Contains(msg, ".source") {
+			t.Errorf("equal module sources should not conflict, got:\n%s", msg)
+		}
+	})
+
+	for _, policy := range []ConflictPolicy{ConflictPolicyWarn, ConflictPolicyOverride} {
+		t.Run(string(policy), func(t *testing.T) {
+			m, err := ParseMultiple(files, policy)
+			if err != nil {
+				t.Fatalf("ParseMultiple failed: %v", err)
+			}
+			attr := m.Modules[0].OverrideBlocks[0].Body().GetAttribute("sku_name")
+			if attr == nil || !strings.Contains(string(attr.Expr().BuildTokens(nil).Bytes()), "S1") {
+				t.Errorf("expected last write to win for sku_name")
+			}
+		})
+	}
+}
+
+func TestParseMultiple_ModuleSourceConflict(t *testing.T) {
+	paths := writeManifests(t, map[string]string{
+		"a.graft.hcl": `
+module "vpc" {
+  source  = "terraform-aws-modules/vpc/aws"
+  version = "4.0.0"
+}
+`,
+		"b.graft.hcl": `
+module "vpc" {
+  version = "5.0.0"
+
+  module "nested" {
+    source = "./nested"
+  }
+}
+`,
+		"c.graft.hcl": `
+module "vpc" {
+  module "nested" {
+    source = "./other"
+  }
+}
+`,
+	})
+
+	_, err := ParseMultiple([]string{paths["a.graft.hcl"], paths["b.graft.hcl"], paths["c.graft.hcl"]}, ConflictPolicyError)
+	if err == nil {
+		t.Fatal("expected conflict error")
+	}
+	msg := err.Error()
+	for _, want := range []string{
+		"module.vpc.version is set in both " + paths["a.graft.hcl"] + ":4 and " + paths["b.graft.hcl"] + ":3",
+		"module.vpc.module.nested.source is set in both " + paths["b.graft.hcl"] + ":6 and " + paths["c.graft.hcl"] + ":4",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("expected error to contain %q, got:\n%s", want, msg)
+		}
+	}
+}
+
+func TestParseMultiple_RootOverrideConflict(t *testing.T) {
+	paths := writeManifests(t, map[string]string{
+		"a.graft.hcl": `
+override {
+  resource "azurerm_virtual_network" "main" {
+    subnet {
+      name = "a"
+    }
+  }
+}
+`,
+		"b.graft.hcl": `
+override {
+  resource "azurerm_virtual_network" "main" {
+    subnet {
+      name = "b"
+    }
+  }
+}
+`,
+	})
+
+	_, err := ParseMultiple([]string{paths["a.graft.hcl"], paths["b.graft.hcl"]}, ConflictPolicyError)
+	if err == nil {
+		t.Fatal("expected conflict error")
+	}
+	if want := "resource.azurerm_virtual_network.main.subnet.name"; !strings.Contains(err.Error(), want) {
+		t.Errorf("expected error to contain %q, got:\n%s", want, err.Error())
+	}
+}
+
+func TestParseMultiple_BlocksWithoutIdentity(t *testing.T) {
+	paths := writeManifests(t, map[string]string{
+		"a.graft.hcl": `
+override {
+  moved {
+    from = azurerm_subnet.a
+    to   = azurerm_subnet.b
+  }
+  provider "azurerm" {
+    alias           = "hub"
+    subscription_id = "00000000"
+  }
+}
+`,
+		"b.graft.hcl": `
+override {
+  moved {
+    from = azurerm_subnet.c
+    to   = azurerm_subnet.d
+  }
+  provider "azurerm" {
+    alias           = "spoke"
+    subscription_id = "11111111"
+  }
+}
+`,
+	})
+
+	m, err := ParseMultiple([]string{paths["a.graft.hcl"], paths["b.graft.hcl"]}, ConflictPolicyError)
+	if err != nil {
+		t.Fatalf("expected no conflicts, got: %v", err)
+	}
+	if len(m.RootOverrides) != 4 {
+		t.Errorf("expected 4 root override blocks, got %d", len(m.RootOverrides))
+	}
+}
+
+func TestParseMultiple_JSONConflictLines(t *testing.T) {
+	paths := writeManifests(t, map[string]string{
+		"a.graft.hcl": `
+override {
+  resource "azurerm_service_plan" "this" {
+    sku_name = "P1v3"
+  }
+}
+`,
+		"b.graft.json": `{
+  "override": {
+    "resource": {
+      "azurerm_service_plan": {
+        "this": {
+          "os_type": "Linux",
+          "sku_name": "S1"
+        }
+      }
+    }
+  }
+}
+`,
+	})
+
+	_, err := ParseMultiple([]string{paths["a.graft.hcl"], paths["b.graft.json"]}, ConflictPolicyError)
+	if err == nil {
+		t.Fatal("expected conflict error")
+	}
+	for _, want := range []string{paths["a.graft.hcl"] + ":4", paths["b.graft.json"] + ":7"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to contain %q, got:\n%s", want, err)
+		}
+	}
+}
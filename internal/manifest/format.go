@@ -0,0 +1,227 @@
+package manifest
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// Format returns the canonical form of a manifest written in native syntax:
+//   - hclwrite.Format is applied
+//   - at the top level and inside module blocks, override blocks come first,
+//     followed by module blocks sorted by name
+//   - blocks inside override are sorted by type and labels
+//   - _graft blocks are moved after every other attribute and block of their
+//     parent block
+//   - repeated blank lines are collapsed, and blank lines right after "{" or
+//     right before "}" are removed
+//
+// Comments attached to a block move with it, except for the comments at the
+// start of the file, which stay in place. The relative order of other nested
+// blocks (e.g. repeated subnet blocks) is preserved, since it matters for the
+// patch stage.
+func Format(src []byte, filename string) ([]byte, error) {
+	if _, diags := hclwrite.ParseConfig(src, filename, hcl.Pos{Line: 1, Column: 1}); diags.HasErrors() {
+		return nil, fmt.Errorf("failed to parse manifest: %s", diags.Error())
+	}
+
+	header, rest := splitHeader(src)
+	f, diags := hclwrite.ParseConfig(rest, filename, hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("failed to parse manifest: %s", diags.Error())
+	}
+
+	var first *hclwrite.Block
+	if blocks := f.Body().Blocks(); len(blocks) > 0 {
+		first = blocks[0]
+	}
+	formatManifestBody(f.Body())
+
+	var buf bytes.Buffer
+	buf.Write(header)
+	// Keep the blank line after the header, and add one if the header no
+	// longer sits on top of the block it was written above
+	detached := len(bytes.TrimLeft(rest, " \t")) > 0 && bytes.TrimLeft(rest, " \t")[0] == '\n'
+	if blocks := f.Body().Blocks(); len(header) > 0 && len(blocks) > 0 && (detached || blocks[0] != first) {
+		buf.WriteString("\n")
+	}
+	buf.Write(normalizeBlankLines(hclwrite.Format(f.Bytes())))
+	return normalizeBlankLines(buf.Bytes()), nil
+}
+
+// splitHeader splits the comments at the start of src from the rest of it, so
+// that they aren't taken for the comments of the first block.
+func splitHeader(src []byte) (header, rest []byte) {
+	tokens, _ := hclsyntax.LexConfig(src, "", hcl.Pos{Line: 1, Column: 1})
+	end := 0
+	for _, token := range tokens {
+		if token.Type == hclsyntax.TokenComment {
+			end = token.Range.End.Byte
+		} else if token.Type != hclsyntax.TokenNewline {
+			break
+		}
+	}
+	// A block comment can be followed by a block on the same line
+	if end == 0 || src[end-1] != '\n' {
+		return nil, src
+	}
+	return src[:end], src[end:]
+}
+
+// formatManifestBody orders the top-level body or a module body.
+func formatManifestBody(body *hclwrite.Body) {
+	blocks := body.Blocks()
+	for _, block := range blocks {
+		switch block.Type() {
+		case "override":
+			formatOverrideBody(block.Body())
+		case "module":
+			formatManifestBody(block.Body())
+		}
+	}
+
+	ordered := append([]*hclwrite.Block(nil), blocks...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		ri, rj := manifestBlockRank(ordered[i]), manifestBlockRank(ordered[j])
+		if ri != rj {
+			return ri < rj
+		}
+		if ordered[i].Type() == "module" && ordered[j].Type() == "module" {
+			return labelsKey(ordered[i]) < labelsKey(ordered[j])
+		}
+		return false
+	})
+	reorderBlocks(body, blocks, ordered)
+}
+
+func manifestBlockRank(block *hclwrite.Block) int {
+	switch block.Type() {
+	case "override":
+		return 0
+	case "module":
+		return 1
+	default:
+		return 2
+	}
+}
+
+// formatOverrideBody sorts the content blocks of an override block.
+func formatOverrideBody(body *hclwrite.Body) {
+	blocks := body.Blocks()
+	for _, block := range blocks {
+		formatContentBody(block.Body())
+	}
+
+	ordered := append([]*hclwrite.Block(nil), blocks...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if ordered[i].Type() != ordered[j].Type() {
+			return ordered[i].Type() < ordered[j].Type()
+		}
+		return labelsKey(ordered[i]) < labelsKey(ordered[j])
+	})
+	reorderBlocks(body, blocks, ordered)
+}
+
+// formatContentBody moves _graft blocks after the attributes and other blocks
+// of a content block, recursively for nested blocks.
+func formatContentBody(body *hclwrite.Body) {
+	var graftBlocks []*hclwrite.Block
+	var graftTokens hclwrite.Tokens
+	for _, block := range body.Blocks() {
+		formatContentBody(block.Body())
+		if block.Type() == "_graft" {
+			graftBlocks = append(graftBlocks, block)
+			graftTokens = append(graftTokens, block.BuildTokens(nil)...)
+		}
+	}
+
+	// Only touch the body if something follows the _graft blocks
+	if len(graftBlocks) == 0 || bytes.HasSuffix(bytes.TrimSpace(body.BuildTokens(nil).Bytes()), bytes.TrimSpace(graftTokens.Bytes())) {
+		return
+	}
+	for _, block := range graftBlocks {
+		body.RemoveBlock(block)
+	}
+	for _, block := range graftBlocks {
+		body.AppendBlock(block)
+	}
+}
+
+// reorderBlocks re-appends the blocks of body in the desired order, with a
+// blank line between them.
+func reorderBlocks(body *hclwrite.Body, current, desired []*hclwrite.Block) {
+	for _, block := range current {
+		body.RemoveBlock(block)
+	}
+	for _, block := range desired {
+		body.AppendNewline()
+		body.AppendBlock(block)
+	}
+}
+
+func labelsKey(block *hclwrite.Block) string {
+	return strings.Join(block.Labels(), "\x00")
+}
+
+// normalizeBlankLines collapses repeated blank lines and removes blank lines
+// at the start and end of a block body. Lines inside heredocs are untouched.
+func normalizeBlankLines(src []byte) []byte {
+	heredocLines := make(map[int]bool)
+	tokens, _ := hclsyntax.LexConfig(src, "", hcl.Pos{Line: 1, Column: 1})
+	start := 0
+	for _, token := range tokens {
+		switch token.Type {
+		case hclsyntax.TokenOHeredoc:
+			start = token.Range.End.Line
+		case hclsyntax.TokenCHeredoc:
+			for line := start; line < token.Range.Start.Line; line++ {
+				heredocLines[line] = true
+			}
+		}
+	}
+
+	lines := strings.Split(strings.TrimRight(string(src), "\n"), "\n")
+	var out []string
+	for i, line := range lines {
+		lineNo := i + 1
+		if heredocLines[lineNo] || strings.TrimSpace(line) != "" {
+			out = append(out, line)
+			continue
+		}
+
+		// Blank line: drop it at the start of the file, after another blank
+		// line, after an opening brace, or before a closing brace.
+		if len(out) == 0 {
+			continue
+		}
+		prev := strings.TrimSpace(out[len(out)-1])
+		if prev == "" || strings.HasSuffix(prev, "{") {
+			continue
+		}
+		next := ""
+		for _, l := range lines[i+1:] {
+			if strings.TrimSpace(l) != "" {
+				next = strings.TrimSpace(l)
+				break
+			}
+		}
+		if next == "" || strings.HasPrefix(next, "}") {
+			continue
+		}
+		out = append(out, "")
+	}
+
+	if len(out) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(strings.Join(out, "\n"))
+	buf.WriteString("\n")
+	return buf.Bytes()
+}
@@ -0,0 +1,252 @@
+package manifest
+
+import (
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name: "already formatted",
+			input: `override {
+  resource "azurerm_resource_group" "main" {
+    tags = {}
+  }
+}
+`,
+			expected: `override {
+  resource "azurerm_resource_group" "main" {
+    tags = {}
+  }
+}
+`,
+		},
+		{
+			name: "sort override blocks by type and labels",
+			input: `override {
+  resource "b" "x" {
+  }
+  # comment for data block
+  data "a" "y" {}
+
+
+  resource "a" "x" {
+    name="a"
+  }
+  locals {
+    foo = 1
+  }
+}
+`,
+			expected: `override {
+  # comment for data block
+  data "a" "y" {}
+
+  locals {
+    foo = 1
+  }
+
+  resource "a" "x" {
+    name = "a"
+  }
+
+  resource "b" "x" {
+  }
+}
+`,
+		},
+		{
+			name: "override blocks first, then modules by name",
+			input: `module "z" {
+  module "nested_b" {
+  }
+  module "nested_a" {
+  }
+  source = "z"
+}
+priority = 1
+module "a" {
+  source = "a"
+}
+override {
+}
+`,
+			expected: `priority = 1
+
+override {
+}
+
+module "a" {
+  source = "a"
+}
+
+module "z" {
+  source = "z"
+
+  module "nested_a" {
+  }
+
+  module "nested_b" {
+  }
+}
+`,
+		},
+		{
+			name: "_graft moved to end without reordering nested blocks",
+			input: `override {
+  resource "azurerm_virtual_network" "main" {
+    _graft {
+      remove = ["subnet"]
+    }
+    subnet {
+      name = "b"
+      _graft {
+        remove = ["delegation"]
+      }
+      delegation {}
+    }
+    subnet {
+      name = "a"
+    }
+  }
+}
+`,
+			expected: `override {
+  resource "azurerm_virtual_network" "main" {
+    subnet {
+      name = "b"
+      delegation {}
+      _graft {
+        remove = ["delegation"]
+      }
+    }
+    subnet {
+      name = "a"
+    }
+    _graft {
+      remove = ["subnet"]
+    }
+  }
+}
+`,
+		},
+		{
+			name: "heredoc blank lines preserved",
+			input: `override {
+
+  locals {
+    script = <<EOT
+line 1
+
+
+line 2
+EOT
+
+  }
+
+}
+`,
+			expected: `override {
+  locals {
+    script = <<EOT
+line 1
+
+
+line 2
+EOT
+  }
+}
+`,
+		},
+		{
+			name: "_graft block after attributes",
+			input: `override {
+  resource "a" "b" {
+    _graft {
+      remove = ["x"]
+    }
+    y = 2
+  }
+}
+`,
+			expected: `override {
+  resource "a" "b" {
+    y = 2
+    _graft {
+      remove = ["x"]
+    }
+  }
+}
+`,
+		},
+		{
+			name: "header comment stays at the top",
+			input: `# header comment
+module "b" {
+  override {}
+}
+
+module "a" {
+  override {}
+}
+`,
+			expected: `# header comment
+
+module "a" {
+  override {}
+}
+
+module "b" {
+  override {}
+}
+`,
+		},
+		{
+			name: "header comment above the first block",
+			input: `# header comment
+override {}
+
+module "a" {
+  override {}
+}
+`,
+			expected: `# header comment
+override {}
+
+module "a" {
+  override {}
+}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Format([]byte(tt.input), "test.graft.hcl")
+			if err != nil {
+				t.Fatalf("Format failed: %v", err)
+			}
+			if string(got) != tt.expected {
+				t.Errorf("Format() =\n%s\nexpected:\n%s", string(got), tt.expected)
+			}
+
+			again, err := Format(got, "test.graft.hcl")
+			if err != nil {
+				t.Fatalf("Format failed on formatted output: %v", err)
+			}
+			if string(again) != string(got) {
+				t.Errorf("Format is not idempotent:\n%s\nthen:\n%s", string(got), string(again))
+			}
+		})
+	}
+}
+
+func TestFormat_InvalidSyntax(t *testing.T) {
+	if _, err := Format([]byte(`override {`), "test.graft.hcl"); err == nil {
+		t.Error("expected parse error")
+	}
+}
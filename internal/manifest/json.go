@@ -0,0 +1,672 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// JSON manifests (*.graft.json) follow the HCL JSON syntax mapping used by
+// Terraform's *.tf.json files. Without a provider schema, a JSON object is
+// ambiguous (map attribute or nested block?), so graft uses these rules:
+//
+//   - At the top level and inside "module", "override" and "module" are blocks;
+//     everything else is an attribute.
+//   - Inside "override", every property is a block type. Labels are taken from
+//     nested object keys, using Terraform's label count for that block type.
+//   - Inside a resource (or any other content block), the block types listed in
+//     jsonNestedBlockLabels are blocks, and so is any property whose value is an
+//     array of objects. Everything else is an attribute. A single nested block
+//     of another type is written as a one-element array.
+//   - Strings are templates: "${expr}" becomes the expression expr.
+
+// jsonTopLevelLabels is the number of labels for block types inside "override".
+var jsonTopLevelLabels = map[string]int{
+	"resource":  2,
+	"data":      2,
+	"ephemeral": 2,
+	"module":    1,
+	"output":    1,
+	"variable":  1,
+	"provider":  1,
+	"check":     1,
+}
+
+// jsonNestedBlockLabels lists nested block types that are always blocks,
+// with their number of labels.
+var jsonNestedBlockLabels = map[string]int{
+	"_graft":             0,
+	"lifecycle":          0,
+	"connection":         0,
+	"provisioner":        1,
+	"dynamic":            1,
+	"content":            0,
+	"timeouts":           0,
+	"required_providers": 0,
+	"backend":            1,
+	"cloud":              0,
+	"validation":         0,
+	"precondition":       0,
+	"postcondition":      0,
+}
+
+// jsonBodyKind identifies how properties of a JSON object are interpreted.
+type jsonBodyKind int
+
+const (
+	jsonManifestBody jsonBodyKind = iota // top level or inside "module"
+	jsonOverrideBody                     // inside "override"
+	jsonContentBody                      // inside resource, data, etc.
+	jsonLocalsBody                       // inside "locals": attributes only
+)
+
+// jsonProperty is a key/value pair of a JSON object, kept in source order.
+// Line is the line of the key in the JSON source, or 0 if unknown.
+type jsonProperty struct {
+	Key   string
+	Value interface{}
+	Line  int
+}
+
+// jsonObject is a JSON object that preserves property order.
+type jsonObject []jsonProperty
+
+// MarshalJSON writes the properties in order.
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, p := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(p.Key)
+		if err != nil {
+			return nil, err
+		}
+		val, err := json.Marshal(p.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(val)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (o jsonObject) get(key string) (interface{}, bool) {
+	for _, p := range o {
+		if p.Key == key {
+			return p.Value, true
+		}
+	}
+	return nil, false
+}
+
+func (o jsonObject) set(key string, value interface{}) jsonObject {
+	for i, p := range o {
+		if p.Key == key {
+			o[i].Value = value
+			return o
+		}
+	}
+	return append(o, jsonProperty{Key: key, Value: value})
+}
+
+// isJSONManifest reports whether path uses the JSON manifest syntax.
+func isJSONManifest(path string) bool {
+	return strings.HasSuffix(path, ".json")
+}
+
+// isManifestFile reports whether name is a graft manifest in either syntax.
+func isManifestFile(name string) bool {
+	return strings.HasSuffix(name, ".graft.hcl") || strings.HasSuffix(name, ".graft.json")
+}
+
+// jsonToHCL converts a JSON manifest into the equivalent native syntax, so it
+// can be parsed into the same Manifest/Module structures as *.graft.hcl files.
+func jsonToHCL(data []byte, path string) ([]byte, error) {
+	out, _, err := jsonToHCLWithLines(data, path)
+	return out, err
+}
+
+// jsonToHCLWithLines is jsonToHCL that also maps the line of every attribute
+// in the native syntax to the line of its property in the JSON source.
+func jsonToHCLWithLines(data []byte, path string) ([]byte, map[int]int, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	lineAt := func(offset int64) int {
+		return bytes.Count(data[:offset], []byte("\n")) + 1
+	}
+	root, err := decodeOrderedJSON(dec, lineAt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse JSON: %w", err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, nil, fmt.Errorf("failed to parse JSON: unexpected data after top-level object")
+	}
+
+	obj, ok := root.(jsonObject)
+	if !ok {
+		return nil, nil, fmt.Errorf("top-level value must be an object")
+	}
+
+	c := &jsonConverter{path: path, lines: make(map[int]int)}
+	if err := c.writeBody(obj, jsonManifestBody); err != nil {
+		return nil, nil, err
+	}
+	// Formatting only changes spacing, so the lines stay the same
+	return hclwrite.Format(c.buf.Bytes()), c.lines, nil
+}
+
+// decodeOrderedJSON decodes the next JSON value, keeping object property order.
+// lineAt returns the line of a byte offset in the input.
+func decodeOrderedJSON(dec *json.Decoder, lineAt func(offset int64) int) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := tok.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			obj := jsonObject{}
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				key, _ := keyTok.(string)
+				line := lineAt(dec.InputOffset())
+				val, err := decodeOrderedJSON(dec, lineAt)
+				if err != nil {
+					return nil, err
+				}
+				obj = append(obj, jsonProperty{Key: key, Value: val, Line: line})
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+			return obj, nil
+		case '[':
+			arr := []interface{}{}
+			for dec.More() {
+				val, err := decodeOrderedJSON(dec, lineAt)
+				if err != nil {
+					return nil, err
+				}
+				arr = append(arr, val)
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+			return arr, nil
+		}
+		return nil, fmt.Errorf("unexpected delimiter %q", t)
+	default:
+		return t, nil
+	}
+}
+
+type jsonConverter struct {
+	path  string
+	buf   bytes.Buffer
+	lines map[int]int // line in buf => line in the JSON source
+	keys  []string    // JSON path of the property being converted
+}
+
+// jsonPath returns the JSON path of the property being converted, e.g.
+// override.resource.azurerm_subnet.main.tags
+func (c *jsonConverter) jsonPath() string {
+	return strings.Join(c.keys, ".")
+}
+
+func (c *jsonConverter) writeBody(obj jsonObject, kind jsonBodyKind) error {
+	for _, p := range obj {
+		if err := c.writeProperty(p, kind); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *jsonConverter) writeProperty(p jsonProperty, kind jsonBodyKind) error {
+	// "//" properties are comments in the HCL JSON syntax
+	if p.Key == "//" {
+		c.writeComment(p.Value)
+		return nil
+	}
+
+	c.keys = append(c.keys, p.Key)
+	defer func() { c.keys = c.keys[:len(c.keys)-1] }()
+
+	// Keys are written as bare names, labels are quoted and may be anything
+	if !hclsyntax.ValidIdentifier(p.Key) {
+		return fmt.Errorf("%s: %q at %s is not a valid attribute or block name", c.path, p.Key, c.jsonPath())
+	}
+
+	labels, isBlock := c.blockLabels(p.Key, p.Value, kind)
+	if !isBlock {
+		c.lines[bytes.Count(c.buf.Bytes(), []byte("\n"))+1] = p.Line
+		return c.writeAttribute(p.Key, p.Value)
+	}
+
+	bodyKind := jsonContentBody
+	switch {
+	case kind == jsonManifestBody && p.Key == "override":
+		bodyKind = jsonOverrideBody
+	case kind == jsonManifestBody && p.Key == "module":
+		bodyKind = jsonManifestBody
+	case kind == jsonOverrideBody && p.Key == "locals":
+		bodyKind = jsonLocalsBody
+	}
+	return c.writeBlocks(p.Key, nil, labels, p.Value, bodyKind)
+}
+
+// blockLabels decides whether a property is a block and, if so, how many
+// labels it has.
+func (c *jsonConverter) blockLabels(key string, value interface{}, kind jsonBodyKind) (int, bool) {
+	switch kind {
+	case jsonManifestBody:
+		switch key {
+		case "override":
+			return 0, true
+		case "module", "patch":
+			return 1, true
+		}
+		return 0, false
+	case jsonOverrideBody:
+		return jsonTopLevelLabels[key], true
+	case jsonContentBody:
+		if labels, ok := jsonNestedBlockLabels[key]; ok {
+			return labels, true
+		}
+		return 0, isArrayOfObjects(value)
+	}
+	return 0, false
+}
+
+// writeBlocks expands a block property. Each remaining label is one level of
+// object nesting; the innermost value is a body object or an array of them.
+func (c *jsonConverter) writeBlocks(blockType string, labels []string, remaining int, value interface{}, kind jsonBodyKind) error {
+	if remaining > 0 {
+		obj, ok := value.(jsonObject)
+		if !ok {
+			return fmt.Errorf("%s: expected an object keyed by label for block %q", c.path, blockType)
+		}
+		for _, p := range obj {
+			nextLabels := append(append([]string{}, labels...), p.Key)
+			c.keys = append(c.keys, p.Key)
+			err := c.writeBlocks(blockType, nextLabels, remaining-1, p.Value, kind)
+			c.keys = c.keys[:len(c.keys)-1]
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var bodies []jsonObject
+	switch v := value.(type) {
+	case jsonObject:
+		bodies = append(bodies, v)
+	case []interface{}:
+		for _, item := range v {
+			body, ok := item.(jsonObject)
+			if !ok {
+				return fmt.Errorf("%s: expected an array of objects for block %q", c.path, blockType)
+			}
+			bodies = append(bodies, body)
+		}
+	default:
+		return fmt.Errorf("%s: expected an object for block %q", c.path, blockType)
+	}
+
+	for _, body := range bodies {
+		// Comments at the start of a body are the comments of the block, as
+		// ConvertToJSON writes them
+		lead := 0
+		for lead < len(body) && body[lead].Key == "//" {
+			lead++
+		}
+		if lead < len(body) {
+			for _, p := range body[:lead] {
+				c.writeComment(p.Value)
+			}
+			body = body[lead:]
+		}
+
+		c.buf.WriteString(blockType)
+		for _, label := range labels {
+			c.buf.Write(hclwrite.TokensForValue(cty.StringVal(label)).Bytes())
+		}
+		c.buf.WriteString(" {\n")
+		if err := c.writeBody(body, kind); err != nil {
+			return err
+		}
+		c.buf.WriteString("}\n")
+	}
+	return nil
+}
+
+// writeComment writes the value of a "//" property as comment lines. Other
+// values are left out, since they have no use but being ignored.
+func (c *jsonConverter) writeComment(value interface{}) {
+	s, ok := value.(string)
+	if !ok {
+		return
+	}
+	for _, line := range strings.Split(s, "\n") {
+		c.buf.WriteString(strings.TrimRight("# "+line, " "))
+		c.buf.WriteString("\n")
+	}
+}
+
+func (c *jsonConverter) writeAttribute(name string, value interface{}) error {
+	c.buf.WriteString(name)
+	c.buf.WriteString(" = ")
+	if err := c.writeExpression(value); err != nil {
+		return fmt.Errorf("%s: attribute %q at %s: %w", c.path, name, c.jsonPath(), err)
+	}
+	c.buf.WriteString("\n")
+	return nil
+}
+
+func (c *jsonConverter) writeExpression(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		c.buf.WriteString("null")
+	case bool:
+		fmt.Fprintf(&c.buf, "%t", v)
+	case json.Number:
+		c.buf.WriteString(v.String())
+	case string:
+		expr, err := templateToExpression(v)
+		if err != nil {
+			return err
+		}
+		c.buf.WriteString(expr)
+	case []interface{}:
+		c.buf.WriteString("[")
+		for i, item := range v {
+			if i > 0 {
+				c.buf.WriteString(", ")
+			}
+			if err := c.writeExpression(item); err != nil {
+				return err
+			}
+		}
+		c.buf.WriteString("]")
+	case jsonObject:
+		c.buf.WriteString("{\n")
+		for _, p := range v {
+			if hclsyntax.ValidIdentifier(p.Key) {
+				c.buf.WriteString(p.Key)
+			} else {
+				c.buf.Write(hclwrite.TokensForValue(cty.StringVal(p.Key)).Bytes())
+			}
+			c.buf.WriteString(" = ")
+			if err := c.writeExpression(p.Value); err != nil {
+				return err
+			}
+			c.buf.WriteString("\n")
+		}
+		c.buf.WriteString("}")
+	default:
+		return fmt.Errorf("unsupported JSON value %v", v)
+	}
+	return nil
+}
+
+// templateToExpression converts a JSON string (a template) into native syntax.
+// A string that is a single interpolation, such as "${merge(graft.source, {})}",
+// becomes the bare expression; anything else becomes a quoted template.
+func templateToExpression(s string) (string, error) {
+	expr, diags := hclsyntax.ParseTemplate([]byte(s), "", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return "", fmt.Errorf("invalid template %q: %s", s, diags.Error())
+	}
+
+	switch e := expr.(type) {
+	case *hclsyntax.TemplateWrapExpr:
+		return sourceText(s, e.Wrapped.Range()), nil
+	case *hclsyntax.TemplateExpr:
+		var sb strings.Builder
+		sb.WriteString(`"`)
+		for _, part := range e.Parts {
+			if lit, ok := part.(*hclsyntax.LiteralValueExpr); ok && lit.Val.Type() == cty.String {
+				quoted := string(hclwrite.TokensForValue(lit.Val).Bytes())
+				sb.WriteString(quoted[1 : len(quoted)-1])
+				continue
+			}
+			text := sourceText(s, part.Range())
+			if strings.HasPrefix(text, "%{") {
+				// Template directives are copied verbatim
+				sb.WriteString(text)
+				continue
+			}
+			sb.WriteString("${" + text + "}")
+		}
+		sb.WriteString(`"`)
+		return sb.String(), nil
+	}
+	return sourceText(s, expr.Range()), nil
+}
+
+func sourceText(src string, rng hcl.Range) string {
+	return src[rng.Start.Byte:rng.End.Byte]
+}
+
+func isArrayOfObjects(value interface{}) bool {
+	arr, ok := value.([]interface{})
+	if !ok || len(arr) == 0 {
+		return false
+	}
+	for _, item := range arr {
+		if _, ok := item.(jsonObject); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// ConvertToJSON converts a manifest written in native syntax (such as the
+// output of graft absorb) into the JSON manifest syntax. Comments become "//"
+// properties, which the HCL JSON syntax ignores: those in front of a block
+// start its body, the others stay in front of the attribute they precede or at
+// the end of their body.
+func ConvertToJSON(src []byte, filename string) ([]byte, error) {
+	f, diags := hclsyntax.ParseConfig(src, filename, hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("failed to parse manifest: %s", diags.Error())
+	}
+	body, ok := f.Body.(*hclsyntax.Body)
+	if !ok {
+		return nil, fmt.Errorf("failed to parse manifest: unexpected body type")
+	}
+
+	tokens, _ := hclsyntax.LexConfig(src, filename, hcl.Pos{Line: 1, Column: 1})
+	var comments []hclsyntax.Token
+	for _, token := range tokens {
+		if token.Type == hclsyntax.TokenComment {
+			comments = append(comments, token)
+		}
+	}
+
+	obj := bodyToJSON(body, src, jsonManifestBody, comments)
+	out, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode JSON manifest: %w", err)
+	}
+	return append(out, '\n'), nil
+}
+
+func bodyToJSON(body *hclsyntax.Body, src []byte, kind jsonBodyKind, comments []hclsyntax.Token) jsonObject {
+	obj := jsonObject{}
+
+	attrs := make([]*hclsyntax.Attribute, 0, len(body.Attributes))
+	for _, attr := range body.Attributes {
+		attrs = append(attrs, attr)
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		return attrs[i].SrcRange.Start.Byte < attrs[j].SrcRange.Start.Byte
+	})
+
+	// The comments in front of an item are those after the end of the item
+	// before it, or after the start of the body
+	var items []hcl.Range
+	for _, attr := range attrs {
+		items = append(items, attr.SrcRange)
+	}
+	for _, block := range body.Blocks {
+		items = append(items, block.Range())
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Start.Byte < items[j].Start.Byte
+	})
+	commentsBefore := func(offset int) jsonObject {
+		from := body.SrcRange.Start.Byte
+		for _, item := range items {
+			if item.Start.Byte >= offset {
+				break
+			}
+			from = item.End.Byte
+		}
+		return commentsToJSON(comments, src, from, offset)
+	}
+
+	for _, attr := range attrs {
+		obj = append(obj, commentsBefore(attr.SrcRange.Start.Byte)...)
+		obj = append(obj, jsonProperty{Key: attr.Name, Value: expressionToJSON(attr.Expr, src)})
+	}
+
+	for _, block := range body.Blocks {
+		bodyKind := jsonContentBody
+		switch {
+		case kind == jsonManifestBody && block.Type == "override":
+			bodyKind = jsonOverrideBody
+		case kind == jsonManifestBody && block.Type == "module":
+			bodyKind = jsonManifestBody
+		case kind == jsonOverrideBody && block.Type == "locals":
+			bodyKind = jsonLocalsBody
+		}
+		content := append(commentsBefore(block.Range().Start.Byte), bodyToJSON(block.Body, src, bodyKind, comments)...)
+
+		// Nested blocks without a fixed label count must be arrays, so they
+		// are read back as blocks rather than object attributes.
+		_, known := jsonNestedBlockLabels[block.Type]
+		asArray := kind == jsonContentBody && !known
+
+		obj = insertBlockJSON(obj, block.Type, block.Labels, content, asArray)
+	}
+	return append(obj, commentsBefore(body.SrcRange.End.Byte)...)
+}
+
+// commentsToJSON returns a "//" property for each comment between the offsets
+// from and to.
+func commentsToJSON(comments []hclsyntax.Token, src []byte, from, to int) jsonObject {
+	var obj jsonObject
+	for _, comment := range comments {
+		if comment.Range.Start.Byte < from || comment.Range.End.Byte > to {
+			continue
+		}
+		text := strings.TrimSpace(string(comment.Bytes))
+		switch {
+		case strings.HasPrefix(text, "/*"):
+			text = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(text, "/*"), "*/"))
+		case strings.HasPrefix(text, "#"):
+			text = strings.TrimPrefix(strings.TrimPrefix(text, "#"), " ")
+		default:
+			text = strings.TrimPrefix(strings.TrimPrefix(text, "//"), " ")
+		}
+		obj = append(obj, jsonProperty{Key: "//", Value: text})
+	}
+	return obj
+}
+
+// insertBlockJSON adds a block to obj, nesting one object level per label.
+// Repeated blocks with the same labels become an array of bodies.
+func insertBlockJSON(obj jsonObject, key string, labels []string, content jsonObject, asArray bool) jsonObject {
+	existing, ok := obj.get(key)
+	if len(labels) > 0 {
+		nested, _ := existing.(jsonObject)
+		return obj.set(key, insertBlockJSON(nested, labels[0], labels[1:], content, asArray))
+	}
+
+	if !ok {
+		if asArray {
+			return obj.set(key, []interface{}{content})
+		}
+		return obj.set(key, content)
+	}
+	switch v := existing.(type) {
+	case []interface{}:
+		return obj.set(key, append(v, content))
+	default:
+		return obj.set(key, []interface{}{v, content})
+	}
+}
+
+// expressionToJSON returns a JSON value for constant expressions and a
+// "${...}" template wrapping the source text for everything else.
+func expressionToJSON(expr hclsyntax.Expression, src []byte) interface{} {
+	text := string(src[expr.Range().Start.Byte:expr.Range().End.Byte])
+
+	val, diags := expr.Value(nil)
+	if diags.HasErrors() || !val.IsWhollyKnown() {
+		return "${" + text + "}"
+	}
+	// Arrays of objects would be read back as nested blocks, so keep them
+	// as expressions to preserve attribute semantics.
+	if val.Type().IsTupleType() || val.Type().IsListType() {
+		for it := val.ElementIterator(); it.Next(); {
+			_, v := it.Element()
+			if v.Type().IsObjectType() || v.Type().IsMapType() {
+				return "${" + text + "}"
+			}
+		}
+	}
+	return ctyToJSON(val)
+}
+
+func ctyToJSON(val cty.Value) interface{} {
+	if val.IsNull() {
+		return nil
+	}
+	ty := val.Type()
+	switch {
+	case ty == cty.String:
+		// Escape template sequences so the string is read back literally
+		s := strings.ReplaceAll(val.AsString(), "${", "$${")
+		return strings.ReplaceAll(s, "%{", "%%{")
+	case ty == cty.Number:
+		return json.Number(val.AsBigFloat().Text('f', -1))
+	case ty == cty.Bool:
+		return val.True()
+	case ty.IsObjectType() || ty.IsMapType():
+		obj := jsonObject{}
+		for it := val.ElementIterator(); it.Next(); {
+			k, v := it.Element()
+			obj = append(obj, jsonProperty{Key: k.AsString(), Value: ctyToJSON(v)})
+		}
+		return obj
+	case ty.IsTupleType() || ty.IsListType() || ty.IsSetType():
+		arr := []interface{}{}
+		for it := val.ElementIterator(); it.Next(); {
+			_, v := it.Element()
+			arr = append(arr, ctyToJSON(v))
+		}
+		return arr
+	}
+	return nil
+}
@@ -0,0 +1,376 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJSONToHCL(t *testing.T) {
+	testcases := []struct {
+		name     string
+		json     string
+		expected string
+	}{
+		{
+			name: "module with resource override",
+			json: `{
+  "module": {
+    "network": {
+      "source": "Azure/network/azurerm",
+      "override": {
+        "resource": {
+          "azurerm_virtual_network": {
+            "main": {
+              "tags": "${merge(graft.source, { Owner = \"platform\" })}",
+              "address_space": ["10.0.0.0/16"]
+            }
+          }
+        }
+      }
+    }
+  }
+}`,
+			expected: `module "network" {
+  source = "Azure/network/azurerm"
+  override {
+    resource "azurerm_virtual_network" "main" {
+      tags          = merge(graft.source, { Owner = "platform" })
+      address_space = ["10.0.0.0/16"]
+    }
+  }
+}
+`,
+		},
+		{
+			name: "nested blocks, meta blocks and map attributes",
+			json: `{
+  "override": {
+    "resource": {
+      "azurerm_network_security_group": {
+        "main": {
+          "//": "comments become native comments",
+          "tags": {"Cost Center": "42", "env": "prod"},
+          "security_rule": [{"name": "a"}, {"name": "b"}],
+          "lifecycle": {"prevent_destroy": true},
+          "_graft": {"remove": ["security_rule"]},
+          "dynamic": {"subnet": {"for_each": "${var.subnets}", "content": {"name": "${subnet.value}"}}}
+        }
+      }
+    },
+    "locals": {"rules": [{"port": 22}]},
+    "moved": [{"from": "${azurerm_subnet.old}", "to": "${azurerm_subnet.new}"}]
+  }
+}`,
+			expected: `override {
+  # comments become native comments
+  resource "azurerm_network_security_group" "main" {
+    tags = {
+      "Cost Center" = "42"
+      env           = "prod"
+    }
+    security_rule {
+      name = "a"
+    }
+    security_rule {
+      name = "b"
+    }
+    lifecycle {
+      prevent_destroy = true
+    }
+    _graft {
+      remove = ["security_rule"]
+    }
+    dynamic "subnet" {
+      for_each = var.subnets
+      content {
+        name = subnet.value
+      }
+    }
+  }
+  locals {
+    rules = [{
+      port = 22
+    }]
+  }
+  moved {
+    from = azurerm_subnet.old
+    to   = azurerm_subnet.new
+  }
+}
+`,
+		},
+		{
+			name: "module with file patches",
+			json: `{
+  "module": {
+    "network": {
+      "patch": {
+        "templates/cloud-init.tpl": {
+          "replace": [{"search": "apt-get", "with": "dnf"}]
+        }
+      }
+    }
+  }
+}`,
+			expected: `module "network" {
+  patch "templates/cloud-init.tpl" {
+    replace {
+      search = "apt-get"
+      with   = "dnf"
+    }
+  }
+}
+`,
+		},
+		{
+			name:     "templates with literal text stay quoted",
+			json:     `{"override": {"output": {"name": {"value": "vnet-${var.env}-\"${var.suffix}\"", "escaped": "$${literal}"}}}}`,
+			expected: "override {\n  output \"name\" {\n    value   = \"vnet-${var.env}-\\\"${var.suffix}\\\"\"\n    escaped = \"$${literal}\"\n  }\n}\n",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := jsonToHCL([]byte(tc.json), "test.graft.json")
+			if err != nil {
+				t.Fatalf("jsonToHCL failed: %v", err)
+			}
+			if string(got) != tc.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", tc.expected, string(got))
+			}
+		})
+	}
+}
+
+func TestJSONToHCL_Errors(t *testing.T) {
+	testcases := []struct {
+		name string
+		json string
+	}{
+		{name: "invalid JSON", json: `{"override": `},
+		{name: "top-level array", json: `[]`},
+		{name: "resource without labels", json: `{"override": {"resource": {"azurerm_subnet": "x"}}}`},
+		{name: "invalid template", json: `{"override": {"locals": {"a": "${"}}}`},
+		{name: "invalid attribute name", json: `{"override": {"resource": {"azurerm_subnet": {"main": {"foo-bar baz": 1}}}}}`},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := jsonToHCL([]byte(tc.json), "test.graft.json"); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestJSONToHCL_InvalidNameReportsPath(t *testing.T) {
+	_, err := jsonToHCL([]byte(`{"override": {"resource": {"azurerm_subnet": {"main": {"foo-bar baz": 1}}}}}`), "test.graft.json")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	want := `"foo-bar baz" at override.resource.azurerm_subnet.main.foo-bar baz is not a valid attribute or block name`
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("expected error to contain %q, got: %s", want, err)
+	}
+}
+
+func TestParse_JSONManifest(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	content := `{
+  "priority": 5,
+  "module": {
+    "vpc": {
+      "override": {
+        "resource": {
+          "aws_vpc": {
+            "this": {"tags": {"Name": "test"}}
+          }
+        }
+      },
+      "module": {
+        "subnets": {
+          "override": {
+            "resource": {"aws_subnet": {"private": {"map_public_ip_on_launch": false}}}
+          }
+        }
+      }
+    }
+  }
+}`
+	filePath := filepath.Join(tmpDir, "policy.graft.json")
+	if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	m, err := Parse(filePath)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if m.Priority != 5 {
+		t.Errorf("expected priority 5, got %d", m.Priority)
+	}
+	if _, ok := m.PatchedModules["vpc"]; !ok {
+		t.Error("expected patched module 'vpc'")
+	}
+	if _, ok := m.PatchedModules["vpc.subnets"]; !ok {
+		t.Error("expected patched module 'vpc.subnets'")
+	}
+	block := m.PatchedModules["vpc"].OverrideBlocks[0]
+	if block.Type() != "resource" || strings.Join(block.Labels(), ".") != "aws_vpc.this" {
+		t.Errorf("expected resource aws_vpc.this, got %s %v", block.Type(), block.Labels())
+	}
+}
+
+func TestDiscoverManifests_JSON(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	for _, f := range []string{"a.graft.hcl", "b.graft.json", "plan.json"} {
+		if err := os.WriteFile(filepath.Join(tmpDir, f), []byte("{}"), 0644); err != nil {
+			t.Fatalf("failed to create file %s: %v", f, err)
+		}
+	}
+
+	manifests, err := DiscoverManifests(tmpDir)
+	if err != nil {
+		t.Fatalf("DiscoverManifests failed: %v", err)
+	}
+
+	expected := []string{
+		filepath.Join(tmpDir, "a.graft.hcl"),
+		filepath.Join(tmpDir, "b.graft.json"),
+	}
+	if len(manifests) != len(expected) {
+		t.Fatalf("expected %d manifests, got %d: %v", len(expected), len(manifests), manifests)
+	}
+	for i, exp := range expected {
+		if manifests[i] != exp {
+			t.Errorf("expected manifests[%d] = %s, got %s", i, exp, manifests[i])
+		}
+	}
+}
+
+func TestConvertToJSON(t *testing.T) {
+	src := `# Generated by graft absorb
+
+override {
+  # Absorb drift for: azurerm_resource_group.env[0], azurerm_resource_group.env[1]
+  resource "azurerm_resource_group" "env" {
+    tags = lookup({
+      0 = {
+        environment = "production"
+      }
+    }, count.index, graft.source)
+  }
+}
+module "network" {
+  override {
+    resource "azurerm_virtual_network" "main" {
+      subnet {
+        address_prefixes = ["10.0.1.0/24"]
+        name             = "web-subnet"
+      }
+      subnet {
+        address_prefixes = ["10.0.2.0/24"]
+        name             = "app-subnet"
+      }
+      tags = {
+        environment = "production"
+      }
+      _graft {
+        remove = ["subnet"]
+      }
+    }
+  }
+}
+`
+
+	got, err := ConvertToJSON([]byte(src), "absorb.graft.hcl")
+	if err != nil {
+		t.Fatalf("ConvertToJSON failed: %v", err)
+	}
+
+	for _, want := range []string{
+		`"tags": "${lookup({\n      0 = {\n        environment = \"production\"\n      }\n    }, count.index, graft.source)}"`,
+		`"subnet": [`,
+		`"name": "app-subnet"`,
+		`"_graft": {`,
+	} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("expected JSON to contain %q, got:\n%s", want, string(got))
+		}
+	}
+
+	// Converting back to native syntax must describe the same manifest
+	native, err := jsonToHCL(got, "absorb.graft.json")
+	if err != nil {
+		t.Fatalf("jsonToHCL failed: %v", err)
+	}
+	again, err := ConvertToJSON(native, "absorb.graft.hcl")
+	if err != nil {
+		t.Fatalf("ConvertToJSON failed: %v", err)
+	}
+	if string(again) != string(got) {
+		t.Errorf("round trip mismatch:\nfirst:\n%s\nsecond:\n%s", string(got), string(again))
+	}
+}
+
+func TestConvertToJSON_Comments(t *testing.T) {
+	src := `override {
+  # WARNING: drift in location forces replacement.
+  resource "azurerm_resource_group" "main" {
+    tags = {}
+    # In config, location refers to var.location; the cloud value below replaces it
+    location = "westus"
+  }
+  resource "azurerm_subnet" "web" {
+    # Not drifted yet
+  }
+}
+`
+
+	got, err := ConvertToJSON([]byte(src), "absorb.graft.hcl")
+	if err != nil {
+		t.Fatalf("ConvertToJSON failed: %v", err)
+	}
+
+	expected := `{
+  "override": {
+    "resource": {
+      "azurerm_resource_group": {
+        "main": {
+          "//": "WARNING: drift in location forces replacement.",
+          "tags": {},
+          "//": "In config, location refers to var.location; the cloud value below replaces it",
+          "location": "westus"
+        }
+      },
+      "azurerm_subnet": {
+        "web": {
+          "//": "Not drifted yet"
+        }
+      }
+    }
+  }
+}
+`
+	if string(got) != expected {
+		t.Errorf("unexpected JSON:\n%s\nexpected:\n%s", string(got), expected)
+	}
+
+	// The comments survive a round trip through the native syntax
+	native, err := jsonToHCL(got, "absorb.graft.json")
+	if err != nil {
+		t.Fatalf("jsonToHCL failed: %v", err)
+	}
+	again, err := ConvertToJSON(native, "absorb.graft.hcl")
+	if err != nil {
+		t.Fatalf("ConvertToJSON failed: %v", err)
+	}
+	if string(again) != string(got) {
+		t.Errorf("round trip mismatch:\nfirst:\n%s\nsecond:\n%s", string(got), string(again))
+	}
+}
@@ -2,21 +2,29 @@ package manifest
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 
 	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/hashicorp/hcl/v2/hclwrite"
 	grafthcl "github.com/ms-henglu/graft/internal/hcl"
+	"github.com/ms-henglu/graft/internal/log"
+	"github.com/zclconf/go-cty/cty/gocty"
 )
 
 // Manifest represents the structure of manifest.hcl
 type Manifest struct {
+	Priority       int               // Merge order key: manifests with a higher priority are merged later and win
 	RootOverrides  []*hclwrite.Block // Flattened content blocks (resource, data, locals, etc.)
 	Modules        []Module
 	PatchedModules map[string]Module
+
+	path  string         // file the manifest was parsed from
+	lines map[string]int // attribute address -> line number, used to report conflicts
 }
 
 // Module represents a module block in manifest.hcl
@@ -26,22 +34,48 @@ type Module struct {
 	Source         string
 	Version        string
 	OverrideBlocks []*hclwrite.Block // Flattened content blocks (resource, data, locals, etc.)
+	FilePatches    []*hclwrite.Block // patch blocks, labeled with a file path relative to the module
 	Modules        []Module
 }
 
-// Parse parses the manifest.hcl file using hclwrite
+// Parse parses the manifest.hcl file using hclwrite.
+// Files ending in .json use the HCL JSON syntax and are converted to native
+// syntax first, so both produce the same Manifest structure.
 func Parse(path string) (*Manifest, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read manifest: %w", err)
 	}
 
+	var lines map[string]int
+	if isJSONManifest(path) {
+		var jsonLines map[int]int
+		data, jsonLines, err = jsonToHCLWithLines(data, path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse manifest: %w", err)
+		}
+		// Point conflicts at the JSON source rather than the converted text
+		lines = attributeLines(data, path)
+		for address, line := range lines {
+			lines[address] = jsonLines[line]
+		}
+	} else {
+		lines = attributeLines(data, path)
+	}
+
 	f, diags := hclwrite.ParseConfig(data, path, hcl.Pos{Line: 1, Column: 1})
 	if diags.HasErrors() {
 		return nil, fmt.Errorf("failed to parse manifest: %s", diags.Error())
 	}
 
-	m := &Manifest{}
+	m := &Manifest{
+		path:  path,
+		lines: lines,
+	}
+	m.Priority, err = parsePriority(f.Body())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
 	m.RootOverrides = flattenOverrideBlocks(grafthcl.BlocksByType(f.Body(), "override"))
 	m.Modules = parseModules(f.Body())
 
@@ -51,6 +85,31 @@ func Parse(path string) (*Manifest, error) {
 	return m, nil
 }
 
+// parsePriority reads the optional top-level "priority" attribute, which must be
+// a whole number. Manifests without a priority default to 0.
+func parsePriority(body *hclwrite.Body) (int, error) {
+	attr := body.GetAttribute("priority")
+	if attr == nil {
+		return 0, nil
+	}
+
+	exprBytes := attr.Expr().BuildTokens(nil).Bytes()
+	expr, diags := hclsyntax.ParseExpression(exprBytes, "priority", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return 0, fmt.Errorf("invalid priority: %s", diags.Error())
+	}
+	val, diags := expr.Value(nil)
+	if diags.HasErrors() {
+		return 0, fmt.Errorf("invalid priority: %s", diags.Error())
+	}
+
+	var priority int
+	if err := gocty.FromCtyValue(val, &priority); err != nil {
+		return 0, fmt.Errorf("invalid priority: must be a whole number")
+	}
+	return priority, nil
+}
+
 func parseModules(body *hclwrite.Body) []Module {
 	var modules []Module
 	for _, block := range body.Blocks() {
@@ -80,6 +139,7 @@ func parseModules(body *hclwrite.Body) []Module {
 			Source:         source,
 			Version:        version,
 			OverrideBlocks: flattenOverrideBlocks(grafthcl.BlocksByType(block.Body(), "override")),
+			FilePatches:    grafthcl.BlocksByType(block.Body(), "patch"),
 			Modules:        parseModules(block.Body()),
 		}
 		modules = append(modules, mod)
@@ -95,8 +155,8 @@ func collectPatchedModules(modules []Module, parentKey string, patched map[strin
 			currentKey = parentKey + "." + mod.Name
 		}
 
-		// Check if this module has overrides
-		if len(mod.OverrideBlocks) > 0 {
+		// Check if this module has overrides or file patches
+		if len(mod.OverrideBlocks) > 0 || len(mod.FilePatches) > 0 {
 			patched[currentKey] = mod
 		}
 
@@ -115,13 +175,30 @@ func flattenOverrideBlocks(overrideBlocks []*hclwrite.Block) []*hclwrite.Block {
 	return result
 }
 
-// DiscoverManifests finds all *.graft.hcl files in the given directory
-// and returns them sorted alphabetically
+// ManifestDir is the conventional directory for organizing manifests in
+// subfolders (e.g. one per team). It is searched recursively by DiscoverManifests.
+const ManifestDir = "graft.d"
+
+// DiscoverManifests finds all *.graft.hcl and *.graft.json files in the given
+// directory, plus any manifests nested under its graft.d directory, and returns
+// them sorted alphabetically by path
 func DiscoverManifests(dir string) ([]string, error) {
-	pattern := filepath.Join(dir, "*.graft.hcl")
-	matches, err := filepath.Glob(pattern)
-	if err != nil {
-		return nil, fmt.Errorf("failed to glob manifest files: %w", err)
+	var matches []string
+	for _, pattern := range []string{"*.graft.hcl", "*.graft.json"} {
+		found, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("failed to glob manifest files: %w", err)
+		}
+		matches = append(matches, found...)
+	}
+
+	manifestDir := filepath.Join(dir, ManifestDir)
+	if info, err := os.Stat(manifestDir); err == nil && info.IsDir() {
+		nested, err := DiscoverManifestsRecursive(manifestDir)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, nested...)
 	}
 
 	if len(matches) == 0 {
@@ -133,28 +210,78 @@ func DiscoverManifests(dir string) ([]string, error) {
 	return matches, nil
 }
 
+// DiscoverManifestsRecursive finds all *.graft.hcl and *.graft.json files under dir, including
+// subdirectories, and returns them sorted alphabetically by path.
+// Hidden directories (such as .terraform and .graft) are skipped.
+func DiscoverManifestsRecursive(dir string) ([]string, error) {
+	var matches []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isManifestFile(d.Name()) {
+			matches = append(matches, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover manifest files in %s: %w", dir, err)
+	}
+
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	sort.Strings(matches)
+	return matches, nil
+}
+
 // ParseMultiple parses multiple manifest files and merges them using deep merge logic.
-// Files are processed in the order provided (should be alphabetically sorted).
+// Files are processed in ascending priority order; files with the same priority
+// keep the order provided (should be alphabetically sorted).
 // For modules with the same name, their contents are merged:
 // - override blocks are combined
 // - nested modules are merged recursively
 // - attributes use "last write wins" semantics
-func ParseMultiple(paths []string) (*Manifest, error) {
+//
+// When two files set the same attribute (or module source/version) to different
+// values, policy decides whether the merge fails, warns, or silently continues.
+func ParseMultiple(paths []string, policy ConflictPolicy) (*Manifest, error) {
 	if len(paths) == 0 {
 		return nil, fmt.Errorf("no manifest files provided")
 	}
 
-	merged := &Manifest{}
-	// Merge subsequent files
+	var manifests []*Manifest
 	for _, path := range paths {
 		other, err := Parse(path)
 		if err != nil {
 			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
 		}
+		manifests = append(manifests, other)
+	}
+
+	sort.SliceStable(manifests, func(i, j int) bool {
+		return manifests[i].Priority < manifests[j].Priority
+	})
 
+	merged := &Manifest{}
+	detector := newConflictDetector()
+	// Merge subsequent files
+	for _, other := range manifests {
+		detector.add(other)
 		merged = mergeManifests(merged, other)
 	}
 
+	if err := reportConflicts(detector.conflicts, policy); err != nil {
+		return nil, err
+	}
+
 	// Rebuild PatchedModules map after merge
 	merged.PatchedModules = make(map[string]Module)
 	collectPatchedModules(merged.Modules, "", merged.PatchedModules)
@@ -162,6 +289,26 @@ func ParseMultiple(paths []string) (*Manifest, error) {
 	return merged, nil
 }
 
+// reportConflicts applies the conflict policy to the conflicts found while merging.
+func reportConflicts(conflicts []Conflict, policy ConflictPolicy) error {
+	if len(conflicts) == 0 || policy == ConflictPolicyOverride {
+		return nil
+	}
+
+	if policy == ConflictPolicyError {
+		var lines []string
+		for _, c := range conflicts {
+			lines = append(lines, "  - "+c.String())
+		}
+		return fmt.Errorf("found %d conflicting value(s) across manifests:\n%s", len(conflicts), strings.Join(lines, "\n"))
+	}
+
+	for _, c := range conflicts {
+		log.Warn(fmt.Sprintf("Conflicting value for %s: %s overrides %s", c.Address, c.Other, c.Base))
+	}
+	return nil
+}
+
 // mergeManifests merges two manifests using deep merge logic
 func mergeManifests(base, other *Manifest) *Manifest {
 	result := &Manifest{
@@ -98,7 +98,7 @@ module "vpc" {
 		t.Fatalf("failed to write file: %v", err)
 	}
 
-	m, err := ParseMultiple([]string{filePath})
+	m, err := ParseMultiple([]string{filePath}, ConflictPolicyOverride)
 	if err != nil {
 		t.Fatalf("ParseMultiple failed: %v", err)
 	}
@@ -156,7 +156,7 @@ module "vpc" {
 		t.Fatalf("failed to write file B: %v", err)
 	}
 
-	m, err := ParseMultiple([]string{fileA, fileB})
+	m, err := ParseMultiple([]string{fileA, fileB}, ConflictPolicyOverride)
 	if err != nil {
 		t.Fatalf("ParseMultiple failed: %v", err)
 	}
@@ -177,6 +177,63 @@ module "vpc" {
 	}
 }
 
+func TestParseMultiple_FilePatches(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	patch := `
+  patch "templates/cloud-init.tpl" {
+    replace {
+      search = "apt-get"
+      with   = "dnf"
+    }
+  }
+`
+	contentA := `
+module "vpc" {
+  source = "terraform-aws-modules/vpc/aws"
+` + patch + `}
+`
+	contentB := `
+module "vpc" {
+` + patch + `
+  patch "scripts/setup.sh" {
+    replace {
+      search = "bash"
+      with   = "sh"
+    }
+  }
+}
+`
+	fileA := filepath.Join(tmpDir, "a.graft.hcl")
+	fileB := filepath.Join(tmpDir, "b.graft.hcl")
+	if err := os.WriteFile(fileA, []byte(contentA), 0644); err != nil {
+		t.Fatalf("failed to write file A: %v", err)
+	}
+	if err := os.WriteFile(fileB, []byte(contentB), 0644); err != nil {
+		t.Fatalf("failed to write file B: %v", err)
+	}
+
+	m, err := ParseMultiple([]string{fileA, fileB}, ConflictPolicyOverride)
+	if err != nil {
+		t.Fatalf("ParseMultiple failed: %v", err)
+	}
+
+	// A module with only file patches still needs to be vendored and patched
+	mod, ok := m.PatchedModules["vpc"]
+	if !ok {
+		t.Fatalf("expected vpc to be a patched module")
+	}
+
+	// The patch that appears in both manifests is applied once
+	var paths []string
+	for _, block := range mod.FilePatches {
+		paths = append(paths, block.Labels()[0])
+	}
+	if strings.Join(paths, ",") != "templates/cloud-init.tpl,scripts/setup.sh" {
+		t.Errorf("unexpected file patches: %v", paths)
+	}
+}
+
 func TestParseMultiple_LastWriteWins(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "graft-test")
 	if err != nil {
@@ -207,7 +264,7 @@ module "vpc" {
 		t.Fatalf("failed to write file B: %v", err)
 	}
 
-	m, err := ParseMultiple([]string{fileA, fileB})
+	m, err := ParseMultiple([]string{fileA, fileB}, ConflictPolicyOverride)
 	if err != nil {
 		t.Fatalf("ParseMultiple failed: %v", err)
 	}
@@ -260,7 +317,7 @@ module "security" {
 		t.Fatalf("failed to write file B: %v", err)
 	}
 
-	m, err := ParseMultiple([]string{fileA, fileB})
+	m, err := ParseMultiple([]string{fileA, fileB}, ConflictPolicyOverride)
 	if err != nil {
 		t.Fatalf("ParseMultiple failed: %v", err)
 	}
@@ -327,7 +384,7 @@ module "parent" {
 		t.Fatalf("failed to write file B: %v", err)
 	}
 
-	m, err := ParseMultiple([]string{fileA, fileB})
+	m, err := ParseMultiple([]string{fileA, fileB}, ConflictPolicyOverride)
 	if err != nil {
 		t.Fatalf("ParseMultiple failed: %v", err)
 	}
@@ -355,3 +412,132 @@ module "parent" {
 		t.Error("expected 'child2' nested module to be present")
 	}
 }
+
+func TestDiscoverManifests_ManifestDir(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	files := []string{
+		"root.graft.hcl",
+		filepath.Join("graft.d", "network", "vnet.graft.hcl"),
+		filepath.Join("graft.d", "security", "nsg.graft.hcl"),
+		filepath.Join("graft.d", "security", "readme.md"),
+		filepath.Join("other", "ignored.graft.hcl"),
+	}
+	for _, f := range files {
+		path := filepath.Join(tmpDir, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create dir for %s: %v", f, err)
+		}
+		if err := os.WriteFile(path, []byte(""), 0644); err != nil {
+			t.Fatalf("failed to create file %s: %v", f, err)
+		}
+	}
+
+	manifests, err := DiscoverManifests(tmpDir)
+	if err != nil {
+		t.Fatalf("DiscoverManifests failed: %v", err)
+	}
+
+	expected := []string{
+		filepath.Join(tmpDir, "graft.d", "network", "vnet.graft.hcl"),
+		filepath.Join(tmpDir, "graft.d", "security", "nsg.graft.hcl"),
+		filepath.Join(tmpDir, "root.graft.hcl"),
+	}
+	if len(manifests) != len(expected) {
+		t.Fatalf("expected %d manifests, got %d: %v", len(expected), len(manifests), manifests)
+	}
+	for i, exp := range expected {
+		if manifests[i] != exp {
+			t.Errorf("expected manifests[%d] = %s, got %s", i, exp, manifests[i])
+		}
+	}
+}
+
+func TestDiscoverManifestsRecursive(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	files := []string{
+		filepath.Join("team-a", "a.graft.hcl"),
+		filepath.Join("team-b", "nested", "b.graft.hcl"),
+		filepath.Join(".terraform", "modules", "vendored.graft.hcl"),
+		filepath.Join(".graft", "build", "vendored.graft.hcl"),
+		"main.tf",
+	}
+	for _, f := range files {
+		path := filepath.Join(tmpDir, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create dir for %s: %v", f, err)
+		}
+		if err := os.WriteFile(path, []byte(""), 0644); err != nil {
+			t.Fatalf("failed to create file %s: %v", f, err)
+		}
+	}
+
+	manifests, err := DiscoverManifestsRecursive(tmpDir)
+	if err != nil {
+		t.Fatalf("DiscoverManifestsRecursive failed: %v", err)
+	}
+
+	expected := []string{
+		filepath.Join(tmpDir, "team-a", "a.graft.hcl"),
+		filepath.Join(tmpDir, "team-b", "nested", "b.graft.hcl"),
+	}
+	if len(manifests) != len(expected) {
+		t.Fatalf("expected %d manifests, got %d: %v", len(expected), len(manifests), manifests)
+	}
+	for i, exp := range expected {
+		if manifests[i] != exp {
+			t.Errorf("expected manifests[%d] = %s, got %s", i, exp, manifests[i])
+		}
+	}
+}
+
+func TestParseMultiple_Priority(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// Alphabetically first, but merged last because of its higher priority
+	contentA := `
+priority = 10
+
+module "vpc" {
+  version = "5.0.0"
+}
+`
+	fileA := filepath.Join(tmpDir, "a_platform.graft.hcl")
+	if err := os.WriteFile(fileA, []byte(contentA), 0644); err != nil {
+		t.Fatalf("failed to write file A: %v", err)
+	}
+
+	contentB := `
+module "vpc" {
+  source  = "terraform-aws-modules/vpc/aws"
+  version = "4.0.0"
+}
+`
+	fileB := filepath.Join(tmpDir, "b_team.graft.hcl")
+	if err := os.WriteFile(fileB, []byte(contentB), 0644); err != nil {
+		t.Fatalf("failed to write file B: %v", err)
+	}
+
+	m, err := ParseMultiple([]string{fileA, fileB}, ConflictPolicyOverride)
+	if err != nil {
+		t.Fatalf("ParseMultiple failed: %v", err)
+	}
+
+	if !strings.Contains(m.Modules[0].Version, "5.0.0") {
+		t.Errorf("expected version from higher priority manifest '5.0.0', got '%s'", m.Modules[0].Version)
+	}
+}
+
+func TestParse_InvalidPriority(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	filePath := filepath.Join(tmpDir, "manifest.graft.hcl")
+	if err := os.WriteFile(filePath, []byte(`priority = "high"`), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	if _, err := Parse(filePath); err == nil || !strings.Contains(err.Error(), "invalid priority") {
+		t.Errorf("expected invalid priority error, got %v", err)
+	}
+}
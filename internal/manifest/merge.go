@@ -47,6 +47,7 @@ func mergeModules(base, other Module) Module {
 		Source:         base.Source,
 		Version:        base.Version,
 		OverrideBlocks: mergeOverrideBlocks(base.OverrideBlocks, other.OverrideBlocks),
+		FilePatches:    mergeFilePatches(base.FilePatches, other.FilePatches),
 		Modules:        mergeModuleLists(base.Modules, other.Modules),
 	}
 
@@ -61,6 +62,25 @@ func mergeModules(base, other Module) Module {
 	return result
 }
 
+// mergeFilePatches appends the patch blocks of other to base. Patches of the
+// same file are applied in order, so an identical patch that appears in both
+// lists is kept once instead of failing on the second application.
+func mergeFilePatches(base, other []*hclwrite.Block) []*hclwrite.Block {
+	result := append([]*hclwrite.Block(nil), base...)
+	seen := make(map[string]bool)
+	for _, block := range base {
+		seen[string(hclwrite.Format(block.BuildTokens(nil).Bytes()))] = true
+	}
+	for _, block := range other {
+		key := string(hclwrite.Format(block.BuildTokens(nil).Bytes()))
+		if !seen[key] {
+			seen[key] = true
+			result = append(result, block)
+		}
+	}
+	return result
+}
+
 // mergeOverrideBlocks merges two lists of flattened content blocks (resource, data, locals, etc.)
 // Blocks with the same type and labels are deep merged using "last write wins" semantics.
 func mergeOverrideBlocks(base, other []*hclwrite.Block) []*hclwrite.Block {
@@ -77,15 +97,19 @@ func mergeOverrideBlocks(base, other []*hclwrite.Block) []*hclwrite.Block {
 
 	// Process base blocks
 	for _, block := range base {
-		key := blockKey(block)
+		key := mergeKey(block)
 		blockMap[key] = block
 		blockOrder = append(blockOrder, key)
 	}
 
 	// Merge other blocks
 	for _, block := range other {
-		key := blockKey(block)
+		key := mergeKey(block)
 		if existing, ok := blockMap[key]; ok {
+			if hcl.IsAdditiveBlock(block.Type()) {
+				// Exact duplicate of a moved, import or removed block
+				continue
+			}
 			// Merge blocks with the same type and labels
 			blockMap[key] = mergeBlocks(existing, block)
 		} else {
@@ -103,9 +127,25 @@ func mergeOverrideBlocks(base, other []*hclwrite.Block) []*hclwrite.Block {
 	return result
 }
 
-// blockKey generates a unique key for a block based on type and labels
+// blockKey generates a unique key for a block based on type and labels.
+// Provider blocks also include their alias.
 func blockKey(block *hclwrite.Block) string {
-	return block.Type() + ":" + strings.Join(block.Labels(), ".")
+	key := block.Type() + ":" + strings.Join(block.Labels(), ".")
+	if alias := hcl.ProviderAlias(block); alias != "" {
+		key += "." + alias
+	}
+	return key
+}
+
+// mergeKey is the key under which top-level blocks are merged. moved, import and
+// removed blocks have no identity, so they are keyed by their content and only
+// exact duplicates are collapsed.
+func mergeKey(block *hclwrite.Block) string {
+	key := blockKey(block)
+	if hcl.IsAdditiveBlock(block.Type()) {
+		key += ":" + normalizeTokens(block.Body().BuildTokens(nil))
+	}
+	return key
 }
 
 // mergeBlocks merges two blocks with the same type and labels
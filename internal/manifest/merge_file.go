@@ -0,0 +1,298 @@
+package manifest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	grafthcl "github.com/ms-henglu/graft/internal/hcl"
+	"github.com/ms-henglu/graft/internal/utils"
+)
+
+// MergeChange lists the attributes and nested blocks that MergeInto added to
+// or updated in one content block, e.g. "module.network.azurerm_subnet.web".
+type MergeChange struct {
+	Address string
+	Added   []string
+	Updated []string
+}
+
+// MergeInto merges the override and module blocks of src, a manifest in native
+// syntax such as the output of graft absorb, into the existing manifest dst.
+// Content blocks are merged like mergeOverrideBlocks does: blocks with the same
+// type and labels are deep merged and src wins for attributes. Unlike
+// mergeBlocks, dst is edited in place, so its comments, hand edits and
+// anything src doesn't mention are kept. Repeated nested blocks of one type
+// can't be matched up, so src replaces them as a whole. The _graft remove and
+// lifecycle ignore_changes lists are combined.
+//
+// dstFilename decides the syntax of dst and of the result, so a JSON manifest
+// stays JSON, with its comments as "//" properties like ConvertToJSON writes.
+func MergeInto(dst []byte, dstFilename string, src []byte) ([]byte, []MergeChange, error) {
+	if isJSONManifest(dstFilename) {
+		converted, err := jsonToHCL(dst, dstFilename)
+		if err != nil {
+			return nil, nil, err
+		}
+		dst = converted
+	}
+
+	dstFile, diags := hclwrite.ParseConfig(dst, dstFilename, hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, nil, fmt.Errorf("failed to parse %s: %s", dstFilename, diags.Error())
+	}
+	srcFile, diags := hclwrite.ParseConfig(src, "absorb.graft.hcl", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, nil, fmt.Errorf("failed to parse manifest: %s", diags.Error())
+	}
+
+	var changes []MergeChange
+	mergeManifestBodies(dstFile.Body(), srcFile.Body(), "", &changes)
+
+	out := hclwrite.Format(dstFile.Bytes())
+	if isJSONManifest(dstFilename) {
+		out, err := ConvertToJSON(out, dstFilename)
+		return out, changes, err
+	}
+	return normalizeBlankLines(out), changes, nil
+}
+
+// mergeManifestBodies merges the top-level body or a module body
+func mergeManifestBodies(dst, src *hclwrite.Body, prefix string, changes *[]MergeChange) {
+	for _, block := range src.Blocks() {
+		switch block.Type() {
+		case "override":
+			existing := dst.FirstMatchingBlock("override", nil)
+			if existing == nil {
+				existing = appendBlock(dst, hclwrite.NewBlock("override", nil))
+			}
+			mergeOverrideBodies(existing.Body(), block.Body(), prefix, changes)
+		case "module":
+			existing := dst.FirstMatchingBlock("module", block.Labels())
+			if existing == nil {
+				existing = appendBlock(dst, hclwrite.NewBlock("module", block.Labels()))
+			}
+			mergeManifestBodies(existing.Body(), block.Body(), prefix+"module."+block.Labels()[0]+".", changes)
+		}
+	}
+}
+
+// mergeOverrideBodies merges the content blocks of two override blocks
+func mergeOverrideBodies(dst, src *hclwrite.Body, prefix string, changes *[]MergeChange) {
+	existing := make(map[string]*hclwrite.Block)
+	for _, block := range dst.Blocks() {
+		existing[mergeKey(block)] = block
+	}
+
+	for _, block := range src.Blocks() {
+		change := MergeChange{Address: prefix + contentAddress(block)}
+		if match, ok := existing[mergeKey(block)]; ok {
+			if grafthcl.IsAdditiveBlock(block.Type()) {
+				// Exact duplicate of a moved, import or removed block
+				continue
+			}
+			mergeContentBodies(match.Body(), block.Body(), "", &change)
+			formatContentBody(match.Body())
+		} else {
+			change.Added = bodyItems(block.Body())
+			// The block keeps the comments in front of it, e.g. "# Absorb drift for: ..."
+			appendBlock(dst, block)
+		}
+
+		if len(change.Added) > 0 || len(change.Updated) > 0 {
+			*changes = append(*changes, change)
+		}
+	}
+}
+
+// mergeContentBodies merges the attributes and nested blocks of src into dst,
+// recording the paths it adds or updates
+func mergeContentBodies(dst, src *hclwrite.Body, path string, change *MergeChange) {
+	for _, name := range utils.SortedKeys(src.Attributes()) {
+		mergeAttribute(dst, name, src.Attributes()[name].Expr().BuildTokens(nil), path, change)
+	}
+
+	srcGroups, keys := groupBlocks(src.Blocks())
+	dstGroups, _ := groupBlocks(dst.Blocks())
+	for _, key := range keys {
+		srcBlocks, dstBlocks := srcGroups[key], dstGroups[key]
+		name := path + srcBlocks[0].Type()
+
+		switch {
+		case combinedLists[srcBlocks[0].Type()] != "" && len(srcBlocks) == 1 && len(dstBlocks) == 1:
+			mergeListBodies(dstBlocks[0].Body(), srcBlocks[0].Body(), name+".", combinedLists[srcBlocks[0].Type()], change)
+		case len(srcBlocks) == 1 && len(dstBlocks) == 1:
+			mergeContentBodies(dstBlocks[0].Body(), srcBlocks[0].Body(), name+".", change)
+		default:
+			for _, block := range dstBlocks {
+				dst.RemoveBlock(block)
+			}
+			for _, block := range srcBlocks {
+				dst.AppendBlock(block)
+			}
+			if len(dstBlocks) > 0 {
+				change.Updated = append(change.Updated, name)
+			} else {
+				change.Added = append(change.Added, name)
+			}
+		}
+	}
+}
+
+// combinedLists are list attributes whose elements are combined rather than
+// replaced, keyed by the type of the block that contains them
+var combinedLists = map[string]string{
+	"_graft":    "remove",
+	"lifecycle": "ignore_changes",
+}
+
+// mergeListBodies merges two blocks like mergeContentBodies, except that the
+// elements of the combined list attribute are appended to the existing ones
+func mergeListBodies(dst, src *hclwrite.Body, path, listName string, change *MergeChange) {
+	for _, name := range utils.SortedKeys(src.Attributes()) {
+		tokens := src.Attributes()[name].Expr().BuildTokens(nil)
+		if current, ok := dst.Attributes()[name]; ok && name == listName {
+			if merged, ok := mergeLists(current.Expr().BuildTokens(nil), tokens); ok {
+				tokens = merged
+			}
+		}
+		mergeAttribute(dst, name, tokens, path, change)
+	}
+}
+
+// mergeAttribute sets an attribute unless dst already has the same expression
+func mergeAttribute(dst *hclwrite.Body, name string, tokens hclwrite.Tokens, path string, change *MergeChange) {
+	current, ok := dst.Attributes()[name]
+	if ok && normalizeTokens(current.Expr().BuildTokens(nil)) == normalizeTokens(tokens) {
+		return
+	}
+	dst.SetAttributeRaw(name, tokens)
+	if ok {
+		change.Updated = append(change.Updated, path+name)
+	} else {
+		change.Added = append(change.Added, path+name)
+	}
+}
+
+// mergeLists appends the elements of other that base doesn't have yet,
+// returning base unchanged if there are none. Both may be list literals or
+// concat(graft.source, [...]), as absorb writes for ignore_changes. It returns
+// false if either is another expression.
+func mergeLists(base, other hclwrite.Tokens) (hclwrite.Tokens, bool) {
+	baseItems, baseSource, ok := listItems(base)
+	if !ok {
+		return nil, false
+	}
+	otherItems, otherSource, ok := listItems(other)
+	if !ok {
+		return nil, false
+	}
+
+	seen := make(map[string]bool)
+	for _, item := range baseItems {
+		seen[item] = true
+	}
+	merged := baseItems
+	for _, item := range otherItems {
+		if !seen[item] {
+			seen[item] = true
+			merged = append(merged, item)
+		}
+	}
+	if len(merged) == len(baseItems) && baseSource == otherSource {
+		return base, true
+	}
+
+	list := "[" + strings.Join(merged, ", ") + "]"
+	if baseSource || otherSource {
+		list = "concat(graft.source, " + list + ")"
+	}
+	f, diags := hclwrite.ParseConfig([]byte("list = "+list+"\n"), "", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, false
+	}
+	return f.Body().GetAttribute("list").Expr().BuildTokens(nil), true
+}
+
+// listItems returns the source text of the elements of a list literal or of a
+// concat() of list literals, and whether the concat() includes graft.source
+func listItems(tokens hclwrite.Tokens) ([]string, bool, bool) {
+	src := tokens.Bytes()
+	expr, diags := hclsyntax.ParseExpression(src, "", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, false, false
+	}
+
+	args := []hclsyntax.Expression{expr}
+	if call, ok := expr.(*hclsyntax.FunctionCallExpr); ok && call.Name == "concat" {
+		args = call.Args
+	}
+
+	var items []string
+	var source bool
+	for _, arg := range args {
+		switch a := arg.(type) {
+		case *hclsyntax.TupleConsExpr:
+			for _, elem := range a.Exprs {
+				items = append(items, string(elem.Range().SliceBytes(src)))
+			}
+		case *hclsyntax.ScopeTraversalExpr:
+			if string(a.Range().SliceBytes(src)) != "graft.source" {
+				return nil, false, false
+			}
+			source = true
+		default:
+			return nil, false, false
+		}
+	}
+	return items, source, true
+}
+
+// groupBlocks groups blocks by blockKey, returning the keys in source order
+func groupBlocks(blocks []*hclwrite.Block) (map[string][]*hclwrite.Block, []string) {
+	groups := make(map[string][]*hclwrite.Block)
+	var keys []string
+	for _, block := range blocks {
+		key := blockKey(block)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], block)
+	}
+	return groups, keys
+}
+
+// bodyItems returns the sorted names of the attributes and nested block types
+// of a body
+func bodyItems(body *hclwrite.Body) []string {
+	items := utils.SortedKeys(body.Attributes())
+	seen := make(map[string]bool)
+	for _, block := range body.Blocks() {
+		if !seen[block.Type()] {
+			seen[block.Type()] = true
+			items = append(items, block.Type())
+		}
+	}
+	sort.Strings(items)
+	return items
+}
+
+// contentAddress returns the address of a content block as Terraform writes it,
+// e.g. "azurerm_subnet.web" or "data.azurerm_client_config.current"
+func contentAddress(block *hclwrite.Block) string {
+	if block.Type() == "resource" {
+		return strings.Join(block.Labels(), ".")
+	}
+	return strings.Join(append([]string{block.Type()}, block.Labels()...), ".")
+}
+
+// appendBlock appends a block to body, separated by a blank line
+func appendBlock(body *hclwrite.Body, block *hclwrite.Block) *hclwrite.Block {
+	if len(body.BuildTokens(nil)) > 0 {
+		body.AppendNewline()
+	}
+	return body.AppendBlock(block)
+}
@@ -0,0 +1,342 @@
+package manifest
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergeInto(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		existing string
+		absorbed string
+		expected string
+		changes  []MergeChange
+	}{
+		{
+			name:     "keeps comments and hand edits",
+			filename: "absorb.graft.hcl",
+			existing: `# Generated by graft absorb
+# This manifest contains overrides to match the current remote state
+
+module "network" {
+  override {
+    # Absorb drift for: module.network.azurerm_virtual_network.vnet
+    resource "azurerm_virtual_network" "vnet" {
+      # Changed in the portal, see INC-1234
+      dns_servers = ["10.0.0.4"]
+      tags = {
+        Env = "Prod"
+      }
+    }
+  }
+}
+`,
+			absorbed: `# Generated by graft absorb
+# This manifest contains overrides to match the current remote state
+
+module "network" {
+  override {
+    # Absorb drift for: module.network.azurerm_subnet.web
+    resource "azurerm_subnet" "web" {
+      service_endpoints = ["Microsoft.Storage"]
+    }
+
+    # Absorb drift for: module.network.azurerm_virtual_network.vnet
+    resource "azurerm_virtual_network" "vnet" {
+      tags = {
+        Env   = "Prod"
+        Owner = "ops"
+      }
+      flow_timeout_in_minutes = 10
+    }
+
+  }
+}
+`,
+			expected: `# Generated by graft absorb
+# This manifest contains overrides to match the current remote state
+
+module "network" {
+  override {
+    # Absorb drift for: module.network.azurerm_virtual_network.vnet
+    resource "azurerm_virtual_network" "vnet" {
+      # Changed in the portal, see INC-1234
+      dns_servers = ["10.0.0.4"]
+      tags = {
+        Env   = "Prod"
+        Owner = "ops"
+      }
+      flow_timeout_in_minutes = 10
+    }
+
+    # Absorb drift for: module.network.azurerm_subnet.web
+    resource "azurerm_subnet" "web" {
+      service_endpoints = ["Microsoft.Storage"]
+    }
+  }
+}
+`,
+			changes: []MergeChange{
+				{Address: "module.network.azurerm_subnet.web", Added: []string{"service_endpoints"}},
+				{Address: "module.network.azurerm_virtual_network.vnet", Added: []string{"flow_timeout_in_minutes"}, Updated: []string{"tags"}},
+			},
+		},
+		{
+			name:     "nested blocks and removals",
+			filename: "absorb.graft.hcl",
+			existing: `override {
+  resource "azurerm_storage_account" "main" {
+    blob_properties {
+      versioning_enabled = true
+    }
+    network_rules {
+      ip_rules = ["1.2.3.4"]
+    }
+    network_rules {
+      ip_rules = ["5.6.7.8"]
+    }
+    _graft {
+      remove = ["queue_properties"]
+    }
+  }
+}
+`,
+			absorbed: `override {
+  resource "azurerm_storage_account" "main" {
+    blob_properties {
+      change_feed_enabled = true
+    }
+    network_rules {
+      ip_rules = ["9.9.9.9"]
+    }
+    _graft {
+      remove = ["queue_properties", "static_website"]
+    }
+  }
+}
+`,
+			expected: `override {
+  resource "azurerm_storage_account" "main" {
+    blob_properties {
+      versioning_enabled  = true
+      change_feed_enabled = true
+    }
+    network_rules {
+      ip_rules = ["9.9.9.9"]
+    }
+    _graft {
+      remove = ["queue_properties", "static_website"]
+    }
+  }
+}
+`,
+			changes: []MergeChange{
+				{
+					Address: "azurerm_storage_account.main",
+					Added:   []string{"blob_properties.change_feed_enabled"},
+					Updated: []string{"network_rules", "_graft.remove"},
+				},
+			},
+		},
+		{
+			name:     "ignore_changes are combined",
+			filename: "absorb.graft.hcl",
+			existing: `override {
+  resource "azurerm_resource_group" "main" {
+    lifecycle {
+      ignore_changes = concat(graft.source, [tags["CreatedOn"]])
+    }
+  }
+}
+`,
+			absorbed: `override {
+  resource "azurerm_resource_group" "main" {
+    lifecycle {
+      ignore_changes = concat(graft.source, [sku])
+    }
+  }
+}
+`,
+			expected: `override {
+  resource "azurerm_resource_group" "main" {
+    lifecycle {
+      ignore_changes = concat(graft.source, [tags["CreatedOn"], sku])
+    }
+  }
+}
+`,
+			changes: []MergeChange{
+				{Address: "azurerm_resource_group.main", Updated: []string{"lifecycle.ignore_changes"}},
+			},
+		},
+		{
+			name:     "drift already absorbed",
+			filename: "absorb.graft.hcl",
+			existing: `override {
+  # Absorb drift for: azurerm_resource_group.main
+  resource "azurerm_resource_group" "main" {
+    tags = { Env = "Prod" }
+  }
+}
+`,
+			absorbed: `override {
+  # Absorb drift for: azurerm_resource_group.main
+  resource "azurerm_resource_group" "main" {
+    tags = {
+      Env = "Prod"
+    }
+  }
+
+}
+`,
+			expected: `override {
+  # Absorb drift for: azurerm_resource_group.main
+  resource "azurerm_resource_group" "main" {
+    tags = { Env = "Prod" }
+  }
+}
+`,
+		},
+		{
+			name:     "new module",
+			filename: "absorb.graft.hcl",
+			existing: `override {
+  resource "azurerm_resource_group" "main" {
+    location = "westeurope"
+  }
+}
+`,
+			absorbed: `module "network" {
+  override {
+    resource "azurerm_subnet" "web" {
+      address_prefixes = ["10.0.1.0/24"]
+    }
+  }
+}
+`,
+			expected: `override {
+  resource "azurerm_resource_group" "main" {
+    location = "westeurope"
+  }
+}
+
+module "network" {
+  override {
+    resource "azurerm_subnet" "web" {
+      address_prefixes = ["10.0.1.0/24"]
+    }
+  }
+}
+`,
+			changes: []MergeChange{
+				{Address: "module.network.azurerm_subnet.web", Added: []string{"address_prefixes"}},
+			},
+		},
+		{
+			name:     "JSON manifest",
+			filename: "absorb.graft.json",
+			existing: `{
+  "override": {
+    "resource": {
+      "azurerm_resource_group": {
+        "main": {
+          "location": "westeurope"
+        }
+      }
+    }
+  }
+}
+`,
+			absorbed: `override {
+  resource "azurerm_resource_group" "main" {
+    tags = {
+      Env = "Prod"
+    }
+  }
+}
+`,
+			expected: `{
+  "override": {
+    "resource": {
+      "azurerm_resource_group": {
+        "main": {
+          "location": "westeurope",
+          "tags": {
+            "Env": "Prod"
+          }
+        }
+      }
+    }
+  }
+}
+`,
+			changes: []MergeChange{
+				{Address: "azurerm_resource_group.main", Added: []string{"tags"}},
+			},
+		},
+		{
+			name:     "JSON manifest keeps comments",
+			filename: "absorb.graft.json",
+			existing: `{
+  "override": {
+    "resource": {
+      "azurerm_resource_group": {
+        "main": {
+          "//": "Pinned until the migration",
+          "location": "westeurope"
+        }
+      }
+    }
+  }
+}
+`,
+			absorbed: `override {
+  # WARNING: drift in location forces replacement.
+  resource "azurerm_storage_account" "logs" {
+    location = "westus"
+  }
+}
+`,
+			expected: `{
+  "override": {
+    "resource": {
+      "azurerm_resource_group": {
+        "main": {
+          "//": "Pinned until the migration",
+          "location": "westeurope"
+        }
+      },
+      "azurerm_storage_account": {
+        "logs": {
+          "//": "WARNING: drift in location forces replacement.",
+          "location": "westus"
+        }
+      }
+    }
+  }
+}
+`,
+			changes: []MergeChange{
+				{Address: "azurerm_storage_account.logs", Added: []string{"location"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changes, err := MergeInto([]byte(tt.existing), tt.filename, []byte(tt.absorbed))
+			if err != nil {
+				t.Fatalf("MergeInto failed: %v", err)
+			}
+			if strings.TrimSpace(string(got)) != strings.TrimSpace(tt.expected) {
+				t.Errorf("unexpected manifest:\n%s\nexpected:\n%s", got, tt.expected)
+			}
+			if !reflect.DeepEqual(changes, tt.changes) {
+				t.Errorf("unexpected changes:\n%+v\nexpected:\n%+v", changes, tt.changes)
+			}
+		})
+	}
+}
@@ -223,6 +223,28 @@ func TestMergeOverrideBlocks(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "moved blocks are kept side by side",
+			baseHCL: `moved {
+  from = aws_vpc.old
+  to   = aws_vpc.main
+}`,
+			otherHCL: `moved {
+  from = aws_subnet.old
+  to   = aws_subnet.main
+}
+moved {
+  from = aws_vpc.old
+  to   = aws_vpc.main # duplicate
+}`,
+			expectedCount: 2,
+		},
+		{
+			name:          "provider blocks with different aliases are not merged",
+			baseHCL:       `provider "aws" { region = "us-east-1" }`,
+			otherHCL:      `provider "aws" { alias = "west" }`,
+			expectedCount: 2,
+		},
 	}
 
 	for _, tt := range tests {
@@ -289,6 +311,12 @@ func TestBlockKey(t *testing.T) {
 			labels:   []string{"instance_type"},
 			expected: "variable:instance_type",
 		},
+		{
+			name:     "provider without alias",
+			typ:      "provider",
+			labels:   []string{"aws"},
+			expected: "provider:aws",
+		},
 	}
 
 	for _, tt := range tests {
@@ -302,6 +330,16 @@ func TestBlockKey(t *testing.T) {
 	}
 }
 
+func TestBlockKey_ProviderAlias(t *testing.T) {
+	f, diags := hclwrite.ParseConfig([]byte(`provider "aws" { alias = "west" }`), "test.hcl", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		t.Fatalf("failed to parse: %s", diags.Error())
+	}
+	if got := blockKey(f.Body().Blocks()[0]); got != "provider:aws.west" {
+		t.Errorf("expected %q, got %q", "provider:aws.west", got)
+	}
+}
+
 func TestMergeBlocks(t *testing.T) {
 	tests := []struct {
 		name     string
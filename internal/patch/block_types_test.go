@@ -0,0 +1,355 @@
+package patch
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/ms-henglu/graft/internal/manifest"
+)
+
+func TestApplyOverrides_BlockTypes(t *testing.T) {
+	tests := []struct {
+		name     string
+		modKey   string
+		files    map[string]string
+		override string
+		expected map[string]string
+	}{
+		{
+			name:   "variable default and validation",
+			modKey: "app",
+			files: map[string]string{
+				"variables.tf": `variable "sku" {
+  type    = string
+  default = "Basic"
+
+  validation {
+    condition     = contains(["Basic", "Standard"], var.sku)
+    error_message = "Invalid SKU."
+  }
+}
+`,
+			},
+			override: `
+override {
+  variable "sku" {
+    default = "Premium"
+    validation {
+      condition     = contains(["Basic", "Standard", "Premium"], var.sku)
+      error_message = "Invalid SKU."
+    }
+  }
+  variable "new" {
+    type = string
+    validation {
+      condition     = length(var.new) > 0
+      error_message = "Must not be empty."
+    }
+  }
+}
+`,
+			expected: map[string]string{
+				"variables.tf": `variable "sku" {
+  type    = string
+  default = "Basic"
+
+  validation {
+    condition     = contains(["Basic", "Standard", "Premium"], var.sku)
+    error_message = "Invalid SKU."
+  }
+}
+`,
+				"_graft_override.tf": `variable "sku" {
+  default = "Premium"
+}
+`,
+				"_graft_add.tf": `variable "new" {
+  type = string
+  validation {
+    condition     = length(var.new) > 0
+    error_message = "Must not be empty."
+  }
+}
+`,
+			},
+		},
+		{
+			name:   "output value",
+			modKey: "app",
+			files: map[string]string{
+				"outputs.tf": `output "id" {
+  value = azurerm_resource_group.main.id
+}
+`,
+			},
+			override: `
+override {
+  output "id" {
+    value = upper(graft.source)
+  }
+}
+`,
+			expected: map[string]string{
+				"_graft_override.tf": `output "id" {
+  value = upper(azurerm_resource_group.main.id)
+}
+`,
+			},
+		},
+		{
+			name:   "provider blocks are matched by alias",
+			modKey: "app",
+			files: map[string]string{
+				"providers.tf": `provider "azurerm" {
+  features {}
+}
+
+provider "azurerm" {
+  alias           = "hub"
+  subscription_id = "00000000"
+  features {}
+}
+`,
+			},
+			override: `
+override {
+  provider "azurerm" {
+    alias           = "hub"
+    subscription_id = "11111111"
+  }
+  provider "azurerm" {
+    alias = "spoke"
+    features {}
+  }
+}
+`,
+			expected: map[string]string{
+				"_graft_override.tf": `provider "azurerm" {
+  alias           = "hub"
+  subscription_id = "11111111"
+}
+`,
+				"_graft_add.tf": `provider "azurerm" {
+  alias = "spoke"
+  features {}
+}
+`,
+			},
+		},
+		{
+			name:   "terraform required_providers across several terraform blocks",
+			modKey: "app",
+			files: map[string]string{
+				"versions.tf": `terraform {
+  required_version = ">= 1.5"
+}
+`,
+				"providers.tf": `terraform {
+  required_providers {
+    azurerm = {
+      source  = "hashicorp/azurerm"
+      version = "~> 3.0"
+    }
+    random = {
+      source = "hashicorp/random"
+    }
+  }
+}
+`,
+			},
+			override: `
+override {
+  terraform {
+    required_providers {
+      azurerm = {
+        source  = "hashicorp/azurerm"
+        version = ">= 3.0, < 5.0"
+      }
+    }
+  }
+}
+`,
+			expected: map[string]string{
+				"_graft_override.tf": `terraform {
+  required_providers {
+    azurerm = {
+      source  = "hashicorp/azurerm"
+      version = ">= 3.0, < 5.0"
+    }
+    random = {
+      source = "hashicorp/random"
+    }
+  }
+}
+`,
+			},
+		},
+		{
+			name:   "moved and removed blocks are always added",
+			modKey: "app",
+			files: map[string]string{
+				"main.tf": `resource "azurerm_resource_group" "main" {
+  name     = "rg"
+  location = "westeurope"
+}
+
+moved {
+  from = azurerm_resource_group.old
+  to   = azurerm_resource_group.main
+}
+`,
+			},
+			override: `
+override {
+  moved {
+    from = azurerm_resource_group.main
+    to   = azurerm_resource_group.this
+  }
+  moved {
+    from = azurerm_storage_account.main
+    to   = azurerm_storage_account.this
+  }
+  removed {
+    from = azurerm_storage_account.legacy
+    lifecycle {
+      destroy = false
+    }
+  }
+}
+`,
+			expected: map[string]string{
+				"_graft_add.tf": `moved {
+  from = azurerm_resource_group.main
+  to   = azurerm_resource_group.this
+}
+moved {
+  from = azurerm_storage_account.main
+  to   = azurerm_storage_account.this
+}
+removed {
+  from = azurerm_storage_account.legacy
+  lifecycle {
+    destroy = false
+  }
+}
+`,
+			},
+		},
+		{
+			name:   "import blocks in the root module",
+			modKey: "root",
+			files: map[string]string{
+				"main.tf": `resource "azurerm_resource_group" "main" {
+  name     = "rg"
+  location = "westeurope"
+}
+`,
+			},
+			override: `
+override {
+  import {
+    to = azurerm_resource_group.main
+    id = "/subscriptions/00000000/resourceGroups/rg"
+  }
+}
+`,
+			expected: map[string]string{
+				"_graft_add.tf": `import {
+  to = azurerm_resource_group.main
+  id = "/subscriptions/00000000/resourceGroups/rg"
+}
+`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+					t.Fatalf("failed to create file %s: %v", name, err)
+				}
+			}
+
+			if err := applyOverrides(tt.modKey, manifest.Module{OverrideBlocks: parseOverrideBlocks(t, tt.override)}, dir); err != nil {
+				t.Fatalf("applyOverrides failed: %v", err)
+			}
+
+			for _, name := range []string{"_graft_override.tf", "_graft_add.tf"} {
+				if _, ok := tt.expected[name]; ok {
+					continue
+				}
+				if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+					t.Errorf("expected %s not to be generated", name)
+				}
+			}
+
+			for name, expected := range tt.expected {
+				content, err := os.ReadFile(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatalf("failed to read file %s: %v", name, err)
+				}
+				got := string(hclwrite.Format(content))
+				if strings.TrimSpace(got) != strings.TrimSpace(string(hclwrite.Format([]byte(expected)))) {
+					t.Errorf("file %s content mismatch.\nGot:\n%s\nExpected:\n%s", name, got, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyOverrides_ImportInChildModule(t *testing.T) {
+	dir := t.TempDir()
+	override := `
+override {
+  import {
+    to = azurerm_resource_group.main
+    id = "/subscriptions/00000000/resourceGroups/rg"
+  }
+}
+`
+	err := applyOverrides("app", manifest.Module{OverrideBlocks: parseOverrideBlocks(t, override)}, dir)
+	if err == nil || !strings.Contains(err.Error(), "only allowed in the root module") {
+		t.Errorf("expected root module error, got %v", err)
+	}
+}
+
+func TestApplyOverrides_InvalidMatch(t *testing.T) {
+	dir := t.TempDir()
+	override := `
+override {
+  resource "aws_security_group" "main" {
+    ingress {
+      _graft {
+        match = "from_port ="
+      }
+      description = "ssh"
+    }
+  }
+}
+`
+	err := applyOverrides("app", manifest.Module{OverrideBlocks: parseOverrideBlocks(t, override)}, dir)
+	if err == nil || !strings.Contains(err.Error(), "resource.aws_security_group.main: ingress block: invalid selector") {
+		t.Errorf("expected invalid selector error, got %v", err)
+	}
+}
+
+func parseOverrideBlocks(t *testing.T, src string) []*hclwrite.Block {
+	t.Helper()
+	f, diags := hclwrite.ParseConfig([]byte(src), "override.hcl", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		t.Fatalf("failed to parse override: %s", diags.Error())
+	}
+	var blocks []*hclwrite.Block
+	for _, b := range f.Body().Blocks() {
+		if b.Type() == "override" {
+			blocks = append(blocks, b.Body().Blocks()...)
+		}
+	}
+	return blocks
+}
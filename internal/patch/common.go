@@ -8,6 +8,8 @@ import (
 
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/hclwrite"
+	grafthcl "github.com/ms-henglu/graft/internal/hcl"
+	"github.com/ms-henglu/graft/internal/utils"
 )
 
 func listLocals(dir string) (map[string]*hclwrite.Attribute, error) {
@@ -69,12 +71,40 @@ func listBlocks(dir string) (map[string]*hclwrite.Block, error) {
 		}
 
 		for _, block := range f.Body().Blocks() {
-			blocks[blockKey(block)] = block
+			key := blockKey(block)
+			if block.Type() == "terraform" && blocks[key] != nil {
+				// A module may split its settings across several terraform blocks
+				// (e.g. required_version in one file and required_providers in another).
+				// Combine them so that deep merge and graft.source see all settings.
+				blocks[key] = combineBlocks(blocks[key], block)
+				continue
+			}
+			blocks[key] = block
 		}
 	}
 	return blocks, nil
 }
 
+// combineBlocks returns a new block with the attributes and nested blocks of both blocks
+func combineBlocks(a, b *hclwrite.Block) *hclwrite.Block {
+	result := grafthcl.DeepCopyBlock(a)
+	attrs := b.Body().Attributes()
+	for _, name := range utils.SortedKeys(attrs) {
+		result.Body().SetAttributeRaw(name, attrs[name].Expr().BuildTokens(nil))
+	}
+	for _, nested := range b.Body().Blocks() {
+		result.Body().AppendBlock(grafthcl.DeepCopyBlock(nested))
+	}
+	return result
+}
+
+// blockKey identifies a top-level block by type and labels. Provider blocks are
+// additionally identified by their alias, since a module may configure the same
+// provider several times.
 func blockKey(b *hclwrite.Block) string {
-	return fmt.Sprintf("%s.%s", b.Type(), strings.Join(b.Labels(), "."))
+	key := fmt.Sprintf("%s.%s", b.Type(), strings.Join(b.Labels(), "."))
+	if alias := grafthcl.ProviderAlias(b); alias != "" {
+		key += "." + alias
+	}
+	return key
 }
@@ -1,9 +1,16 @@
 package patch
 
 import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/hashicorp/hcl/v2/hclwrite"
-	"github.com/ms-henglu/graft/internal/hcl"
+	grafthcl "github.com/ms-henglu/graft/internal/hcl"
+	"github.com/ms-henglu/graft/internal/log"
 	"github.com/ms-henglu/graft/internal/utils"
+	"github.com/zclconf/go-cty/cty"
 )
 
 // metaArgumentBlocks are block types that have special override behavior in Terraform.
@@ -32,7 +39,7 @@ var metaArgumentBlocks = map[string]bool{
 // 3. Meta-argument blocks (lifecycle, connection, provisioner) are NOT deep-merged
 func deepMergeNestedBlock(sourceBlock, overrideBlock *hclwrite.Block, isRootLevel bool) *hclwrite.Block {
 	if overrideBlock == nil {
-		return hcl.DeepCopyBlock(sourceBlock)
+		return grafthcl.DeepCopyBlock(sourceBlock)
 	}
 	result := hclwrite.NewBlock(sourceBlock.Type(), sourceBlock.Labels())
 
@@ -44,14 +51,31 @@ func deepMergeNestedBlock(sourceBlock, overrideBlock *hclwrite.Block, isRootLeve
 		for _, name := range utils.SortedKeys(attrs) {
 			result.Body().SetAttributeRaw(name, attrs[name].Expr().BuildTokens(nil))
 		}
-		// Copy iterator block if present
-		for _, block := range sourceBlock.Body().Blocks() {
-			switch block.Type() {
-			case "iterator":
-				result.Body().AppendBlock(hcl.DeepCopyBlock(block))
-			case "content":
-				fromBlock = block
+
+		// An override written as a dynamic block may change the header and
+		// merges its content block into the source content block
+		iterator := sourceBlock.Body().FirstMatchingBlock("iterator", nil)
+		if overrideBlock.Type() == "dynamic" {
+			overrideAttrs := overrideBlock.Body().Attributes()
+			for _, name := range utils.SortedKeys(overrideAttrs) {
+				tokens, _ := resolveGraftSource(name, overrideAttrs[name], sourceBlock.Body())
+				result.Body().SetAttributeRaw(name, tokens)
+			}
+			if overrideIterator := overrideBlock.Body().FirstMatchingBlock("iterator", nil); overrideIterator != nil {
+				iterator = overrideIterator
 			}
+			overrideBlock = overrideBlock.Body().FirstMatchingBlock("content", nil)
+			if overrideBlock == nil {
+				overrideBlock = hclwrite.NewBlock("content", nil)
+			}
+		}
+		if iterator != nil {
+			result.Body().AppendBlock(grafthcl.DeepCopyBlock(iterator))
+		}
+
+		fromBlock = sourceBlock.Body().FirstMatchingBlock("content", nil)
+		if fromBlock == nil {
+			fromBlock = hclwrite.NewBlock("content", nil)
 		}
 		toBlock = hclwrite.NewBlock("content", nil)
 		result.Body().AppendBlock(toBlock)
@@ -60,7 +84,7 @@ func deepMergeNestedBlock(sourceBlock, overrideBlock *hclwrite.Block, isRootLeve
 		toBlock = result
 	}
 
-	if !isRootLevel && fromBlock != nil {
+	if !isRootLevel {
 		// Copy all attributes from source in sorted order
 		attrs := fromBlock.Body().Attributes()
 		for _, name := range utils.SortedKeys(attrs) {
@@ -68,58 +92,314 @@ func deepMergeNestedBlock(sourceBlock, overrideBlock *hclwrite.Block, isRootLeve
 		}
 	}
 
-	// Override with attributes from override in sorted order
+	// Override with attributes from override in sorted order. graft.source in
+	// top-level attributes is resolved by resolveGraftTokens; in nested blocks it
+	// refers to the attribute of the source block this override is paired with.
 	overrideAttrs := overrideBlock.Body().Attributes()
 	for _, name := range utils.SortedKeys(overrideAttrs) {
-		toBlock.Body().SetAttributeRaw(name, overrideAttrs[name].Expr().BuildTokens(nil))
+		tokens := overrideAttrs[name].Expr().BuildTokens(nil)
+		if !isRootLevel {
+			tokens, _ = resolveGraftSource(name, overrideAttrs[name], fromBlock.Body())
+		}
+		toBlock.Body().SetAttributeRaw(name, tokens)
 	}
 
-	// Get all nested block types from the override
+	// Get all nested block types from the override, counting dynamic "x" as x.
 	// Process each nested block type in sorted order for deterministic output
-	for _, blockType := range utils.SortedKeys(hcl.ListBlockTypes(overrideBlock.Body())) {
-		overrideNestedBlocks := hcl.BlocksByType(overrideBlock.Body(), blockType)
+	for _, blockType := range utils.SortedKeys(nestedBlockTypes(overrideBlock.Body())) {
+		overrideNestedBlocks := grafthcl.BlocksByType(overrideBlock.Body(), blockType)
+		overrideDynamicBlocks := grafthcl.DynamicBlocksByType(overrideBlock.Body(), blockType)
 
 		// Skip meta-argument blocks - Terraform handles these specially
 		// (lifecycle/connection are replaced, provisioner is appended)
 		if isRootLevel && metaArgumentBlocks[blockType] {
 			for _, ob := range overrideNestedBlocks {
-				newBlock := hcl.DeepCopyBlock(ob)
+				newBlock := grafthcl.DeepCopyBlock(ob)
 				toBlock.Body().AppendBlock(newBlock)
 			}
 			continue
 		}
 
-		// Use the first override block as the template for merging
-		// (typically there's only one override block per type for specifying what to merge)
-		var overrideTemplate *hclwrite.Block
-		if len(overrideNestedBlocks) > 0 {
-			overrideTemplate = overrideNestedBlocks[0]
-		}
-
 		// Find all matching blocks in source (both static and dynamic)
-		sourceStaticBlocks := hcl.BlocksByType(fromBlock.Body(), blockType)
-		sourceDynamicBlocks := hcl.DynamicBlocksByType(fromBlock.Body(), blockType)
+		sourceStaticBlocks := grafthcl.BlocksByType(fromBlock.Body(), blockType)
+		sourceDynamicBlocks := grafthcl.DynamicBlocksByType(fromBlock.Body(), blockType)
+
+		// Static overrides are paired with static source blocks and dynamic
+		// overrides with dynamic source blocks
+		p := pairNestedBlocks(sourceStaticBlocks, overrideNestedBlocks)
+		dp := pairNestedBlocks(sourceDynamicBlocks, overrideDynamicBlocks)
+		for _, sel := range append(p.unmatched, dp.unmatched...) {
+			log.Warn(fmt.Sprintf("No %s block in %s matches [%s], skipping override", blockType, blockKey(sourceBlock), sel.src))
+		}
 
 		// Deep merge each static block from source
-		for _, sourceNested := range sourceStaticBlocks {
-			mergedBlock := deepMergeNestedBlock(sourceNested, overrideTemplate, false)
+		for i, sourceNested := range sourceStaticBlocks {
+			override, ok := p.pairs[i]
+			if !ok {
+				override = p.template
+			}
+			mergedBlock := deepMergeNestedBlock(sourceNested, override, false)
 			toBlock.Body().AppendBlock(mergedBlock)
 		}
 
-		// Deep merge each dynamic block from source
-		for _, sourceDynamic := range sourceDynamicBlocks {
-			mergedDynamic := deepMergeNestedBlock(sourceDynamic, overrideTemplate, false)
+		// Deep merge each dynamic block from source; a static template applies to
+		// dynamic blocks that no dynamic override is paired with
+		for i, sourceDynamic := range sourceDynamicBlocks {
+			override, ok := dp.pairs[i]
+			if !ok {
+				override = dp.template
+			}
+			if override == nil {
+				override = p.template
+			}
+			mergedDynamic := deepMergeNestedBlock(sourceDynamic, override, false)
 			toBlock.Body().AppendBlock(mergedDynamic)
 		}
 
-		// If no source blocks exist for this type, add the override block as-is
+		// Override blocks that aren't paired with a source block are added as-is,
+		// graft.source has no original value to refer to in them
+		added := append(p.added, dp.added...)
 		if len(sourceStaticBlocks) == 0 && len(sourceDynamicBlocks) == 0 {
-			for _, ob := range overrideNestedBlocks {
-				newBlock := hcl.DeepCopyBlock(ob)
-				toBlock.Body().AppendBlock(newBlock)
+			if p.template != nil {
+				added = append(added, p.template)
+			}
+			if dp.template != nil {
+				added = append(added, dp.template)
+			}
+		}
+		for _, ob := range added {
+			newBlock := grafthcl.DeepCopyBlock(ob)
+			stripNestedGraftBlocks(newBlock)
+			resolveBodyGraftSource(newBlock.Body(), nil)
+			toBlock.Body().AppendBlock(newBlock)
+		}
+	}
+
+	return result
+}
+
+// nestedBlockTypes returns the nested block types in body, where dynamic "x"
+// blocks count as type x. _graft blocks are left out.
+func nestedBlockTypes(body *hclwrite.Body) map[string]bool {
+	types := make(map[string]bool)
+	for _, block := range body.Blocks() {
+		switch {
+		case block.Type() == "_graft":
+		case block.Type() == "dynamic" && len(block.Labels()) > 0:
+			types[block.Labels()[0]] = true
+		default:
+			types[block.Type()] = true
+		}
+	}
+	return types
+}
+
+// nestedPairing describes which override block applies to each source block of one nested type
+type nestedPairing struct {
+	// pairs maps the position of a static source block to its override block
+	pairs map[int]*hclwrite.Block
+	// template applies to the static source blocks without a pair and to dynamic blocks
+	template *hclwrite.Block
+	// added are override blocks without a source block
+	added []*hclwrite.Block
+	// unmatched are the selectors that matched no source block
+	unmatched []*blockSelector
+}
+
+// pairNestedBlocks pairs the override blocks of one nested type with the static
+// source blocks of that type:
+//
+//  1. An override block with _graft { match = ... } is paired with every source
+//     block its selector matches. If several selectors match a block, the first wins.
+//  2. The remaining override blocks are keyed by their name attribute when they
+//     all have one and all source blocks have one too. An override block whose
+//     name matches no source block is added as a new block. A single override
+//     block is only keyed if its name matches a source block.
+//  3. Otherwise a single remaining override block is a template that is merged
+//     into every source block that isn't paired yet, including dynamic blocks.
+//     It is added as a new block if there are no source blocks at all.
+//  4. Otherwise the remaining override blocks are paired by position with the
+//     source blocks that aren't paired yet. Extra override blocks are added.
+//
+// Source blocks without a pair and without a template are kept unchanged.
+func pairNestedBlocks(sources []*hclwrite.Block, overrides []*hclwrite.Block) nestedPairing {
+	result := nestedPairing{pairs: make(map[int]*hclwrite.Block)}
+
+	var plain []*hclwrite.Block
+	for _, ob := range overrides {
+		// Selectors are validated by validateMatches before the merge
+		sel, _ := parseMatch(ob)
+		if sel == nil {
+			plain = append(plain, ob)
+			continue
+		}
+		matched := false
+		for i, source := range sources {
+			if !sel.matches(source, i) {
+				continue
+			}
+			matched = true
+			if _, ok := result.pairs[i]; !ok {
+				result.pairs[i] = ob
+			}
+		}
+		if !matched {
+			result.unmatched = append(result.unmatched, sel)
+		}
+	}
+	if len(plain) == 0 {
+		return result
+	}
+
+	if byName, ok := sourcesByName(sources, plain); ok {
+		for _, ob := range plain {
+			i, found := byName[literalName(ob)]
+			if !found {
+				result.added = append(result.added, ob)
+				continue
+			}
+			if _, ok := result.pairs[i]; !ok {
+				result.pairs[i] = ob
 			}
 		}
+		return result
+	}
+
+	if len(plain) == 1 {
+		result.template = plain[0]
+		return result
 	}
 
+	next := 0
+	for i := range sources {
+		if next == len(plain) {
+			break
+		}
+		if _, ok := result.pairs[i]; ok {
+			continue
+		}
+		result.pairs[i] = plain[next]
+		next++
+	}
+	result.added = append(result.added, plain[next:]...)
 	return result
 }
+
+// sourcesByName returns the positions of the source blocks keyed by their name
+// attribute, if the override blocks should be paired by name
+func sourcesByName(sources []*hclwrite.Block, overrides []*hclwrite.Block) (map[string]int, bool) {
+	if len(sources) == 0 {
+		return nil, false
+	}
+	byName := make(map[string]int)
+	for i, source := range sources {
+		name := literalName(source)
+		if name == "" {
+			return nil, false
+		}
+		if _, ok := byName[name]; !ok {
+			byName[name] = i
+		}
+	}
+	for _, ob := range overrides {
+		if literalName(ob) == "" {
+			return nil, false
+		}
+	}
+	if len(overrides) == 1 {
+		if _, ok := byName[literalName(overrides[0])]; !ok {
+			return nil, false
+		}
+	}
+	return byName, true
+}
+
+// literalName returns the value of the name attribute of b if it is a string literal
+func literalName(b *hclwrite.Block) string {
+	val, ok := literalAttributes(b)["name"]
+	if !ok || val.Type() != cty.String || val.IsNull() {
+		return ""
+	}
+	return val.AsString()
+}
+
+// parseMatch returns the selector of a nested override block. match is either
+// an object of attribute values, a position, or a string with the same syntax
+// as removal selectors:
+//
+//	ingress {
+//	  _graft {
+//	    match = { from_port = 22 }  # or 0, or "from_port < 1024"
+//	  }
+//	  cidr_blocks = ["10.0.0.0/8"]
+//	}
+//
+// It returns nil if the block has no selector.
+func parseMatch(b *hclwrite.Block) (*blockSelector, error) {
+	graftBlock := b.Body().FirstMatchingBlock("_graft", nil)
+	if graftBlock == nil {
+		return nil, nil
+	}
+	attr := graftBlock.Body().GetAttribute("match")
+	if attr == nil {
+		return nil, nil
+	}
+
+	exprBytes := attr.Expr().BuildTokens(nil).Bytes()
+	expr, diags := hclsyntax.ParseExpression(exprBytes, "match", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("invalid match: %s", diags.Error())
+	}
+	val, diags := expr.Value(nil)
+	if diags.HasErrors() || val.IsNull() || !val.IsWhollyKnown() {
+		return nil, fmt.Errorf("invalid match: must be an object, a number or a string literal")
+	}
+	switch {
+	case val.Type().IsObjectType():
+		src := strings.TrimSpace(string(exprBytes))
+		return &blockSelector{src: src, index: -1, attrs: val.AsValueMap()}, nil
+	case val.Type() == cty.Number:
+		return parseBlockSelector(strings.TrimSpace(string(exprBytes)))
+	case val.Type() == cty.String:
+		return parseBlockSelector(val.AsString())
+	}
+	return nil, fmt.Errorf("invalid match: must be an object, a number or a string literal")
+}
+
+// validateMatches checks the selectors of the nested override blocks, so that
+// mistakes are reported before any file is generated
+func validateMatches(overrideBlocks []*hclwrite.Block) error {
+	var visit func(b *hclwrite.Block) error
+	visit = func(b *hclwrite.Block) error {
+		for _, nested := range b.Body().Blocks() {
+			if nested.Type() == "_graft" {
+				continue
+			}
+			if _, err := parseMatch(nested); err != nil {
+				return fmt.Errorf("%s block: %w", nested.Type(), err)
+			}
+			if err := visit(nested); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, block := range overrideBlocks {
+		if err := visit(block); err != nil {
+			return fmt.Errorf("%s: %w", blockKey(block), err)
+		}
+	}
+	return nil
+}
+
+// stripNestedGraftBlocks removes the _graft blocks of nested blocks, which only
+// steer the deep merge and must not end up in generated files
+func stripNestedGraftBlocks(b *hclwrite.Block) {
+	for _, nested := range b.Body().Blocks() {
+		if nested.Type() == "_graft" {
+			b.Body().RemoveBlock(nested)
+			continue
+		}
+		stripNestedGraftBlocks(nested)
+	}
+}
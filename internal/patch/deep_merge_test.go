@@ -408,6 +408,402 @@ resource "azurerm_virtual_network" "main" {
   lifecycle {
     prevent_destroy = true
   }
+}`,
+		},
+		{
+			name: "override selected by attribute match",
+			sourceHCL: `
+resource "aws_security_group" "main" {
+  name = "web"
+
+  ingress {
+    from_port = 22
+    to_port   = 22
+  }
+
+  ingress {
+    from_port = 443
+    to_port   = 443
+  }
+}
+`,
+			overrideHCL: `
+resource "aws_security_group" "main" {
+  ingress {
+    _graft {
+      match = "from_port = 22"
+    }
+    cidr_blocks = ["10.0.0.0/8"]
+  }
+}
+`,
+			expected: `resource "aws_security_group" "main" {
+  ingress {
+    cidr_blocks = ["10.0.0.0/8"]
+    from_port   = 22
+    to_port     = 22
+  }
+  ingress {
+    from_port = 443
+    to_port   = 443
+  }
+}`,
+		},
+		{
+			name: "selected overrides and template",
+			sourceHCL: `
+resource "aws_security_group" "main" {
+  ingress {
+    from_port = 22
+  }
+
+  ingress {
+    from_port = 80
+  }
+
+  ingress {
+    from_port = 443
+  }
+}
+`,
+			overrideHCL: `
+resource "aws_security_group" "main" {
+  ingress {
+    description = "default"
+  }
+  ingress {
+    _graft {
+      match = "from_port < 100 && from_port != 22"
+    }
+    description = "http"
+  }
+  ingress {
+    _graft {
+      match = "0"
+    }
+    description = "ssh"
+  }
+}
+`,
+			expected: `resource "aws_security_group" "main" {
+  ingress {
+    description = "ssh"
+    from_port   = 22
+  }
+  ingress {
+    description = "http"
+    from_port   = 80
+  }
+  ingress {
+    description = "default"
+    from_port   = 443
+  }
+}`,
+		},
+		{
+			name: "selector without match keeps source blocks",
+			sourceHCL: `
+resource "aws_security_group" "main" {
+  ingress {
+    from_port = 22
+  }
+}
+`,
+			overrideHCL: `
+resource "aws_security_group" "main" {
+  ingress {
+    _graft {
+      match = "from_port = 8080"
+    }
+    description = "alt"
+  }
+}
+`,
+			expected: `resource "aws_security_group" "main" {
+  ingress {
+    from_port = 22
+  }
+}`,
+		},
+		{
+			name: "overrides paired by position",
+			sourceHCL: `
+resource "azurerm_network_interface" "main" {
+  ip_configuration {
+    private_ip_address_allocation = "Dynamic"
+  }
+  ip_configuration {
+    private_ip_address_allocation = "Dynamic"
+  }
+  ip_configuration {
+    private_ip_address_allocation = "Static"
+  }
+}
+`,
+			overrideHCL: `
+resource "azurerm_network_interface" "main" {
+  ip_configuration {
+    primary = true
+  }
+  ip_configuration {
+    primary = false
+  }
+}
+`,
+			expected: `resource "azurerm_network_interface" "main" {
+  ip_configuration {
+    primary                       = true
+    private_ip_address_allocation = "Dynamic"
+  }
+  ip_configuration {
+    primary                       = false
+    private_ip_address_allocation = "Dynamic"
+  }
+  ip_configuration {
+    private_ip_address_allocation = "Static"
+  }
+}`,
+		},
+		{
+			name: "overrides keyed by name",
+			sourceHCL: `
+resource "azurerm_network_interface" "main" {
+  ip_configuration {
+    name     = "primary"
+    priority = 100
+  }
+  ip_configuration {
+    name     = "secondary"
+    priority = 100
+  }
+}
+`,
+			overrideHCL: `
+resource "azurerm_network_interface" "main" {
+  ip_configuration {
+    name     = "secondary"
+    priority = 200
+  }
+  ip_configuration {
+    name     = "tertiary"
+    priority = 300
+  }
+}
+`,
+			expected: `resource "azurerm_network_interface" "main" {
+  ip_configuration {
+    name     = "primary"
+    priority = 100
+  }
+  ip_configuration {
+    name     = "secondary"
+    priority = 200
+  }
+  ip_configuration {
+    name     = "tertiary"
+    priority = 300
+  }
+}`,
+		},
+		{
+			name: "single override keyed by name",
+			sourceHCL: `
+resource "azurerm_network_interface" "main" {
+  ip_configuration {
+    name     = "primary"
+    priority = 100
+  }
+  ip_configuration {
+    name     = "secondary"
+    priority = 100
+  }
+}
+`,
+			overrideHCL: `
+resource "azurerm_network_interface" "main" {
+  ip_configuration {
+    name     = "secondary"
+    priority = 200
+  }
+}
+`,
+			expected: `resource "azurerm_network_interface" "main" {
+  ip_configuration {
+    name     = "primary"
+    priority = 100
+  }
+  ip_configuration {
+    name     = "secondary"
+    priority = 200
+  }
+}`,
+		},
+		{
+			name: "overrides keyed by match object and position",
+			sourceHCL: `
+resource "aws_security_group" "main" {
+  ingress {
+    from_port = 22
+    protocol  = "tcp"
+  }
+  ingress {
+    from_port = 443
+    protocol  = "tcp"
+  }
+}
+`,
+			overrideHCL: `
+resource "aws_security_group" "main" {
+  ingress {
+    _graft {
+      match = { from_port = 443, protocol = "tcp" }
+    }
+    description = "https"
+  }
+  ingress {
+    _graft {
+      match = 0
+    }
+    description = "ssh"
+  }
+}
+`,
+			expected: `resource "aws_security_group" "main" {
+  ingress {
+    description = "ssh"
+    from_port   = 22
+    protocol    = "tcp"
+  }
+  ingress {
+    description = "https"
+    from_port   = 443
+    protocol    = "tcp"
+  }
+}`,
+		},
+		{
+			name: "graft.source resolved per sibling block",
+			sourceHCL: `
+resource "aws_security_group" "main" {
+  ingress {
+    from_port   = 22
+    cidr_blocks = ["10.0.0.0/8"]
+  }
+  ingress {
+    from_port   = 443
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+}
+`,
+			overrideHCL: `
+resource "aws_security_group" "main" {
+  ingress {
+    cidr_blocks = concat(graft.source, ["192.168.0.0/16"])
+  }
+}
+`,
+			expected: `resource "aws_security_group" "main" {
+  ingress {
+    cidr_blocks = concat(["10.0.0.0/8"], ["192.168.0.0/16"])
+    from_port   = 22
+  }
+  ingress {
+    cidr_blocks = concat(["0.0.0.0/0"], ["192.168.0.0/16"])
+    from_port   = 443
+  }
+}`,
+		},
+		{
+			name: "graft.source inside dynamic block content",
+			sourceHCL: `
+resource "azurerm_virtual_network" "main" {
+  dynamic "subnet" {
+    for_each = var.subnets
+    content {
+      name = subnet.value.name
+      tags = subnet.value.tags
+    }
+  }
+  dynamic "delegation" {
+    for_each = var.delegations
+    content {
+      name = delegation.value
+    }
+  }
+}
+`,
+			overrideHCL: `
+resource "azurerm_virtual_network" "main" {
+  dynamic "subnet" {
+    for_each = merge(graft.source, var.extra_subnets)
+    content {
+      tags = merge(graft.source, { managed = "graft" })
+    }
+  }
+}
+`,
+			expected: `resource "azurerm_virtual_network" "main" {
+  dynamic "subnet" {
+    for_each = merge(var.subnets, var.extra_subnets)
+    content {
+      name = subnet.value.name
+      tags = merge(subnet.value.tags, { managed = "graft" })
+    }
+  }
+}`,
+		},
+		{
+			name: "graft.source in a template merged into static and dynamic blocks",
+			sourceHCL: `
+resource "azurerm_virtual_network" "main" {
+  subnet {
+    tags = { tier = "web" }
+  }
+  dynamic "subnet" {
+    for_each = var.subnets
+    content {
+      tags = subnet.value.tags
+    }
+  }
+}
+`,
+			overrideHCL: `
+resource "azurerm_virtual_network" "main" {
+  subnet {
+    tags = merge(graft.source, { managed = "graft" })
+  }
+}
+`,
+			expected: `resource "azurerm_virtual_network" "main" {
+  subnet {
+    tags = merge({ tier = "web" }, { managed = "graft" })
+  }
+  dynamic "subnet" {
+    for_each = var.subnets
+    content {
+      tags = merge(subnet.value.tags, { managed = "graft" })
+    }
+  }
+}`,
+		},
+		{
+			name: "graft.source in an added nested block",
+			sourceHCL: `
+resource "azurerm_virtual_network" "main" {
+  name = "vnet"
+}
+`,
+			overrideHCL: `
+resource "azurerm_virtual_network" "main" {
+  ddos_protection_plan {
+    enable = coalesce(graft.source, true)
+  }
+}
+`,
+			expected: `resource "azurerm_virtual_network" "main" {
+  ddos_protection_plan {
+    enable = coalesce(null, true)
+  }
 }`,
 		},
 	}
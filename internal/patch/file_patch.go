@@ -0,0 +1,465 @@
+package patch
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/ms-henglu/graft/internal/log"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// maxHunkFuzz is the number of context lines that may be ignored at the start
+// and end of a hunk when it doesn't apply with its full context, like the fuzz
+// factor of GNU patch.
+const maxHunkFuzz = 2
+
+// hunk is a single @@ section of a unified diff
+type hunk struct {
+	header   string
+	oldStart int // 1-based line number in the original file
+	lines    []hunkLine
+}
+
+// hunkLine is a line of a hunk: ' ' (context), '-' (delete) or '+' (insert)
+type hunkLine struct {
+	kind byte
+	text string
+}
+
+var hunkHeaderPattern = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// applyFilePatches applies patch blocks to files of the vendored module. Each
+// block is labeled with a path relative to the module and contains a unified
+// diff, replace rules, or both:
+//
+//	patch "templates/cloud-init.tpl" {
+//	  diff = <<-EOT
+//	    @@ -3,3 +3,3 @@
+//	     packages:
+//	    -  - docker
+//	    +  - podman
+//	     runcmd:
+//	  EOT
+//
+//	  replace {
+//	    search = "apt-get"
+//	    with   = "dnf"
+//	    count  = 2
+//	  }
+//	}
+//
+// Hunks are located near their line numbers and may match with ignored
+// whitespace or some context lines, but a hunk or search string that can't be
+// found is an error, so that upstream changes don't silently drop a patch.
+func applyFilePatches(modulePath string, patches []*hclwrite.Block) error {
+	for _, block := range patches {
+		if len(block.Labels()) != 1 {
+			return fmt.Errorf("patch blocks must have exactly one label, the path of the file in the module")
+		}
+		name := block.Labels()[0]
+		if err := applyFilePatch(modulePath, name, block.Body()); err != nil {
+			return fmt.Errorf("patch %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func applyFilePatch(modulePath string, name string, body *hclwrite.Body) error {
+	path, err := patchFilePath(modulePath, name)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("file not found in module: %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	// Work on LF line endings and restore CRLF when writing the file back
+	content := string(data)
+	crlf := strings.Contains(content, "\r\n")
+	if crlf {
+		content = strings.ReplaceAll(content, "\r\n", "\n")
+	}
+
+	applied := false
+	if attr := body.GetAttribute("diff"); attr != nil {
+		diff, err := patchString(attr, "diff")
+		if err != nil {
+			return err
+		}
+		hunks, err := parseUnifiedDiff(diff)
+		if err != nil {
+			return err
+		}
+		content, err = applyHunks(name, content, hunks)
+		if err != nil {
+			return err
+		}
+		applied = true
+	}
+
+	for i, block := range body.Blocks() {
+		if block.Type() != "replace" {
+			return fmt.Errorf("unsupported block %q, expected replace", block.Type())
+		}
+		content, err = applyReplace(content, block.Body())
+		if err != nil {
+			return fmt.Errorf("replace #%d: %w", i+1, err)
+		}
+		applied = true
+	}
+
+	if !applied {
+		return fmt.Errorf("a patch needs a diff or at least one replace block")
+	}
+
+	if crlf {
+		content = strings.ReplaceAll(content, "\n", "\r\n")
+	}
+	return os.WriteFile(path, []byte(content), info.Mode().Perm())
+}
+
+// patchFilePath resolves the label of a patch block to a file in the module,
+// rejecting paths that point outside of it
+func patchFilePath(modulePath string, name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if name == "" || filepath.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("the path must be relative to the module and stay inside it")
+	}
+	return filepath.Join(modulePath, clean), nil
+}
+
+// patchString evaluates an attribute of a patch block, which must be a string
+// literal or a heredoc without interpolation
+func patchString(attr *hclwrite.Attribute, name string) (string, error) {
+	// A heredoc needs the newline after its closing marker
+	src := append(attr.Expr().BuildTokens(nil).Bytes(), '\n')
+	expr, diags := hclsyntax.ParseExpression(src, name, hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return "", fmt.Errorf("invalid %s: %s", name, diags.Error())
+	}
+	val, diags := expr.Value(nil)
+	if diags.HasErrors() || val.Type() != cty.String || val.IsNull() {
+		return "", fmt.Errorf("invalid %s: must be a string without interpolation, write $${ and %%%%{ for a literal ${ and %%{", name)
+	}
+	return val.AsString(), nil
+}
+
+// parseUnifiedDiff parses the hunks of a unified diff for a single file. Lines
+// before the first hunk, such as the ---/+++ headers, are ignored.
+func parseUnifiedDiff(diff string) ([]hunk, error) {
+	lines := strings.Split(strings.ReplaceAll(diff, "\r\n", "\n"), "\n")
+
+	var hunks []hunk
+	seenFileHeader := false
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ ") {
+			if seenFileHeader && len(hunks) > 0 {
+				return nil, fmt.Errorf("the diff changes more than one file, use a patch block per file")
+			}
+			seenFileHeader = true
+			i++
+			continue
+		}
+
+		m := hunkHeaderPattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		h := hunk{header: strings.TrimSpace(line)}
+		h.oldStart, _ = strconv.Atoi(m[1])
+		oldCount, newCount := 1, 1
+		if m[2] != "" {
+			oldCount, _ = strconv.Atoi(m[2])
+		}
+		if m[4] != "" {
+			newCount, _ = strconv.Atoi(m[4])
+		}
+
+		// Read lines until the counts in the header are used up
+		for oldCount > 0 || newCount > 0 {
+			i++
+			if i >= len(lines) {
+				return nil, fmt.Errorf("hunk %s: the diff ends before the hunk is complete", h.header)
+			}
+			text := lines[i]
+			if strings.HasPrefix(text, `\`) {
+				// "\ No newline at end of file"
+				continue
+			}
+			kind := byte(' ')
+			if text != "" {
+				kind = text[0]
+				text = text[1:]
+			}
+			switch kind {
+			case ' ':
+				oldCount--
+				newCount--
+			case '-':
+				oldCount--
+			case '+':
+				newCount--
+			default:
+				return nil, fmt.Errorf("hunk %s: unexpected line %q", h.header, lines[i])
+			}
+			if oldCount < 0 || newCount < 0 {
+				return nil, fmt.Errorf("hunk %s: the line counts don't match the header", h.header)
+			}
+			h.lines = append(h.lines, hunkLine{kind: kind, text: text})
+		}
+		hunks = append(hunks, h)
+	}
+
+	if len(hunks) == 0 {
+		return nil, fmt.Errorf("the diff has no hunks")
+	}
+	return hunks, nil
+}
+
+// applyHunks applies hunks to content in order. Each hunk is searched for at
+// its line number, adjusted by the offset of the previous hunks, and then
+// further away from it. If that fails, whitespace differences are ignored and
+// up to maxHunkFuzz context lines are dropped from both ends of the hunk.
+func applyHunks(name string, content string, hunks []hunk) (string, error) {
+	trailingNewline := strings.HasSuffix(content, "\n")
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	if content == "" {
+		lines = nil
+	}
+
+	offset := 0
+	minLine := 0
+	for i, h := range hunks {
+		expected := h.oldStart - 1 + offset
+		if h.oldLineCount() == 0 {
+			// An insertion without context goes after line oldStart
+			expected = h.oldStart + offset
+		}
+
+		match, ok := locateHunk(lines, h, expected, minLine)
+		if !ok {
+			reason := "its lines were not found, the file may have changed upstream"
+			if _, applied := locateHunk(lines, h.reversed(), expected, 0); applied {
+				reason = "the file already contains its changes, it may have been fixed upstream"
+			}
+			return "", fmt.Errorf("hunk #%d (%s) does not apply: %s", i+1, h.header, reason)
+		}
+		shift := match.pos - match.leading - expected
+		if shift != 0 || match.fuzz > 0 || match.ignoredWhitespace {
+			details := fmt.Sprintf("offset %d lines, fuzz %d", shift, match.fuzz)
+			if match.ignoredWhitespace {
+				details += ", ignoring whitespace"
+			}
+			log.Warn(fmt.Sprintf("%s: hunk #%d applied at line %d (%s)", name, i+1, match.pos-match.leading+1, details))
+		}
+
+		// Context lines keep the text of the file, so fuzzy matches don't reformat them
+		pos := match.pos
+		var replacement []string
+		at := pos
+		for _, line := range match.hunk.lines {
+			switch line.kind {
+			case ' ':
+				replacement = append(replacement, lines[at])
+				at++
+			case '-':
+				at++
+			case '+':
+				replacement = append(replacement, line.text)
+			}
+		}
+		lines = append(append(append([]string(nil), lines[:pos]...), replacement...), lines[at:]...)
+
+		offset += shift + len(replacement) - (at - pos)
+		minLine = pos + len(replacement)
+	}
+
+	result := strings.Join(lines, "\n")
+	if trailingNewline || (content == "" && len(lines) > 0) {
+		result += "\n"
+	}
+	return result, nil
+}
+
+// hunkMatch is where a hunk applies in a file
+type hunkMatch struct {
+	pos               int  // line of the first line of hunk
+	hunk              hunk // the hunk with any ignored context lines removed
+	leading           int  // context lines removed from the start of the hunk
+	fuzz              int  // context lines ignored per side
+	ignoredWhitespace bool // lines only matched when ignoring whitespace
+}
+
+// locateHunk returns where h applies, trying exact matches before ignoring
+// whitespace and before ignoring context lines
+func locateHunk(lines []string, h hunk, expected int, minLine int) (hunkMatch, bool) {
+	for fuzz := 0; fuzz <= maxHunkFuzz; fuzz++ {
+		fuzzed, leading, ok := h.withoutContext(fuzz)
+		if !ok {
+			break
+		}
+		// Dropped leading context lines shift where the hunk starts
+		start := expected + leading
+		for _, ignoreWhitespace := range []bool{false, true} {
+			equal := equalLine
+			if ignoreWhitespace {
+				equal = equalIgnoringWhitespace
+			}
+			if pos, ok := findHunk(lines, fuzzed.oldLines(), start, minLine, equal); ok {
+				return hunkMatch{pos: pos, hunk: fuzzed, leading: leading, fuzz: fuzz, ignoredWhitespace: ignoreWhitespace}, true
+			}
+		}
+	}
+	return hunkMatch{}, false
+}
+
+// findHunk searches for old in lines, starting at expected and moving away from
+// it in both directions, but never before minLine
+func findHunk(lines []string, old []string, expected int, minLine int, equal func(a, b string) bool) (int, bool) {
+	matchesAt := func(pos int) bool {
+		if pos < minLine || pos+len(old) > len(lines) {
+			return false
+		}
+		for i, text := range old {
+			if !equal(lines[pos+i], text) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for distance := 0; distance <= len(lines); distance++ {
+		if matchesAt(expected + distance) {
+			return expected + distance, true
+		}
+		if distance > 0 && matchesAt(expected-distance) {
+			return expected - distance, true
+		}
+	}
+	return 0, false
+}
+
+func equalLine(a, b string) bool {
+	return a == b
+}
+
+func equalIgnoringWhitespace(a, b string) bool {
+	return strings.Join(strings.Fields(a), " ") == strings.Join(strings.Fields(b), " ")
+}
+
+// oldLines returns the lines the hunk expects in the original file
+func (h hunk) oldLines() []string {
+	var res []string
+	for _, line := range h.lines {
+		if line.kind != '+' {
+			res = append(res, line.text)
+		}
+	}
+	return res
+}
+
+func (h hunk) oldLineCount() int {
+	return len(h.oldLines())
+}
+
+// withoutContext returns the hunk with up to n context lines dropped from its
+// start and end, and the number of lines dropped from its start. It fails if
+// no context line would be left or nothing could be dropped.
+func (h hunk) withoutContext(n int) (hunk, int, bool) {
+	if n == 0 {
+		return h, 0, true
+	}
+	start, end := 0, len(h.lines)
+	for i := 0; i < n && start < end && h.lines[start].kind == ' '; i++ {
+		start++
+	}
+	for i := 0; i < n && end > start && h.lines[end-1].kind == ' '; i++ {
+		end--
+	}
+	if start == 0 && end == len(h.lines) {
+		return h, 0, false
+	}
+	fuzzed := hunk{header: h.header, oldStart: h.oldStart, lines: h.lines[start:end]}
+	for _, line := range fuzzed.lines {
+		if line.kind == ' ' {
+			return fuzzed, start, true
+		}
+	}
+	return h, 0, false
+}
+
+// reversed returns the hunk that undoes h
+func (h hunk) reversed() hunk {
+	r := hunk{header: h.header, oldStart: h.oldStart}
+	for _, line := range h.lines {
+		switch line.kind {
+		case '-':
+			line.kind = '+'
+		case '+':
+			line.kind = '-'
+		}
+		r.lines = append(r.lines, line)
+	}
+	return r
+}
+
+// applyReplace applies a replace block, which replaces every occurrence of
+// search with with. If count is set, the number of occurrences must match it.
+func applyReplace(content string, body *hclwrite.Body) (string, error) {
+	searchAttr := body.GetAttribute("search")
+	if searchAttr == nil {
+		return "", fmt.Errorf("search is required")
+	}
+	search, err := patchString(searchAttr, "search")
+	if err != nil {
+		return "", err
+	}
+	if search == "" {
+		return "", fmt.Errorf("search can't be empty")
+	}
+
+	withAttr := body.GetAttribute("with")
+	if withAttr == nil {
+		return "", fmt.Errorf("with is required")
+	}
+	with, err := patchString(withAttr, "with")
+	if err != nil {
+		return "", err
+	}
+
+	found := strings.Count(content, search)
+	if found == 0 {
+		return "", fmt.Errorf("%q was not found, the file may have changed upstream", search)
+	}
+	if countAttr := body.GetAttribute("count"); countAttr != nil {
+		expr, diags := hclsyntax.ParseExpression(countAttr.Expr().BuildTokens(nil).Bytes(), "count", hcl.Pos{Line: 1, Column: 1})
+		if diags.HasErrors() {
+			return "", fmt.Errorf("invalid count: %s", diags.Error())
+		}
+		val, diags := expr.Value(nil)
+		if diags.HasErrors() || val.Type() != cty.Number || val.IsNull() {
+			return "", fmt.Errorf("invalid count: must be a number")
+		}
+		count, ok := countIndex(val)
+		if !ok {
+			return "", fmt.Errorf("invalid count: must be a whole number")
+		}
+		if count != int64(found) {
+			return "", fmt.Errorf("expected %d occurrence(s) of %q, found %d", count, search, found)
+		}
+	}
+	return strings.ReplaceAll(content, search, with), nil
+}
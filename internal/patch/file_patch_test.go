@@ -0,0 +1,310 @@
+package patch
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+func TestApplyFilePatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		content  string
+		patch    string
+		expected string
+		err      string
+	}{
+		{
+			name: "unified diff",
+			file: "templates/cloud-init.tpl",
+			content: `#cloud-config
+packages:
+  - docker
+  - git
+runcmd:
+  - echo ${name}
+`,
+			patch: `
+patch "templates/cloud-init.tpl" {
+  diff = <<-EOT
+    --- a/templates/cloud-init.tpl
+    +++ b/templates/cloud-init.tpl
+    @@ -1,5 +1,5 @@
+     #cloud-config
+     packages:
+    -  - docker
+    +  - podman
+       - git
+     runcmd:
+  EOT
+}
+`,
+			expected: `#cloud-config
+packages:
+  - podman
+  - git
+runcmd:
+  - echo ${name}
+`,
+		},
+		{
+			name: "hunk applies at an offset",
+			file: "scripts/setup.sh",
+			content: `#!/bin/bash
+# Added upstream
+# after the diff was written
+set -e
+apt-get update
+apt-get install -y curl
+echo done
+`,
+			patch: `
+patch "scripts/setup.sh" {
+  diff = <<-EOT
+    @@ -2,3 +2,4 @@
+     set -e
+     apt-get update
+    +apt-get upgrade -y
+     apt-get install -y curl
+  EOT
+}
+`,
+			expected: `#!/bin/bash
+# Added upstream
+# after the diff was written
+set -e
+apt-get update
+apt-get upgrade -y
+apt-get install -y curl
+echo done
+`,
+		},
+		{
+			name: "whitespace changes and fuzz",
+			file: "main.tf",
+			content: `locals {
+  # reformatted upstream
+  size    = lookup(var.sizes, var.env)
+  zone = "1"
+}
+`,
+			patch: `
+patch "main.tf" {
+  diff = <<-EOT
+    @@ -1,4 +1,4 @@
+     locals {
+    -  size = lookup(var.sizes, var.env)
+    +  size = lookup(var.sizes, var.env, "small")
+       zone = "1"
+     }
+  EOT
+}
+`,
+			expected: `locals {
+  # reformatted upstream
+  size = lookup(var.sizes, var.env, "small")
+  zone = "1"
+}
+`,
+		},
+		{
+			name: "multiple hunks",
+			file: "config.json",
+			content: `{
+  "a": 1,
+  "b": 2,
+  "c": 3,
+  "d": 4,
+  "e": 5,
+  "f": 6,
+  "g": 7,
+  "h": 8
+}
+`,
+			patch: `
+patch "config.json" {
+  diff = <<-EOT
+    @@ -1,3 +1,4 @@
+     {
+    +  "z": 0,
+       "a": 1,
+       "b": 2,
+    @@ -8,3 +9,3 @@
+       "g": 7,
+    -  "h": 8
+    +  "h": 9
+     }
+  EOT
+}
+`,
+			expected: `{
+  "z": 0,
+  "a": 1,
+  "b": 2,
+  "c": 3,
+  "d": 4,
+  "e": 5,
+  "f": 6,
+  "g": 7,
+  "h": 9
+}
+`,
+		},
+		{
+			name: "search and replace",
+			file: "templates/user-data.tpl",
+			content: `apt-get update
+apt-get install -y ${packages}
+`,
+			patch: `
+patch "templates/user-data.tpl" {
+  replace {
+    search = "apt-get"
+    with   = "dnf"
+    count  = 2
+  }
+  replace {
+    search = "$${packages}"
+    with   = "$${join(\" \", packages)}"
+  }
+}
+`,
+			expected: `dnf update
+dnf install -y ${join(" ", packages)}
+`,
+		},
+		{
+			name:    "hunk no longer applies",
+			file:    "main.tf",
+			content: "locals {\n  name = var.prefix\n}\n",
+			patch: `
+patch "main.tf" {
+  diff = <<-EOT
+    @@ -1,3 +1,3 @@
+     locals {
+    -  size = "small"
+    +  size = "large"
+     }
+  EOT
+}
+`,
+			err: `patch "main.tf": hunk #1 (@@ -1,3 +1,3 @@) does not apply: its lines were not found`,
+		},
+		{
+			name:    "hunk already applied upstream",
+			file:    "main.tf",
+			content: "locals {\n  size = \"large\"\n}\n",
+			patch: `
+patch "main.tf" {
+  diff = <<-EOT
+    @@ -1,3 +1,3 @@
+     locals {
+    -  size = "small"
+    +  size = "large"
+     }
+  EOT
+}
+`,
+			err: "the file already contains its changes",
+		},
+		{
+			name:    "search string not found",
+			file:    "setup.sh",
+			content: "yum install -y curl\n",
+			patch: `
+patch "setup.sh" {
+  replace {
+    search = "apt-get"
+    with   = "dnf"
+  }
+}
+`,
+			err: `patch "setup.sh": replace #1: "apt-get" was not found`,
+		},
+		{
+			name:    "unexpected number of occurrences",
+			file:    "setup.sh",
+			content: "apt-get update\napt-get install -y curl\n",
+			patch: `
+patch "setup.sh" {
+  replace {
+    search = "apt-get"
+    with   = "dnf"
+    count  = 1
+  }
+}
+`,
+			err: `expected 1 occurrence(s) of "apt-get", found 2`,
+		},
+		{
+			name:    "path outside of the module",
+			file:    "main.tf",
+			content: "",
+			patch: `
+patch "../main.tf" {
+  replace {
+    search = "a"
+    with   = "b"
+  }
+}
+`,
+			err: "the path must be relative to the module and stay inside it",
+		},
+		{
+			name:    "interpolation in a search string",
+			file:    "main.tf",
+			content: "a\n",
+			patch: `
+patch "main.tf" {
+  replace {
+    search = "${var.a}"
+    with   = "b"
+  }
+}
+`,
+			err: "invalid search: must be a string without interpolation",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, filepath.FromSlash(tt.file))
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			f, diags := hclwrite.ParseConfig([]byte(tt.patch), "manifest.hcl", hcl.Pos{Line: 1, Column: 1})
+			if diags.HasErrors() {
+				t.Fatalf("failed to parse patch: %s", diags.Error())
+			}
+
+			err := applyFilePatches(dir, f.Body().Blocks())
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyFilePatches failed: %v", err)
+			}
+
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.expected {
+				t.Errorf("unexpected content:\n%s\nexpected:\n%s", got, tt.expected)
+			}
+		})
+	}
+}
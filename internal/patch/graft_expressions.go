@@ -0,0 +1,308 @@
+package patch
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	grafthcl "github.com/ms-henglu/graft/internal/hcl"
+	"github.com/ms-henglu/graft/internal/log"
+	"github.com/ms-henglu/graft/internal/utils"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// graftExpression is a graft.* expression in the tokens of an attribute, e.g.
+// graft.source or graft::source_of("resource.azurerm_subnet.main.name")
+type graftExpression struct {
+	// start and end are the indexes of the first token and one past the last token
+	start, end int
+	name       string
+	// arg is the address passed to graft::source_of
+	arg string
+}
+
+// graftExpressionNames are the supported graft.* expressions:
+//   - graft.source: the original expression of the attribute being overridden
+//   - graft::source_of("<address>"): the original expression of another attribute.
+//     It is written as a namespaced function, since graft.source_of(...) isn't valid HCL.
+//   - graft.module_key: the key of the patched module, e.g. "network.subnets"
+//   - graft.module_version: the version of the patched module
+//   - graft.original_block: the original nested blocks named by the attribute
+var graftExpressionNames = map[string]bool{
+	"source":         true,
+	"source_of":      true,
+	"module_key":     true,
+	"module_version": true,
+	"original_block": true,
+}
+
+// findGraftExpressions returns the graft.* expressions in tokens. References
+// such as var.graft.source are not graft expressions.
+func findGraftExpressions(tokens hclwrite.Tokens) ([]graftExpression, error) {
+	var result []graftExpression
+	for i := 0; i < len(tokens); i++ {
+		if tokens[i].Type != hclsyntax.TokenIdent || string(tokens[i].Bytes) != "graft" {
+			continue
+		}
+		if i > 0 && tokens[i-1].Type == hclsyntax.TokenDot {
+			continue
+		}
+		if i+2 >= len(tokens) || tokens[i+2].Type != hclsyntax.TokenIdent {
+			continue
+		}
+
+		e := graftExpression{start: i, end: i + 3, name: string(tokens[i+2].Bytes)}
+		switch tokens[i+1].Type {
+		case hclsyntax.TokenDot:
+			if e.name == "source_of" {
+				return nil, fmt.Errorf("graft.source_of must be called as graft::source_of(\"<address>\")")
+			}
+		case hclsyntax.TokenDoubleColon:
+			if e.name != "source_of" {
+				return nil, fmt.Errorf("unknown function graft::%s", e.name)
+			}
+			arg, end, ok := parseStringArgument(tokens, i+3)
+			if !ok {
+				return nil, fmt.Errorf("graft::source_of requires a single string literal argument, e.g. graft::source_of(\"resource.azurerm_subnet.main.name\")")
+			}
+			e.arg = arg
+			e.end = end
+		default:
+			continue
+		}
+		result = append(result, e)
+		i = e.end - 1
+	}
+	return result, nil
+}
+
+// parseStringArgument parses ("...") starting at tokens[i] and returns the string
+// and the index one past the closing parenthesis
+func parseStringArgument(tokens hclwrite.Tokens, i int) (string, int, bool) {
+	if i >= len(tokens) || tokens[i].Type != hclsyntax.TokenOParen {
+		return "", 0, false
+	}
+	i++
+	if i >= len(tokens) || tokens[i].Type != hclsyntax.TokenOQuote {
+		return "", 0, false
+	}
+	i++
+	var arg strings.Builder
+	for i < len(tokens) && tokens[i].Type == hclsyntax.TokenQuotedLit {
+		arg.Write(tokens[i].Bytes)
+		i++
+	}
+	if i+1 >= len(tokens) || tokens[i].Type != hclsyntax.TokenCQuote || tokens[i+1].Type != hclsyntax.TokenCParen {
+		return "", 0, false
+	}
+	return arg.String(), i + 2, true
+}
+
+// replaceGraftExpressions replaces each expression for which replace returns
+// tokens. It returns nil if nothing was replaced.
+func replaceGraftExpressions(tokens hclwrite.Tokens, exprs []graftExpression, replace func(graftExpression) hclwrite.Tokens) hclwrite.Tokens {
+	var newTokens hclwrite.Tokens
+	changed := false
+	last := 0
+	for _, e := range exprs {
+		replacement := replace(e)
+		if replacement == nil {
+			continue
+		}
+		newTokens = append(newTokens, tokens[last:e.start]...)
+		newTokens = append(newTokens, replacement...)
+		last = e.end
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return append(newTokens, tokens[last:]...)
+}
+
+// resolveGraftExpressions resolves the graft.* expressions that don't depend on
+// which source block an override is merged into: graft::source_of,
+// graft.module_key and graft.module_version in all attributes, and
+// graft.original_block in top-level attributes. graft.source is resolved later,
+// by resolveGraftTokens and the deep merge.
+func resolveGraftExpressions(modKey string, modVersion string, overrideBlocks []*hclwrite.Block, existingBlocks map[string]*hclwrite.Block, existingLocals map[string]*hclwrite.Attribute) error {
+	// Root overrides have the empty module key, like in modules.json
+	if modKey == "root" {
+		modKey = ""
+	}
+
+	var resolveBody func(body *hclwrite.Body, isTopLevel bool) error
+	resolveBody = func(body *hclwrite.Body, isTopLevel bool) error {
+		attrs := body.Attributes()
+		for _, name := range utils.SortedKeys(attrs) {
+			tokens := attrs[name].Expr().BuildTokens(nil)
+			exprs, err := findGraftExpressions(tokens)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+
+			var resolveErr error
+			newTokens := replaceGraftExpressions(tokens, exprs, func(e graftExpression) hclwrite.Tokens {
+				if resolveErr != nil {
+					return nil
+				}
+				if !graftExpressionNames[e.name] {
+					resolveErr = fmt.Errorf("%s: unknown expression graft.%s", name, e.name)
+					return nil
+				}
+
+				var value string
+				switch e.name {
+				case "original_block":
+					if !isTopLevel || len(exprs) != 1 || !isWholeExpression(tokens, e) {
+						resolveErr = fmt.Errorf("%s: graft.original_block must be the whole value of a top-level attribute", name)
+					}
+					return nil
+				case "source_of":
+					replacement, err := sourceOf(e.arg, existingBlocks, existingLocals)
+					if err != nil {
+						resolveErr = fmt.Errorf("%s: %w", name, err)
+					}
+					return replacement
+				case "module_key":
+					value = modKey
+				case "module_version":
+					value = modVersion
+				default:
+					// graft.source depends on the source block and is resolved later
+					return nil
+				}
+				literal := hclwrite.TokensForValue(cty.StringVal(value))
+				literal[0].SpacesBefore = tokens[e.start].SpacesBefore
+				return literal
+			})
+			if resolveErr != nil {
+				return resolveErr
+			}
+			if newTokens != nil {
+				body.SetAttributeRaw(name, newTokens)
+			}
+		}
+
+		for _, nested := range body.Blocks() {
+			if err := resolveBody(nested.Body(), false); err != nil {
+				return fmt.Errorf("%s: %w", nested.Type(), err)
+			}
+		}
+		return nil
+	}
+
+	for _, block := range overrideBlocks {
+		if err := resolveBody(block.Body(), block.Type() != "locals"); err != nil {
+			return fmt.Errorf("%s: %w", blockKey(block), err)
+		}
+		if block.Type() == "locals" || grafthcl.IsAdditiveBlock(block.Type()) {
+			continue
+		}
+		expandOriginalBlocks(block, existingBlocks[blockKey(block)])
+	}
+	return nil
+}
+
+// isWholeExpression reports whether e is all of tokens, apart from newlines
+func isWholeExpression(tokens hclwrite.Tokens, e graftExpression) bool {
+	for i, t := range tokens {
+		if (i < e.start || i >= e.end) && t.Type != hclsyntax.TokenNewline {
+			return false
+		}
+	}
+	return true
+}
+
+// expandOriginalBlocks replaces each attribute x = graft.original_block of block
+// with copies of the x blocks of the original block, placed before the x blocks
+// of the override. For example, this keeps the original provisioners when an
+// override adds one, since Terraform would otherwise drop them.
+func expandOriginalBlocks(block *hclwrite.Block, original *hclwrite.Block) {
+	attrs := block.Body().Attributes()
+	for _, name := range utils.SortedKeys(attrs) {
+		exprs, _ := findGraftExpressions(attrs[name].Expr().BuildTokens(nil))
+		if len(exprs) != 1 || exprs[0].name != "original_block" {
+			continue
+		}
+		block.Body().RemoveAttribute(name)
+
+		var originals []*hclwrite.Block
+		if original != nil {
+			originals = grafthcl.BlocksByType(original.Body(), name)
+		}
+		if len(originals) == 0 {
+			log.Warn(fmt.Sprintf("%s: no original %s block for graft.original_block", blockKey(block), name))
+			continue
+		}
+
+		// Keep the blocks of the override after the original ones
+		overrides := grafthcl.BlocksByType(block.Body(), name)
+		for _, b := range overrides {
+			block.Body().RemoveBlock(b)
+		}
+		for _, b := range originals {
+			block.Body().AppendBlock(grafthcl.DeepCopyBlock(b))
+		}
+		for _, b := range overrides {
+			block.Body().AppendBlock(b)
+		}
+	}
+}
+
+// sourceOf returns the original expression at address, e.g.
+// "resource.azurerm_subnet.main.address_prefixes", "data.azurerm_client_config.current.tenant_id",
+// "module.network.address_space", "variable.location.default", "local.tags" or
+// "resource.azurerm_virtual_network.main.ddos_protection_plan.id". Nested blocks
+// in the path refer to the first block of that type.
+func sourceOf(address string, existingBlocks map[string]*hclwrite.Block, existingLocals map[string]*hclwrite.Attribute) (hclwrite.Tokens, error) {
+	parts := strings.Split(address, ".")
+
+	labelCount := 0
+	switch parts[0] {
+	case "local", "locals":
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("graft::source_of(%q): expected local.<name>", address)
+		}
+		attr, ok := existingLocals[parts[1]]
+		if !ok {
+			return nil, fmt.Errorf("graft::source_of(%q): local %s not found in module", address, parts[1])
+		}
+		return attr.Expr().BuildTokens(nil), nil
+	case "resource", "data":
+		labelCount = 2
+	case "module", "variable", "output", "provider":
+		labelCount = 1
+	case "terraform":
+	default:
+		return nil, fmt.Errorf("graft::source_of(%q): address must start with resource, data, module, variable, output, provider, terraform or local", address)
+	}
+	if len(parts) < labelCount+2 {
+		return nil, fmt.Errorf("graft::source_of(%q): address must end with an attribute name", address)
+	}
+
+	key := strings.Join(parts[:labelCount+1], ".")
+	if labelCount == 0 {
+		key += "."
+	}
+	block, ok := existingBlocks[key]
+	if !ok {
+		return nil, fmt.Errorf("graft::source_of(%q): %s not found in module", address, strings.Join(parts[:labelCount+1], "."))
+	}
+
+	body := block.Body()
+	path := parts[labelCount+1:]
+	for _, blockType := range path[:len(path)-1] {
+		nested := body.FirstMatchingBlock(blockType, nil)
+		if nested == nil {
+			return nil, fmt.Errorf("graft::source_of(%q): %s block not found", address, blockType)
+		}
+		body = nested.Body()
+	}
+	attr := body.GetAttribute(path[len(path)-1])
+	if attr == nil {
+		return nil, fmt.Errorf("graft::source_of(%q): attribute %s not found", address, path[len(path)-1])
+	}
+	return attr.Expr().BuildTokens(nil), nil
+}
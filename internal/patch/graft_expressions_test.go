@@ -0,0 +1,231 @@
+package patch
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+func TestFindGraftExpressions(t *testing.T) {
+	tests := []struct {
+		name     string
+		expr     string
+		expected []graftExpression
+		wantErr  bool
+	}{
+		{
+			name:     "source",
+			expr:     `merge(graft.source, { a = 1 })`,
+			expected: []graftExpression{{start: 2, end: 5, name: "source"}},
+		},
+		{
+			name:     "source_of",
+			expr:     `graft::source_of("resource.azurerm_subnet.main.name")`,
+			expected: []graftExpression{{start: 0, end: 8, name: "source_of", arg: "resource.azurerm_subnet.main.name"}},
+		},
+		{
+			name: "in template",
+			expr: `"${graft.module_key}-${graft.module_version}"`,
+			expected: []graftExpression{
+				{start: 2, end: 5, name: "module_key"},
+				{start: 8, end: 11, name: "module_version"},
+			},
+		},
+		{
+			name: "reference to an attribute named graft",
+			expr: `var.graft.source`,
+		},
+		{
+			name:    "source_of without argument",
+			expr:    `graft::source_of()`,
+			wantErr: true,
+		},
+		{
+			name:    "source_of as attribute",
+			expr:    `graft.source_of`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown function",
+			expr:    `graft::module("a")`,
+			wantErr: true,
+		},
+		{
+			name:    "source_of with expression argument",
+			expr:    `graft::source_of(var.address)`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := findGraftExpressions(getTokensFromExpr(tt.expr))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(got) != len(tt.expected) {
+				t.Fatalf("got %d expressions %v, expected %v", len(got), got, tt.expected)
+			}
+			for i := range got {
+				if got[i] != tt.expected[i] {
+					t.Errorf("expression %d = %+v, expected %+v", i, got[i], tt.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestResolveGraftExpressions(t *testing.T) {
+	existing := `
+resource "azurerm_subnet" "main" {
+  address_prefixes = ["10.0.1.0/24"]
+
+  delegation {
+    name = "aks"
+  }
+}
+
+resource "null_resource" "setup" {
+  provisioner "local-exec" {
+    command = "echo original"
+  }
+}
+
+locals {
+  tags = { env = "prod" }
+}
+`
+	tests := []struct {
+		name     string
+		override string
+		expected string
+		errMsg   string
+	}{
+		{
+			name: "module key and version",
+			override: `
+resource "azurerm_subnet" "main" {
+  name = "${graft.module_key}-${graft.module_version}"
+  tags = { version = graft.module_version }
+}
+`,
+			expected: `
+resource "azurerm_subnet" "main" {
+  name = "${"network"}-${"1.2.0"}"
+  tags = { version = "1.2.0" }
+}
+`,
+		},
+		{
+			name: "source_of",
+			override: `
+resource "azurerm_subnet" "other" {
+  address_prefixes = graft::source_of("resource.azurerm_subnet.main.address_prefixes")
+  delegation {
+    name = graft::source_of("resource.azurerm_subnet.main.delegation.name")
+  }
+  tags = merge(graft::source_of("local.tags"), graft.source)
+}
+`,
+			expected: `
+resource "azurerm_subnet" "other" {
+  address_prefixes = ["10.0.1.0/24"]
+  delegation {
+    name = "aks"
+  }
+  tags = merge({ env = "prod" }, graft.source)
+}
+`,
+		},
+		{
+			name: "original_block keeps original provisioners",
+			override: `
+resource "null_resource" "setup" {
+  provisioner = graft.original_block
+  provisioner "local-exec" {
+    command = "echo patched"
+  }
+}
+`,
+			expected: `
+resource "null_resource" "setup" {
+  provisioner "local-exec" {
+    command = "echo original"
+  }
+  provisioner "local-exec" {
+    command = "echo patched"
+  }
+}
+`,
+		},
+		{
+			name: "source_of unknown block",
+			override: `
+resource "azurerm_subnet" "main" {
+  name = graft::source_of("resource.azurerm_subnet.missing.name")
+}
+`,
+			errMsg: `resource.azurerm_subnet.main: name: graft::source_of("resource.azurerm_subnet.missing.name"): resource.azurerm_subnet.missing not found in module`,
+		},
+		{
+			name: "unknown expression",
+			override: `
+resource "azurerm_subnet" "main" {
+  delegation {
+    name = graft.module_name
+  }
+}
+`,
+			errMsg: "resource.azurerm_subnet.main: delegation: name: unknown expression graft.module_name",
+		},
+		{
+			name: "original_block inside an expression",
+			override: `
+resource "null_resource" "setup" {
+  triggers = merge(graft.original_block, {})
+}
+`,
+			errMsg: "graft.original_block must be the whole value of a top-level attribute",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, diags := hclwrite.ParseConfig([]byte(existing), "main.tf", hcl.Pos{Line: 1, Column: 1})
+			if diags.HasErrors() {
+				t.Fatalf("failed to parse existing blocks: %s", diags.Error())
+			}
+			existingBlocks := make(map[string]*hclwrite.Block)
+			for _, b := range f.Body().Blocks() {
+				existingBlocks[blockKey(b)] = b
+			}
+			existingLocals := f.Body().FirstMatchingBlock("locals", nil).Body().Attributes()
+
+			overrideBlocks := parseOverrideBlocks(t, "override {\n"+tt.override+"\n}")
+			err := resolveGraftExpressions("network", "1.2.0", overrideBlocks, existingBlocks, existingLocals)
+			if tt.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+					t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got := string(hclwrite.Format(overrideBlocks[0].BuildTokens(nil).Bytes()))
+			expected := string(hclwrite.Format([]byte(strings.TrimSpace(tt.expected) + "\n")))
+			if strings.TrimSpace(got) != strings.TrimSpace(expected) {
+				t.Errorf("got:\n%s\nexpected:\n%s", got, expected)
+			}
+		})
+	}
+}
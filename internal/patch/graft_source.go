@@ -3,6 +3,7 @@ package patch
 import (
 	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/hashicorp/hcl/v2/hclwrite"
+	grafthcl "github.com/ms-henglu/graft/internal/hcl"
 )
 
 func resolveGraftTokens(overrideBlocks []*hclwrite.Block, existingBlocks map[string]*hclwrite.Block, existingLocals map[string]*hclwrite.Attribute) {
@@ -20,46 +21,77 @@ func resolveGraftTokens(overrideBlocks []*hclwrite.Block, existingBlocks map[str
 			continue
 		}
 
+		// moved, import and removed blocks have no original block to refer to
+		if grafthcl.IsAdditiveBlock(block.Type()) {
+			continue
+		}
+
 		key := blockKey(block)
-		if existingBlock := existingBlocks[key]; existingBlock != nil {
-			resolveBodyGraftSource(block.Body(), existingBlock.Body())
+		existingBlock := existingBlocks[key]
+		if existingBlock == nil {
+			continue
+		}
+		for name, attr := range block.Body().Attributes() {
+			if tokens, ok := resolveGraftSource(name, attr, existingBlock.Body()); ok {
+				block.Body().SetAttributeRaw(name, tokens)
+			}
+		}
+
+		// Meta-argument blocks are unique by type and passed through as-is. Other
+		// nested blocks may repeat, e.g. ingress, so graft.source in them is
+		// resolved by deepMergeNestedBlock for each source block they are merged into.
+		for _, nested := range block.Body().Blocks() {
+			if !metaArgumentBlocks[nested.Type()] {
+				continue
+			}
+			// Without a matching block, e.g. no lifecycle in the source, graft.source is null
+			var matchingBody *hclwrite.Body
+			if matchingBlock := findMatchingBlock(existingBlock.Body(), nested); matchingBlock != nil {
+				matchingBody = matchingBlock.Body()
+			}
+			resolveBodyGraftSource(nested.Body(), matchingBody)
 		}
 	}
 }
 
+// resolveBodyGraftSource replaces graft.source in body and its nested blocks with
+// the expressions of originalBody. Nested blocks are matched by type and labels.
+// A nil originalBody resolves every graft.source to null.
 func resolveBodyGraftSource(body *hclwrite.Body, originalBody *hclwrite.Body) {
 	for name, attr := range body.Attributes() {
-		originalAttr := originalBody.GetAttribute(name)
+		if tokens, ok := resolveGraftSource(name, attr, originalBody); ok {
+			body.SetAttributeRaw(name, tokens)
+		}
+	}
 
-		var replacement hclwrite.Tokens
-		if originalAttr != nil {
-			replacement = originalAttr.Expr().BuildTokens(nil)
-		} else {
-			replacement = hclwrite.Tokens{
-				{
-					Type:  hclsyntax.TokenIdent,
-					Bytes: []byte("null"),
-				},
+	for _, block := range body.Blocks() {
+		var matchingBody *hclwrite.Body
+		if originalBody != nil {
+			if matchingBlock := findMatchingBlock(originalBody, block); matchingBlock != nil {
+				matchingBody = matchingBlock.Body()
 			}
 		}
+		resolveBodyGraftSource(block.Body(), matchingBody)
+	}
+}
+
+// resolveGraftSource returns the tokens of attr with graft.source replaced by the
+// expression of the attribute with the same name in originalBody, or null if
+// there is none. It reports whether attr refers to graft.source.
+func resolveGraftSource(name string, attr *hclwrite.Attribute, originalBody *hclwrite.Body) (hclwrite.Tokens, bool) {
+	tokens := attr.Expr().BuildTokens(nil)
 
-		newTokens := replaceGraftSourceTokens(attr.Expr().BuildTokens(nil), replacement)
-		if newTokens != nil {
-			body.SetAttributeRaw(name, newTokens)
+	var replacement hclwrite.Tokens
+	if originalBody != nil {
+		if originalAttr := originalBody.GetAttribute(name); originalAttr != nil {
+			replacement = originalAttr.Expr().BuildTokens(nil)
 		}
 	}
 
-	// Simple recursion for nested blocks that are unique by type
-	// If there are multiple blocks of the same type (like ingress), this naive matching
-	// might be ambiguous, but for attributes like lifecycle it works well.
-	for _, block := range body.Blocks() {
-		// Try to find matching block in originalBody
-		// We look for strict match on Type and Labels
-		matchingBlock := findMatchingBlock(originalBody, block)
-		if matchingBlock != nil {
-			resolveBodyGraftSource(block.Body(), matchingBlock.Body())
-		}
+	if newTokens := replaceGraftSourceTokens(tokens, replacement); newTokens != nil {
+		return newTokens, true
 	}
+	return tokens, false
 }
 
 func findMatchingBlock(body *hclwrite.Body, pattern *hclwrite.Block) *hclwrite.Block {
@@ -84,6 +116,8 @@ func findMatchingBlock(body *hclwrite.Body, pattern *hclwrite.Block) *hclwrite.B
 	return nil
 }
 
+// replaceGraftSourceTokens replaces graft.source in tokens with replacement, or
+// with null if replacement is empty. It returns nil if tokens don't refer to graft.source.
 func replaceGraftSourceTokens(tokens hclwrite.Tokens, replacement hclwrite.Tokens) hclwrite.Tokens {
 	if len(replacement) == 0 {
 		replacement = hclwrite.Tokens{
@@ -94,26 +128,12 @@ func replaceGraftSourceTokens(tokens hclwrite.Tokens, replacement hclwrite.Token
 		}
 	}
 
-	var newTokens hclwrite.Tokens
-	changed := false
-
-	for i := 0; i < len(tokens); i++ {
-		// Check for graft.source sequence: IDENT("graft") DOT IDENT("source")
-		if i+2 < len(tokens) &&
-			tokens[i].Type == hclsyntax.TokenIdent && string(tokens[i].Bytes) == "graft" &&
-			tokens[i+1].Type == hclsyntax.TokenDot &&
-			tokens[i+2].Type == hclsyntax.TokenIdent && string(tokens[i+2].Bytes) == "source" {
-
-			newTokens = append(newTokens, replacement...)
-			i += 2 // skip existing graft.source
-			changed = true
-		} else {
-			newTokens = append(newTokens, tokens[i])
+	// Malformed graft.* expressions are reported by resolveGraftExpressions
+	exprs, _ := findGraftExpressions(tokens)
+	return replaceGraftExpressions(tokens, exprs, func(e graftExpression) hclwrite.Tokens {
+		if e.name == "source" {
+			return replacement
 		}
-	}
-
-	if changed {
-		return newTokens
-	}
-	return nil
+		return nil
+	})
 }
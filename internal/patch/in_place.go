@@ -0,0 +1,106 @@
+package patch
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	grafthcl "github.com/ms-henglu/graft/internal/hcl"
+)
+
+// inPlaceNestedBlocks lists nested block types that Terraform silently ignores in
+// override files, keyed by the type of their parent block. For example, the merge
+// of a variable block only considers its arguments, so validation blocks in
+// _graft_override.tf would have no effect.
+//
+// Graft writes these blocks into the source block instead: all original nested
+// blocks of the same type are replaced by the ones from the manifest.
+var inPlaceNestedBlocks = map[string]map[string]bool{
+	"variable": {
+		"validation": true,
+	},
+}
+
+// applyInPlaceBlocks writes the in-place nested blocks of the override blocks into
+// the matching source blocks of the module, and removes them from the override
+// blocks. Override blocks without a source block are left untouched, since they
+// are added as a whole.
+func applyInPlaceBlocks(modulePath string, overrideBlocks []*hclwrite.Block) error {
+	replacements := make(map[string][]*hclwrite.Block)
+	for _, block := range overrideBlocks {
+		types := inPlaceNestedBlocks[block.Type()]
+		for _, nested := range block.Body().Blocks() {
+			if types[nested.Type()] {
+				replacements[blockKey(block)] = append(replacements[blockKey(block)], nested)
+			}
+		}
+	}
+	if len(replacements) == 0 {
+		return nil
+	}
+
+	entries, err := filepath.Glob(filepath.Join(modulePath, "*.tf"))
+	if err != nil {
+		return err
+	}
+
+	applied := make(map[string]bool)
+	for _, entry := range entries {
+		if strings.HasPrefix(filepath.Base(entry), "_graft_") {
+			continue
+		}
+
+		content, err := os.ReadFile(entry)
+		if err != nil {
+			return err
+		}
+
+		f, diags := hclwrite.ParseConfig(content, entry, hcl.Pos{Line: 1, Column: 1})
+		if diags.HasErrors() {
+			// Skip malformed files or files hclwrite can't parse
+			continue
+		}
+
+		isFileChanged := false
+		for _, block := range f.Body().Blocks() {
+			nestedBlocks, ok := replacements[blockKey(block)]
+			if !ok {
+				continue
+			}
+
+			types := inPlaceNestedBlocks[block.Type()]
+			for _, existing := range block.Body().Blocks() {
+				if types[existing.Type()] {
+					block.Body().RemoveBlock(existing)
+				}
+			}
+			for _, nested := range nestedBlocks {
+				block.Body().AppendBlock(grafthcl.DeepCopyBlock(nested))
+			}
+			applied[blockKey(block)] = true
+			isFileChanged = true
+		}
+
+		if isFileChanged {
+			if err := os.WriteFile(entry, f.Bytes(), 0644); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, block := range overrideBlocks {
+		if !applied[blockKey(block)] {
+			continue
+		}
+		types := inPlaceNestedBlocks[block.Type()]
+		for _, nested := range block.Body().Blocks() {
+			if types[nested.Type()] {
+				block.Body().RemoveBlock(nested)
+			}
+		}
+	}
+
+	return nil
+}
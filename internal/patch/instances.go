@@ -0,0 +1,314 @@
+package patch
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/ms-henglu/graft/internal/log"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// parseRemoveInstances evaluates the remove_instances attribute of a _graft block.
+// Keys are returned as cty values so that for_each keys (strings) and count
+// indexes (numbers) can be told apart.
+func parseRemoveInstances(b *hclwrite.Block) ([]cty.Value, error) {
+	attr := b.Body().GetAttribute("remove_instances")
+	if attr == nil {
+		return nil, nil
+	}
+
+	exprBytes := attr.Expr().BuildTokens(nil).Bytes()
+	expr, diags := hclsyntax.ParseExpression(exprBytes, "remove_instances", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("invalid remove_instances: %s", diags.Error())
+	}
+
+	val, diags := expr.Value(nil)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("invalid remove_instances: %s", diags.Error())
+	}
+	if !val.Type().IsTupleType() && !val.Type().IsListType() {
+		return nil, fmt.Errorf("invalid remove_instances: must be a list of instance keys")
+	}
+
+	var res []cty.Value
+	it := val.ElementIterator()
+	for it.Next() {
+		_, v := it.Element()
+		if v.IsNull() || (v.Type() != cty.String && v.Type() != cty.Number) {
+			return nil, fmt.Errorf("invalid remove_instances: keys must be strings or numbers")
+		}
+		res = append(res, v)
+	}
+	return res, nil
+}
+
+// removeInstances rewrites the for_each or count argument of a block so that the
+// given instances are no longer created.
+//
+// for_each is wrapped in a for expression that filters out the given keys:
+//
+//	for_each = { for k, v in (<original>) : k => v if !contains(["a", "b"], k) }
+//
+// count can't skip an index, so the remaining indexes are stored in a local next
+// to the block, and every count.index in the block is mapped onto them:
+//
+//	count = length(local._graft_resource_<type>_<name>_indexes)
+//	name  = "vm-${local._graft_resource_<type>_<name>_indexes[count.index]}"
+//
+// The instances after a removed index move to a lower index, which Terraform
+// plans as an update or replacement of those instances.
+func removeInstances(fileBody *hclwrite.Body, block *hclwrite.Block, keys []cty.Value) error {
+	body := block.Body()
+
+	if attr := body.GetAttribute("for_each"); attr != nil {
+		var list hclwrite.Tokens
+		for i, key := range keys {
+			if i > 0 {
+				list = append(list, &hclwrite.Token{Type: hclsyntax.TokenComma, Bytes: []byte(",")})
+			}
+			if key.Type() == cty.Number {
+				// for_each keys are always strings
+				key = cty.StringVal(key.AsBigFloat().Text('f', -1))
+			}
+			list = append(list, hclwrite.TokensForValue(key)...)
+		}
+
+		// Root overrides edit the source files in place, so a previous build
+		// may have wrapped for_each already. Its filter is replaced.
+		original := attr.Expr().BuildTokens(nil)
+		if unwrapped, ok := unwrapForEachFilter(original); ok {
+			original = unwrapped
+		}
+
+		var tokens hclwrite.Tokens
+		tokens = append(tokens, rawTokens("{ for k, v in")...)
+		tokens = append(tokens, original...)
+		tokens = append(tokens, rawTokens(" : k => v if !contains([")...)
+		tokens = append(tokens, list...)
+		tokens = append(tokens, rawTokens("], k) }")...)
+		body.SetAttributeRaw("for_each", tokens)
+		return nil
+	}
+
+	if attr := body.GetAttribute("count"); attr != nil {
+		var list hclwrite.Tokens
+		for i, key := range keys {
+			if i > 0 {
+				list = append(list, &hclwrite.Token{Type: hclsyntax.TokenComma, Bytes: []byte(",")})
+			}
+			index, ok := countIndex(key)
+			if !ok {
+				return fmt.Errorf("remove_instances for a count resource must contain whole numbers, got %s", key.GoString())
+			}
+			list = append(list, hclwrite.TokensForValue(cty.NumberIntVal(index))...)
+		}
+
+		local := fmt.Sprintf("_graft_%s_indexes", strings.Join(append([]string{block.Type()}, block.Labels()...), "_"))
+
+		// A previous build of a root override may have mapped count onto the
+		// local already. Only the removed indexes in the local are replaced then.
+		original := attr.Expr().BuildTokens(nil)
+		existing := findLocal(fileBody, local)
+		if strings.TrimSpace(string(original.Bytes())) == fmt.Sprintf("length(local.%s)", local) {
+			if existing == nil {
+				return fmt.Errorf("count refers to local.%s, which isn't defined in the same file", local)
+			}
+			unwrapped, ok := unwrapRemainingIndexes(existing.Expr().BuildTokens(nil))
+			if !ok {
+				return fmt.Errorf("local.%s isn't a list of remaining indexes", local)
+			}
+			original = unwrapped
+		} else if existing != nil {
+			return fmt.Errorf("local.%s is already defined", local)
+		}
+
+		var remaining hclwrite.Tokens
+		remaining = append(remaining, rawTokens("[for i in range(")...)
+		remaining = append(remaining, original...)
+		remaining = append(remaining, rawTokens(") : i if !contains([")...)
+		remaining = append(remaining, list...)
+		remaining = append(remaining, rawTokens("], i)]")...)
+
+		if existing != nil {
+			for _, locals := range fileBody.Blocks() {
+				if locals.Type() == "locals" && locals.Body().GetAttribute(local) != nil {
+					locals.Body().SetAttributeRaw(local, remaining)
+				}
+			}
+		} else {
+			fileBody.AppendNewline()
+			locals := fileBody.AppendNewBlock("locals", nil)
+			locals.Body().SetAttributeRaw(local, remaining)
+
+			replaceCountIndex(body, rawTokens(fmt.Sprintf("local.%s[count.index]", local)))
+			body.SetAttributeRaw("count", rawTokens(fmt.Sprintf("length(local.%s)", local)))
+		}
+
+		log.Warn(fmt.Sprintf("Removing instances of %s shifts the index of the instances after them", blockKey(block)))
+		return nil
+	}
+
+	return fmt.Errorf("remove_instances requires a for_each or count argument")
+}
+
+// unwrapForEachFilter returns the original for_each expression if tokens is a
+// for_each that removeInstances wrapped:
+//
+//	{ for k, v in (<original>) : k => v if !contains([...], k) }
+func unwrapForEachFilter(tokens hclwrite.Tokens) (hclwrite.Tokens, bool) {
+	src := tokens.Bytes()
+	expr, diags := hclsyntax.ParseExpression(src, "for_each", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, false
+	}
+	forExpr, ok := expr.(*hclsyntax.ForExpr)
+	if !ok || forExpr.KeyVar != "k" || forExpr.ValVar != "v" || forExpr.KeyExpr == nil || forExpr.Group {
+		return nil, false
+	}
+	if !isTraversalOf(forExpr.KeyExpr, "k") || !isTraversalOf(forExpr.ValExpr, "v") || !isContainsFilter(forExpr.CondExpr, "k") {
+		return nil, false
+	}
+	return sourceTokens(src, forExpr.CollExpr.Range()), true
+}
+
+// unwrapRemainingIndexes returns the original count expression if tokens is a
+// local that removeInstances defined:
+//
+//	[for i in range(<original>) : i if !contains([...], i)]
+func unwrapRemainingIndexes(tokens hclwrite.Tokens) (hclwrite.Tokens, bool) {
+	src := tokens.Bytes()
+	expr, diags := hclsyntax.ParseExpression(src, "locals", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, false
+	}
+	forExpr, ok := expr.(*hclsyntax.ForExpr)
+	if !ok || forExpr.KeyExpr != nil || forExpr.ValVar != "i" || !isTraversalOf(forExpr.ValExpr, "i") || !isContainsFilter(forExpr.CondExpr, "i") {
+		return nil, false
+	}
+	call, ok := forExpr.CollExpr.(*hclsyntax.FunctionCallExpr)
+	if !ok || call.Name != "range" || len(call.Args) != 1 {
+		return nil, false
+	}
+	return sourceTokens(src, call.Args[0].Range()), true
+}
+
+// isContainsFilter reports whether expr is !contains([...], <name>)
+func isContainsFilter(expr hclsyntax.Expression, name string) bool {
+	not, ok := expr.(*hclsyntax.UnaryOpExpr)
+	if !ok || not.Op != hclsyntax.OpLogicalNot {
+		return false
+	}
+	call, ok := not.Val.(*hclsyntax.FunctionCallExpr)
+	if !ok || call.Name != "contains" || len(call.Args) != 2 {
+		return false
+	}
+	_, isList := call.Args[0].(*hclsyntax.TupleConsExpr)
+	return isList && isTraversalOf(call.Args[1], name)
+}
+
+func isTraversalOf(expr hclsyntax.Expression, name string) bool {
+	traversal, ok := expr.(*hclsyntax.ScopeTraversalExpr)
+	return ok && len(traversal.Traversal) == 1 && traversal.Traversal.RootName() == name
+}
+
+// sourceTokens lexes the source text in rng, keeping one space before it
+func sourceTokens(src []byte, rng hcl.Range) hclwrite.Tokens {
+	tokens := rawTokens(string(rng.SliceBytes(src)))
+	if len(tokens) > 0 {
+		tokens[0].SpacesBefore = 1
+	}
+	return tokens
+}
+
+// findLocal returns the attribute that defines a local in body
+func findLocal(body *hclwrite.Body, name string) *hclwrite.Attribute {
+	for _, block := range body.Blocks() {
+		if block.Type() != "locals" {
+			continue
+		}
+		if attr := block.Body().GetAttribute(name); attr != nil {
+			return attr
+		}
+	}
+	return nil
+}
+
+func countIndex(key cty.Value) (int64, bool) {
+	if key.Type() == cty.String {
+		var err error
+		key, err = cty.ParseNumberVal(key.AsString())
+		if err != nil {
+			return 0, false
+		}
+	}
+	bf := key.AsBigFloat()
+	if !bf.IsInt() || bf.Sign() < 0 {
+		return 0, false
+	}
+	index, accuracy := bf.Int64()
+	return index, accuracy == big.Exact
+}
+
+// replaceCountIndex replaces every count.index in the attributes of body and its
+// nested blocks with the given tokens
+func replaceCountIndex(body *hclwrite.Body, replacement hclwrite.Tokens) {
+
+	for name, attr := range body.Attributes() {
+		if name == "count" {
+			continue
+		}
+		tokens := attr.Expr().BuildTokens(nil)
+		var newTokens hclwrite.Tokens
+		changed := false
+		for i := 0; i < len(tokens); i++ {
+			if i+2 < len(tokens) &&
+				tokens[i].Type == hclsyntax.TokenIdent && string(tokens[i].Bytes) == "count" &&
+				tokens[i+1].Type == hclsyntax.TokenDot &&
+				tokens[i+2].Type == hclsyntax.TokenIdent && string(tokens[i+2].Bytes) == "index" &&
+				(i == 0 || tokens[i-1].Type != hclsyntax.TokenDot) {
+				for j, token := range replacement {
+					copied := *token
+					if j == 0 {
+						copied.SpacesBefore = tokens[i].SpacesBefore
+					}
+					newTokens = append(newTokens, &copied)
+				}
+				i += 2
+				changed = true
+				continue
+			}
+			newTokens = append(newTokens, tokens[i])
+		}
+		if changed {
+			body.SetAttributeRaw(name, newTokens)
+		}
+	}
+
+	for _, nested := range body.Blocks() {
+		replaceCountIndex(nested.Body(), replacement)
+	}
+}
+
+// rawTokens lexes a fragment of HCL into tokens
+func rawTokens(src string) hclwrite.Tokens {
+	lexed, _ := hclsyntax.LexExpression([]byte(src), "", hcl.Pos{Line: 1, Column: 1})
+	var tokens hclwrite.Tokens
+	prevEnd := 0
+	for _, t := range lexed {
+		if t.Type == hclsyntax.TokenEOF {
+			continue
+		}
+		tokens = append(tokens, &hclwrite.Token{
+			Type:         t.Type,
+			Bytes:        t.Bytes,
+			SpacesBefore: t.Range.Start.Byte - prevEnd,
+		})
+		prevEnd = t.Range.End.Byte
+	}
+	return tokens
+}
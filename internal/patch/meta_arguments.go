@@ -0,0 +1,378 @@
+package patch
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/ms-henglu/graft/internal/log"
+	"github.com/ms-henglu/graft/internal/utils"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// instanceKeying returns the meta-argument that creates instances of a block:
+// "count", "for_each" or "" for a single instance
+func instanceKeying(body *hclwrite.Body) string {
+	if body.GetAttribute("for_each") != nil {
+		return "for_each"
+	}
+	if body.GetAttribute("count") != nil {
+		return "count"
+	}
+	return ""
+}
+
+// applyInstanceKeying handles overrides that change how a resource or module
+// block creates instances, e.g. from count to for_each. Terraform would see both
+// meta-arguments after the override, so the original one is commented out in
+// the source block, where later builds of root overrides find it again. Since
+// the instance addresses change, a warning is logged. A moved block is only
+// returned when a single instance becomes a single instance, e.g. count = 1 to
+// one for_each key. With more instances, which old instance matches which new
+// one depends on the attributes, so the moved blocks are left to the user.
+func applyInstanceKeying(modulePath string, overrideBlocks []*hclwrite.Block) ([]*hclwrite.Block, error) {
+	overrides := make(map[string]*hclwrite.Block)
+	for _, block := range overrideBlocks {
+		switch block.Type() {
+		case "resource", "data", "module":
+		default:
+			continue
+		}
+		body := block.Body()
+		if body.GetAttribute("count") != nil && body.GetAttribute("for_each") != nil {
+			return nil, fmt.Errorf("%s: count and for_each can't be set together", blockKey(block))
+		}
+		if instanceKeying(body) != "" {
+			overrides[blockKey(block)] = block
+		}
+	}
+	if len(overrides) == 0 {
+		return nil, nil
+	}
+
+	entries, err := filepath.Glob(filepath.Join(modulePath, "*.tf"))
+	if err != nil {
+		return nil, err
+	}
+
+	var moved []*hclwrite.Block
+	for _, entry := range entries {
+		if strings.HasPrefix(filepath.Base(entry), "_graft_") {
+			continue
+		}
+
+		content, err := os.ReadFile(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		f, diags := hclwrite.ParseConfig(content, entry, hcl.Pos{Line: 1, Column: 1})
+		if diags.HasErrors() {
+			// Skip malformed files or files hclwrite can't parse
+			continue
+		}
+
+		isFileChanged := false
+		for _, block := range f.Body().Blocks() {
+			override, ok := overrides[blockKey(block)]
+			if !ok {
+				continue
+			}
+			oldKeying := instanceKeying(block.Body())
+			oldAttr := block.Body().GetAttribute(oldKeying)
+			if oldKeying == "" {
+				// A previous build of a root override commented it out
+				oldKeying, oldAttr = replacedKeying(block.Body())
+			}
+			newKeying := instanceKeying(override.Body())
+			if oldKeying == newKeying {
+				continue
+			}
+
+			address := strings.Join(blockAddress(block.Type(), block.Labels()), ".")
+			log.Warn(fmt.Sprintf("%s: the override changes %s to %s, which changes the instance addresses", address, keyingName(oldKeying), keyingName(newKeying)))
+
+			oldKeys, oldKnown := []cty.Value{cty.NilVal}, true
+			if oldKeying != "" {
+				oldKeys, oldKnown = staticInstanceKeys(oldKeying, oldAttr)
+			}
+			if block.Body().GetAttribute(oldKeying) != nil {
+				commentOutKeying(block.Body(), oldKeying)
+				isFileChanged = true
+			}
+			newKeys, newKnown := staticInstanceKeys(newKeying, override.Body().GetAttribute(newKeying))
+
+			for _, ref := range instanceReferences(block, oldKeying, override) {
+				log.Warn(fmt.Sprintf("%s: %s still refers to %s", address, ref, oldKeying))
+			}
+
+			// Data sources have no state to move
+			if block.Type() == "data" {
+				continue
+			}
+			if !oldKnown || !newKnown || len(oldKeys) != 1 || len(newKeys) != 1 {
+				log.Warn(fmt.Sprintf("%s: graft can't tell which old instance becomes which new one, add moved blocks to the override to keep the existing instances", address))
+				continue
+			}
+			movedBlock := hclwrite.NewBlock("moved", nil)
+			movedBlock.Body().SetAttributeTraversal("from", instanceTraversal(block, oldKeys[0]))
+			movedBlock.Body().SetAttributeTraversal("to", instanceTraversal(block, newKeys[0]))
+			moved = append(moved, movedBlock)
+		}
+
+		if isFileChanged {
+			if err := os.WriteFile(entry, f.Bytes(), 0644); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return moved, nil
+}
+
+// replacedKeyingComment starts the comment that replaces the original count or
+// for_each of a source block
+const replacedKeyingComment = "# Replaced by a graft override:"
+
+// commentOutKeying replaces the count or for_each attribute of body with a
+// comment at the end of the body:
+//
+//	# Replaced by a graft override:
+//	# count = 2
+func commentOutKeying(body *hclwrite.Body, keying string) {
+	attr := body.GetAttribute(keying)
+	tokens := hclwrite.Tokens{
+		{Type: hclsyntax.TokenIdent, Bytes: []byte(keying)},
+		{Type: hclsyntax.TokenEqual, Bytes: []byte("=")},
+	}
+	tokens = append(tokens, attr.Expr().BuildTokens(nil)...)
+	text := strings.TrimSpace(string(hclwrite.Format(tokens.Bytes())))
+	body.RemoveAttribute(keying)
+
+	comments := hclwrite.Tokens{{Type: hclsyntax.TokenComment, Bytes: []byte(replacedKeyingComment + "\n")}}
+	for _, line := range strings.Split(text, "\n") {
+		comments = append(comments, &hclwrite.Token{Type: hclsyntax.TokenComment, Bytes: []byte("# " + line + "\n")})
+	}
+	body.AppendUnstructuredTokens(comments)
+}
+
+// replacedKeying returns the count or for_each that commentOutKeying commented
+// out of body, or "" if there is none
+func replacedKeying(body *hclwrite.Body) (string, *hclwrite.Attribute) {
+	tokens := body.BuildTokens(nil)
+	for i, token := range tokens {
+		if token.Type != hclsyntax.TokenComment || strings.TrimSpace(string(token.Bytes)) != replacedKeyingComment {
+			continue
+		}
+		var sb strings.Builder
+		for _, line := range tokens[i+1:] {
+			if line.Type != hclsyntax.TokenComment || !strings.HasPrefix(string(line.Bytes), "# ") {
+				break
+			}
+			sb.WriteString(strings.TrimPrefix(string(line.Bytes), "# "))
+		}
+		f, diags := hclwrite.ParseConfig([]byte(sb.String()), "", hcl.Pos{Line: 1, Column: 1})
+		if diags.HasErrors() {
+			return "", nil
+		}
+		if keying := instanceKeying(f.Body()); keying != "" {
+			return keying, f.Body().GetAttribute(keying)
+		}
+	}
+	return "", nil
+}
+
+func keyingName(keying string) string {
+	if keying == "" {
+		return "a single instance"
+	}
+	return keying
+}
+
+// staticInstanceKeys returns the instance keys created by a count or for_each
+// attribute, if they can be evaluated at build time. count must be a number,
+// for_each a map literal or toset() of a list literal. Keys are returned in the
+// order Terraform uses for instances.
+func staticInstanceKeys(keying string, attr *hclwrite.Attribute) ([]cty.Value, bool) {
+	expr, diags := hclsyntax.ParseExpression(attr.Expr().BuildTokens(nil).Bytes(), keying, hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, false
+	}
+
+	if keying == "count" {
+		val, diags := expr.Value(nil)
+		if diags.HasErrors() || val.Type() != cty.Number || !val.IsKnown() || val.IsNull() {
+			return nil, false
+		}
+		count, ok := countIndex(val)
+		if !ok {
+			return nil, false
+		}
+		keys := make([]cty.Value, count)
+		for i := range keys {
+			keys[i] = cty.NumberIntVal(int64(i))
+		}
+		return keys, true
+	}
+
+	var names []string
+	if call, ok := expr.(*hclsyntax.FunctionCallExpr); ok && call.Name == "toset" && len(call.Args) == 1 {
+		val, diags := call.Args[0].Value(nil)
+		if diags.HasErrors() || !val.Type().IsTupleType() || !val.IsWhollyKnown() {
+			return nil, false
+		}
+		seen := make(map[string]bool)
+		for _, elem := range val.AsValueSlice() {
+			if elem.Type() != cty.String || elem.IsNull() {
+				return nil, false
+			}
+			if !seen[elem.AsString()] {
+				seen[elem.AsString()] = true
+				names = append(names, elem.AsString())
+			}
+		}
+		sort.Strings(names)
+	} else {
+		val, diags := expr.Value(nil)
+		if diags.HasErrors() || !val.Type().IsObjectType() || !val.IsKnown() || val.IsNull() {
+			return nil, false
+		}
+		names = utils.SortedKeys(val.AsValueMap())
+	}
+
+	keys := make([]cty.Value, len(names))
+	for i, name := range names {
+		keys[i] = cty.StringVal(name)
+	}
+	return keys, true
+}
+
+// instanceTraversal returns the address of an instance of block, e.g.
+// azurerm_subnet.main[0] or module.network["east"]. A nil key addresses the
+// block without an index.
+func instanceTraversal(block *hclwrite.Block, key cty.Value) hcl.Traversal {
+	traversal := traversalFor(blockAddress(block.Type(), block.Labels()))
+	if key != cty.NilVal {
+		traversal = append(traversal, hcl.TraverseIndex{Key: key})
+	}
+	return traversal
+}
+
+// instanceReferences returns the attributes of block that refer to count.index or
+// each after the keying changed from oldKeying, leaving out top-level attributes
+// that the override replaces
+func instanceReferences(block *hclwrite.Block, oldKeying string, override *hclwrite.Block) []string {
+	root := map[string]string{"count": "count", "for_each": "each"}[oldKeying]
+	if root == "" {
+		return nil
+	}
+
+	var refs []string
+	var visit func(body *hclwrite.Body, path string)
+	visit = func(body *hclwrite.Body, path string) {
+		attrs := body.Attributes()
+		for _, name := range utils.SortedKeys(attrs) {
+			if path == "" && override.Body().GetAttribute(name) != nil {
+				continue
+			}
+			tokens := attrs[name].Expr().BuildTokens(nil)
+			for i := 0; i+1 < len(tokens); i++ {
+				if tokens[i].Type == hclsyntax.TokenIdent && string(tokens[i].Bytes) == root && tokens[i+1].Type == hclsyntax.TokenDot &&
+					(i == 0 || tokens[i-1].Type != hclsyntax.TokenDot) {
+					refs = append(refs, path+name)
+					break
+				}
+			}
+		}
+		for _, nested := range body.Blocks() {
+			visit(nested.Body(), path+nested.Type()+".")
+		}
+	}
+	visit(block.Body(), "")
+	return refs
+}
+
+// staticListAttributes are meta-arguments that Terraform requires to be a static
+// list, so graft.source can't be combined with them at plan time. They are
+// keyed by the type of the block that contains them.
+var staticListAttributes = map[string]map[string]bool{
+	"":          {"depends_on": true},
+	"lifecycle": {"ignore_changes": true, "replace_triggered_by": true},
+}
+
+// flattenStaticLists rewrites concat(...) of list literals in static list
+// meta-arguments into a single list, so that an override can append to the
+// original value with depends_on = concat(graft.source, [azurerm_subnet.main]).
+// Duplicate elements and null (an unset original value) are dropped.
+func flattenStaticLists(overrideBlocks []*hclwrite.Block) {
+	for _, block := range overrideBlocks {
+		flattenStaticListAttributes(block.Body(), staticListAttributes[""])
+		for _, nested := range block.Body().Blocks() {
+			if names, ok := staticListAttributes[nested.Type()]; ok {
+				flattenStaticListAttributes(nested.Body(), names)
+			}
+		}
+	}
+}
+
+func flattenStaticListAttributes(body *hclwrite.Body, names map[string]bool) {
+	for name, attr := range body.Attributes() {
+		if !names[name] {
+			continue
+		}
+		src := attr.Expr().BuildTokens(nil).Bytes()
+		expr, diags := hclsyntax.ParseExpression(src, name, hcl.Pos{Line: 1, Column: 1})
+		if diags.HasErrors() {
+			continue
+		}
+		call, ok := expr.(*hclsyntax.FunctionCallExpr)
+		if !ok || call.Name != "concat" {
+			continue
+		}
+		if name == "ignore_changes" && ignoresAll(call.Args) {
+			body.SetAttributeRaw(name, rawTokens("all"))
+			continue
+		}
+
+		var elems []string
+		seen := make(map[string]bool)
+		flattened := true
+		for _, arg := range call.Args {
+			switch a := arg.(type) {
+			case *hclsyntax.TupleConsExpr:
+				for _, elem := range a.Exprs {
+					text := string(elem.Range().SliceBytes(src))
+					if !seen[text] {
+						seen[text] = true
+						elems = append(elems, text)
+					}
+				}
+			case *hclsyntax.LiteralValueExpr:
+				if !a.Val.IsNull() {
+					flattened = false
+				}
+			default:
+				flattened = false
+			}
+		}
+		if flattened {
+			body.SetAttributeRaw(name, rawTokens("["+strings.Join(elems, ", ")+"]"))
+		}
+	}
+}
+
+// ignoresAll reports whether one of the concat() arguments is the keyword all,
+// e.g. when graft.source resolved to ignore_changes = all
+func ignoresAll(args []hclsyntax.Expression) bool {
+	for _, arg := range args {
+		if hcl.ExprAsKeyword(arg) == "all" {
+			return true
+		}
+	}
+	return false
+}
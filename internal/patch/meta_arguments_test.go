@@ -0,0 +1,348 @@
+package patch
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/ms-henglu/graft/internal/manifest"
+)
+
+func TestApplyOverrides_MetaArguments(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		override string
+		expected map[string]string
+	}{
+		{
+			name: "graft.source in for_each and count",
+			files: map[string]string{
+				"main.tf": `resource "azurerm_subnet" "this" {
+  for_each = var.subnets
+  name     = each.key
+}
+
+resource "azurerm_public_ip" "this" {
+  count = var.ip_count
+  name  = "pip-${count.index}"
+}
+`,
+			},
+			override: `
+override {
+  resource "azurerm_subnet" "this" {
+    for_each = merge(graft.source, var.extra_subnets)
+  }
+  resource "azurerm_public_ip" "this" {
+    count = graft.source + 1
+  }
+}
+`,
+			expected: map[string]string{
+				"_graft_override.tf": `resource "azurerm_subnet" "this" {
+  for_each = merge(var.subnets, var.extra_subnets)
+}
+resource "azurerm_public_ip" "this" {
+  count = var.ip_count + 1
+}
+`,
+			},
+		},
+		{
+			name: "append to depends_on and ignore_changes",
+			files: map[string]string{
+				"main.tf": `resource "azurerm_subnet" "this" {
+  name       = "snet"
+  depends_on = [azurerm_virtual_network.main]
+
+  lifecycle {
+    ignore_changes = [tags]
+  }
+}
+
+module "app" {
+  source = "./app"
+}
+`,
+			},
+			override: `
+override {
+  resource "azurerm_subnet" "this" {
+    depends_on = concat(graft.source, [azurerm_route_table.main, azurerm_virtual_network.main])
+    lifecycle {
+      ignore_changes = concat(graft.source, [name])
+    }
+  }
+  module "app" {
+    depends_on = concat(graft.source, [azurerm_subnet.this])
+  }
+}
+`,
+			expected: map[string]string{
+				"_graft_override.tf": `resource "azurerm_subnet" "this" {
+  depends_on = [azurerm_virtual_network.main, azurerm_route_table.main]
+  lifecycle {
+    ignore_changes = [tags, name]
+  }
+}
+module "app" {
+  depends_on = [azurerm_subnet.this]
+}
+`,
+			},
+		},
+		{
+			name: "append absorbed drift to ignore_changes",
+			files: map[string]string{
+				"main.tf": `resource "azurerm_subnet" "this" {
+  name = "snet"
+}
+
+resource "azurerm_virtual_network" "this" {
+  name = "vnet"
+
+  lifecycle {
+    ignore_changes = all
+  }
+}
+`,
+			},
+			override: `
+override {
+  resource "azurerm_subnet" "this" {
+    lifecycle {
+      ignore_changes = concat(graft.source, [tags["CreatedOn"], name])
+    }
+  }
+  resource "azurerm_virtual_network" "this" {
+    lifecycle {
+      ignore_changes = concat(graft.source, [tags])
+    }
+  }
+}
+`,
+			expected: map[string]string{
+				"_graft_override.tf": `resource "azurerm_subnet" "this" {
+  lifecycle {
+    ignore_changes = [tags["CreatedOn"], name]
+  }
+}
+resource "azurerm_virtual_network" "this" {
+  lifecycle {
+    ignore_changes = all
+  }
+}
+`,
+			},
+		},
+		{
+			name: "count to for_each with several instances",
+			files: map[string]string{
+				"main.tf": `resource "azurerm_public_ip" "this" {
+  count = 2
+  name  = "pip-${count.index}"
+}
+`,
+			},
+			override: `
+override {
+  resource "azurerm_public_ip" "this" {
+    for_each = toset(["web", "api"])
+    name     = "pip-${each.key}"
+  }
+}
+`,
+			expected: map[string]string{
+				"main.tf": `resource "azurerm_public_ip" "this" {
+  name = "pip-${count.index}"
+  # Replaced by a graft override:
+  # count = 2
+}
+`,
+				"_graft_override.tf": `resource "azurerm_public_ip" "this" {
+  for_each = toset(["web", "api"])
+  name     = "pip-${each.key}"
+}
+`,
+			},
+		},
+		{
+			name: "count of one to a single for_each key",
+			files: map[string]string{
+				"main.tf": `resource "azurerm_public_ip" "this" {
+  count = 1
+  name  = "pip"
+}
+`,
+			},
+			override: `
+override {
+  resource "azurerm_public_ip" "this" {
+    for_each = toset(["web"])
+  }
+}
+`,
+			expected: map[string]string{
+				"_graft_add.tf": `moved {
+  from = azurerm_public_ip.this[0]
+  to   = azurerm_public_ip.this["web"]
+}
+`,
+			},
+		},
+		{
+			name: "single instance to for_each",
+			files: map[string]string{
+				"main.tf": `module "network" {
+  source = "./network"
+}
+`,
+			},
+			override: `
+override {
+  module "network" {
+    for_each = { east = "eastus" }
+  }
+}
+`,
+			expected: map[string]string{
+				"_graft_add.tf": `moved {
+  from = module.network
+  to   = module.network["east"]
+}
+`,
+			},
+		},
+		{
+			name: "for_each to count with unknown keys",
+			files: map[string]string{
+				"main.tf": `resource "azurerm_subnet" "this" {
+  for_each = var.subnets
+  name     = each.key
+}
+`,
+			},
+			override: `
+override {
+  resource "azurerm_subnet" "this" {
+    count = length(var.subnet_names)
+    name  = var.subnet_names[count.index]
+  }
+}
+`,
+			expected: map[string]string{
+				"main.tf": `resource "azurerm_subnet" "this" {
+  name = each.key
+  # Replaced by a graft override:
+  # for_each = var.subnets
+}
+`,
+				"_graft_override.tf": `resource "azurerm_subnet" "this" {
+  count = length(var.subnet_names)
+  name  = var.subnet_names[count.index]
+}
+`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+					t.Fatalf("failed to create file %s: %v", name, err)
+				}
+			}
+
+			if err := applyOverrides("app", manifest.Module{OverrideBlocks: parseOverrideBlocks(t, tt.override)}, dir); err != nil {
+				t.Fatalf("applyOverrides failed: %v", err)
+			}
+
+			for name, expected := range tt.expected {
+				content, err := os.ReadFile(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatalf("failed to read file %s: %v", name, err)
+				}
+				got := string(hclwrite.Format(content))
+				if strings.TrimSpace(got) != strings.TrimSpace(string(hclwrite.Format([]byte(expected)))) {
+					t.Errorf("file %s content mismatch.\nGot:\n%s\nExpected:\n%s", name, got, expected)
+				}
+			}
+			if _, ok := tt.expected["_graft_add.tf"]; !ok {
+				if _, err := os.Stat(filepath.Join(dir, "_graft_add.tf")); err == nil {
+					t.Errorf("expected _graft_add.tf not to be generated")
+				}
+			}
+		})
+	}
+}
+
+func TestApplyOverrides_InstanceKeyingTwice(t *testing.T) {
+	// Root overrides edit the source files in place, so the second build has
+	// to find the original keying in the comment
+	dir := t.TempDir()
+	source := `module "network" {
+  source = "./network"
+  count  = 1
+}
+`
+	if err := os.WriteFile(filepath.Join(dir, "main.tf"), []byte(source), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	override := `
+override {
+  module "network" {
+    for_each = {
+      east = "eastus"
+    }
+  }
+}
+`
+
+	for i := 0; i < 2; i++ {
+		if err := applyOverrides("root", manifest.Module{OverrideBlocks: parseOverrideBlocks(t, override)}, dir); err != nil {
+			t.Fatalf("build %d: applyOverrides failed: %v", i+1, err)
+		}
+	}
+
+	expected := map[string]string{
+		"main.tf": `module "network" {
+  source = "./network"
+  # Replaced by a graft override:
+  # count = 1
+}`,
+		"_graft_add.tf": `moved {
+  from = module.network[0]
+  to   = module.network["east"]
+}`,
+	}
+	for name, want := range expected {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("failed to read file %s: %v", name, err)
+		}
+		if got := strings.TrimSpace(string(hclwrite.Format(content))); got != want {
+			t.Errorf("file %s content mismatch.\nGot:\n%s\nExpected:\n%s", name, got, want)
+		}
+	}
+}
+
+func TestApplyOverrides_CountAndForEach(t *testing.T) {
+	dir := t.TempDir()
+	override := `
+override {
+  resource "azurerm_subnet" "this" {
+    count    = 1
+    for_each = var.subnets
+  }
+}
+`
+	err := applyOverrides("app", manifest.Module{OverrideBlocks: parseOverrideBlocks(t, override)}, dir)
+	if err == nil || !strings.Contains(err.Error(), "count and for_each can't be set together") {
+		t.Errorf("expected count and for_each error, got %v", err)
+	}
+}
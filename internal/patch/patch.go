@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/hashicorp/hcl/v2/hclwrite"
+	grafthcl "github.com/ms-henglu/graft/internal/hcl"
 	"github.com/ms-henglu/graft/internal/log"
 	"github.com/ms-henglu/graft/internal/manifest"
 	"github.com/ms-henglu/graft/internal/utils"
@@ -21,7 +23,7 @@ func ApplyPatches(vendorMap map[string]string, m *manifest.Manifest) error {
 			return err
 		}
 
-		err = applyOverrides("root", cwd, m.RootOverrides)
+		err = applyOverrides("root", manifest.Module{OverrideBlocks: m.RootOverrides}, cwd)
 		if err != nil {
 			return err
 		}
@@ -38,30 +40,83 @@ func ApplyPatches(vendorMap map[string]string, m *manifest.Manifest) error {
 			continue
 		}
 
-		err := applyOverrides(modKey, vendorPath, mod.OverrideBlocks)
+		err := applyOverrides(modKey, mod, vendorPath)
 		if err != nil {
 			return err
 		}
 
-		count := len(mod.OverrideBlocks)
-		suffix := "s"
-		if count == 1 {
-			suffix = ""
+		summary := fmt.Sprintf("%s: %s", modKey, plural(len(mod.OverrideBlocks), "override"))
+		if len(mod.FilePatches) > 0 {
+			summary += ", " + plural(len(mod.FilePatches), "file patch")
 		}
-		log.Item(fmt.Sprintf("%s: %d override%s", modKey, count, suffix))
+		log.Item(summary)
 		log.Debug("Patched module %s in %s", modKey, vendorPath)
 	}
 	return nil
 }
 
-func applyOverrides(modKey string, modulePath string, overrideBlocks []*hclwrite.Block) error {
-	// First, apply removals before reading existing blocks
+func plural(count int, noun string) string {
+	suffix := "s"
+	if count == 1 {
+		suffix = ""
+	} else if strings.HasSuffix(noun, "ch") {
+		suffix = "es"
+	}
+	return fmt.Sprintf("%d %s%s", count, noun, suffix)
+}
+
+func applyOverrides(modKey string, mod manifest.Module, modulePath string) error {
+	overrideBlocks := mod.OverrideBlocks
+	err := validateMatches(overrideBlocks)
+	if err != nil {
+		return fmt.Errorf("invalid override for %s: %w", modKey, err)
+	}
+
+	// Renames come first so that the other stages see the new block names
+	moved, err := applyRenames(modulePath, overrideBlocks)
+	if err != nil {
+		return fmt.Errorf("failed to apply renames for %s: %w", modKey, err)
+	}
+	if len(moved) > 0 {
+		overrideBlocks = append(append([]*hclwrite.Block(nil), overrideBlocks...), moved...)
+	}
+
+	// Then apply removals before reading existing blocks
 	// This ensures that removed blocks won't be included in deep merge
-	err := applyRemovals(modulePath, overrideBlocks)
+	err = applyRemovals(modulePath, overrideBlocks)
 	if err != nil {
 		return fmt.Errorf("failed to calculate removals for %s: %w", modKey, err)
 	}
 
+	// File patches see the module after removals, like the overrides that follow
+	err = applyFilePatches(modulePath, mod.FilePatches)
+	if err != nil {
+		return fmt.Errorf("failed to apply file patches for %s: %w", modKey, err)
+	}
+
+	if modKey != "root" {
+		for _, block := range overrideBlocks {
+			if block.Type() == "import" {
+				return fmt.Errorf("import blocks are only allowed in the root module, but module %s has one", modKey)
+			}
+		}
+	}
+
+	// Nested blocks that Terraform ignores in override files are written into the source
+	err = applyInPlaceBlocks(modulePath, overrideBlocks)
+	if err != nil {
+		return fmt.Errorf("failed to apply in-place blocks for %s: %w", modKey, err)
+	}
+
+	// An override that switches between count and for_each replaces the original meta-argument
+	moved, err = applyInstanceKeying(modulePath, overrideBlocks)
+	if err != nil {
+		return fmt.Errorf("failed to apply instance keying for %s: %w", modKey, err)
+	}
+	if len(moved) > 0 {
+		overrideBlocks = append(append([]*hclwrite.Block(nil), overrideBlocks...), moved...)
+	}
+
 	// Now read existing blocks after removals have been applied
 	existingBlocks, err := listBlocks(modulePath)
 	if err != nil {
@@ -73,7 +128,12 @@ func applyOverrides(modKey string, modulePath string, overrideBlocks []*hclwrite
 		return fmt.Errorf("failed to list locals: %w", err)
 	}
 
+	err = resolveGraftExpressions(modKey, mod.Version, overrideBlocks, existingBlocks, existingLocals)
+	if err != nil {
+		return fmt.Errorf("failed to resolve graft expressions for %s: %w", modKey, err)
+	}
 	resolveGraftTokens(overrideBlocks, existingBlocks, existingLocals)
+	flattenStaticLists(overrideBlocks)
 
 	overrideFile := generateOverrideFile(overrideBlocks, existingBlocks, existingLocals)
 	if len(overrideFile.Body().Attributes()) > 0 || len(overrideFile.Body().Blocks()) > 0 {
@@ -113,10 +173,12 @@ func generateAddFile(overrideBlocks []*hclwrite.Block, existingBlocks map[string
 			continue
 		}
 		key := blockKey(block)
-		if existingBlocks[key] == nil {
+		// moved, import and removed blocks can't be overridden, they are always added
+		if grafthcl.IsAdditiveBlock(block.Type()) || existingBlocks[key] == nil {
 			if len(block.Body().Attributes()) == 0 && len(block.Body().Blocks()) == 0 {
 				continue
 			}
+			stripNestedGraftBlocks(block)
 			bAdd.AppendBlock(block)
 		}
 	}
@@ -130,6 +192,10 @@ func generateOverrideFile(overrideBlocks []*hclwrite.Block, existingBlocks map[s
 	for _, block := range overrideBlocks {
 		key := blockKey(block)
 
+		if grafthcl.IsAdditiveBlock(block.Type()) {
+			continue
+		}
+
 		if block.Type() == "locals" {
 			newLocals := hclwrite.NewBlock("locals", nil)
 			hasAttr := false
@@ -0,0 +1,377 @@
+package patch
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/ms-henglu/graft/internal/log"
+	"github.com/ms-henglu/graft/internal/utils"
+)
+
+// blockAddress returns the traversal used to refer to a top-level block from within
+// its module, e.g. ["azurerm_subnet", "main"], ["data", "azurerm_client_config", "current"],
+// ["module", "network"] or ["var", "location"]. It returns nil for blocks that can't be
+// referenced.
+func blockAddress(blockType string, labels []string) []string {
+	switch blockType {
+	case "resource":
+		if len(labels) == 2 {
+			return []string{labels[0], labels[1]}
+		}
+	case "data":
+		if len(labels) == 2 {
+			return []string{"data", labels[0], labels[1]}
+		}
+	case "module":
+		if len(labels) == 1 {
+			return []string{"module", labels[0]}
+		}
+	case "variable":
+		if len(labels) == 1 {
+			return []string{"var", labels[0]}
+		}
+	}
+	return nil
+}
+
+// hasAddressPrefix reports whether traversal refers to the given address
+func hasAddressPrefix(traversal hcl.Traversal, address []string) bool {
+	if len(address) == 0 || len(traversal) < len(address) || traversal.RootName() != address[0] {
+		return false
+	}
+	for i := 1; i < len(address); i++ {
+		attr, ok := traversal[i].(hcl.TraverseAttr)
+		if !ok || attr.Name != address[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// danglingReference is a reference to a removed block
+type danglingReference struct {
+	file    string
+	line    int
+	text    string
+	address string
+	// rng covers the whole reference including trailing index, attribute and
+	// splat operations, e.g. azurerm_subnet.main[*].id
+	rng hcl.Range
+}
+
+func (r danglingReference) String() string {
+	return fmt.Sprintf("%s:%d: %s refers to removed %s", r.file, r.line, r.text, r.address)
+}
+
+// checkDanglingReferences looks for references to removed blocks across the module.
+// removed maps the address of every removed block to whether its _graft block has
+// cascade = true. References in attributes that overrideBlocks replace are left
+// alone. References to blocks removed without cascade are reported as an error.
+// References to blocks removed with cascade are cleaned up:
+//   - outputs whose value only refers to removed blocks are removed
+//   - elements of depends_on and replace_triggered_by that refer to removed blocks are removed
+//   - any other reference is replaced with null
+func checkDanglingReferences(modulePath string, removed map[string]bool, overrideBlocks []*hclwrite.Block) error {
+	if len(removed) == 0 {
+		return nil
+	}
+
+	addresses := make([][]string, 0, len(removed))
+	for _, address := range utils.SortedKeys(removed) {
+		addresses = append(addresses, strings.Split(address, "."))
+	}
+
+	overridden := overriddenAttributes(overrideBlocks)
+
+	entries, err := filepath.Glob(filepath.Join(modulePath, "*.tf"))
+	if err != nil {
+		return err
+	}
+
+	type fileEdits struct {
+		path    string
+		content []byte
+		edits   []edit
+	}
+	var cascades []fileEdits
+	var dangling []danglingReference
+
+	for _, entry := range entries {
+		name := filepath.Base(entry)
+		if strings.HasPrefix(name, "_graft_") {
+			continue
+		}
+
+		content, err := os.ReadFile(entry)
+		if err != nil {
+			return err
+		}
+
+		f, diags := hclsyntax.ParseConfig(content, name, hcl.Pos{Line: 1, Column: 1})
+		if diags.HasErrors() {
+			// Skip malformed files or files hclsyntax can't parse
+			continue
+		}
+		body, ok := f.Body.(*hclsyntax.Body)
+		if !ok {
+			continue
+		}
+
+		var edits []edit
+		for _, block := range body.Blocks {
+			switch block.Type {
+			case "moved", "removed", "import":
+				// These refer to addresses, not to objects
+				continue
+			}
+
+			key := fmt.Sprintf("%s.%s", block.Type, strings.Join(block.Labels, "."))
+			refs := findReferences(content, name, block.Body, addresses, overridden[key])
+			if len(refs) == 0 {
+				continue
+			}
+			sort.Slice(refs, func(i, j int) bool {
+				return refs[i].rng.Start.Byte < refs[j].rng.Start.Byte
+			})
+
+			var strict []danglingReference
+			for _, ref := range refs {
+				if !removed[ref.address] {
+					strict = append(strict, ref)
+				}
+			}
+			if len(strict) > 0 {
+				dangling = append(dangling, strict...)
+				continue
+			}
+
+			if block.Type == "output" && onlyReferences(block, refs) {
+				edits = append(edits, edit{start: block.Range().Start.Byte, end: lineEnd(content, block.Range().End.Byte)})
+				log.Warn(fmt.Sprintf("Removed output %q, which only referred to removed blocks", block.Labels[0]))
+				continue
+			}
+
+			blockEdits := cascadeEdits(content, block.Body, refs)
+			edits = append(edits, blockEdits...)
+			for _, ref := range refs {
+				log.Warn(fmt.Sprintf("Cleaned up reference %s", ref))
+			}
+		}
+
+		if len(edits) > 0 {
+			cascades = append(cascades, fileEdits{path: entry, content: content, edits: edits})
+		}
+	}
+
+	if len(dangling) > 0 {
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "found %d reference(s) to removed blocks; remove them as well or set cascade = true in the _graft block:", len(dangling))
+		for _, ref := range dangling {
+			sb.WriteString("\n  - ")
+			sb.WriteString(ref.String())
+		}
+		return fmt.Errorf("%s", sb.String())
+	}
+
+	for _, fe := range cascades {
+		if err := os.WriteFile(fe.path, applyEdits(fe.content, fe.edits), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// overriddenAttributes returns the top-level attributes that the override blocks
+// set, keyed by block. Their source values are replaced, so references in them
+// don't survive the override, unless the override keeps them with graft.source.
+func overriddenAttributes(overrideBlocks []*hclwrite.Block) map[string]map[string]bool {
+	result := make(map[string]map[string]bool)
+	for _, block := range overrideBlocks {
+		key := blockKey(block)
+		for name, attr := range block.Body().Attributes() {
+			if strings.Contains(string(attr.Expr().BuildTokens(nil).Bytes()), "graft.source") {
+				continue
+			}
+			if result[key] == nil {
+				result[key] = make(map[string]bool)
+			}
+			result[key][name] = true
+		}
+	}
+	return result
+}
+
+// findReferences returns the references to the given addresses in body and its nested
+// blocks, leaving out the top-level attributes in skip
+func findReferences(content []byte, file string, body *hclsyntax.Body, addresses [][]string, skip map[string]bool) []danglingReference {
+	var refs []danglingReference
+	for _, name := range utils.SortedKeys(body.Attributes) {
+		if skip[name] {
+			continue
+		}
+		walker := &referenceWalker{content: content, file: file, addresses: addresses}
+		hclsyntax.Walk(body.Attributes[name].Expr, walker)
+		refs = append(refs, walker.refs...)
+	}
+	for _, nested := range body.Blocks {
+		refs = append(refs, findReferences(content, file, nested.Body, addresses, nil)...)
+	}
+	return refs
+}
+
+// referenceWalker collects references while keeping track of the enclosing expressions,
+// so that a reference can be extended to the index, attribute and splat operations applied to it
+type referenceWalker struct {
+	content   []byte
+	file      string
+	addresses [][]string
+	stack     []hclsyntax.Node
+	refs      []danglingReference
+}
+
+func (w *referenceWalker) Enter(node hclsyntax.Node) hcl.Diagnostics {
+	w.stack = append(w.stack, node)
+
+	expr, ok := node.(*hclsyntax.ScopeTraversalExpr)
+	if !ok {
+		return nil
+	}
+	for _, address := range w.addresses {
+		if !hasAddressPrefix(expr.Traversal, address) {
+			continue
+		}
+		rng := w.referenceRange()
+		w.refs = append(w.refs, danglingReference{
+			file:    w.file,
+			line:    rng.Start.Line,
+			text:    string(rng.SliceBytes(w.content)),
+			address: strings.Join(address, "."),
+			rng:     rng,
+		})
+		break
+	}
+	return nil
+}
+
+func (w *referenceWalker) Exit(node hclsyntax.Node) hcl.Diagnostics {
+	w.stack = w.stack[:len(w.stack)-1]
+	return nil
+}
+
+// referenceRange returns the range of the outermost expression that only applies
+// index, attribute or splat operations to the traversal on top of the stack
+func (w *referenceWalker) referenceRange() hcl.Range {
+	current := w.stack[len(w.stack)-1].(hclsyntax.Expression)
+	for i := len(w.stack) - 2; i >= 0; i-- {
+		switch parent := w.stack[i].(type) {
+		case *hclsyntax.IndexExpr:
+			if parent.Collection != current {
+				return current.Range()
+			}
+		case *hclsyntax.RelativeTraversalExpr:
+			if parent.Source != current {
+				return current.Range()
+			}
+		case *hclsyntax.SplatExpr:
+			if parent.Source != current {
+				return current.Range()
+			}
+		default:
+			return current.Range()
+		}
+		current = w.stack[i].(hclsyntax.Expression)
+	}
+	return current.Range()
+}
+
+// onlyReferences reports whether the value of an output consists of nothing but references to removed blocks
+func onlyReferences(block *hclsyntax.Block, refs []danglingReference) bool {
+	value, ok := block.Body.Attributes["value"]
+	if !ok {
+		return false
+	}
+	for _, traversal := range value.Expr.Variables() {
+		found := false
+		for _, ref := range refs {
+			if ref.rng.ContainsOffset(traversal.SourceRange().Start.Byte) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// cascadeEdits returns the edits that remove the given references from body
+func cascadeEdits(content []byte, body *hclsyntax.Body, refs []danglingReference) []edit {
+	var edits []edit
+	handled := make(map[hcl.Range]bool)
+
+	var visit func(body *hclsyntax.Body, inLifecycle bool)
+	visit = func(body *hclsyntax.Body, inLifecycle bool) {
+		for _, attr := range body.Attributes {
+			isDependency := attr.Name == "depends_on" || (inLifecycle && attr.Name == "replace_triggered_by")
+			tuple, ok := attr.Expr.(*hclsyntax.TupleConsExpr)
+			if !isDependency || !ok {
+				continue
+			}
+
+			// Drop the elements that refer to removed blocks
+			var kept []string
+			changed := false
+			for _, elem := range tuple.Exprs {
+				isRemoved := false
+				for _, ref := range refs {
+					if ref.rng.ContainsOffset(elem.Range().Start.Byte) {
+						isRemoved = true
+						handled[ref.rng] = true
+					}
+				}
+				if isRemoved {
+					changed = true
+					continue
+				}
+				kept = append(kept, string(elem.Range().SliceBytes(content)))
+			}
+			if changed {
+				edits = append(edits, edit{
+					start: tuple.SrcRange.Start.Byte,
+					end:   tuple.SrcRange.End.Byte,
+					text:  "[" + strings.Join(kept, ", ") + "]",
+				})
+			}
+		}
+		for _, nested := range body.Blocks {
+			visit(nested.Body, nested.Type == "lifecycle")
+		}
+	}
+	visit(body, false)
+
+	for _, ref := range refs {
+		if handled[ref.rng] {
+			continue
+		}
+		edits = append(edits, edit{start: ref.rng.Start.Byte, end: ref.rng.End.Byte, text: "null"})
+	}
+	return edits
+}
+
+// lineEnd returns the offset just after the newline that ends the line containing offset
+func lineEnd(content []byte, offset int) int {
+	for offset < len(content) && content[offset] != '\n' {
+		offset++
+	}
+	if offset < len(content) {
+		offset++
+	}
+	return offset
+}
@@ -0,0 +1,226 @@
+package patch
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplyRemovals_DanglingReferences(t *testing.T) {
+	files := map[string]string{
+		"main.tf": `resource "azurerm_public_ip" "main" {
+  name = "pip"
+}
+
+resource "azurerm_network_interface" "main" {
+  name = "nic"
+  ip_configuration {
+    public_ip_address_id = azurerm_public_ip.main.id
+  }
+  depends_on = [azurerm_public_ip.main, azurerm_resource_group.main]
+}
+`,
+		"outputs.tf": `output "public_ip" {
+  value = azurerm_public_ip.main.ip_address
+}
+
+output "public_ip_ids" {
+  value = azurerm_public_ip.main[*].id
+}
+
+output "summary" {
+  value = {
+    nic = azurerm_network_interface.main.id
+    pip = azurerm_public_ip.main.id
+  }
+}
+`,
+	}
+
+	// Line numbers refer to the vendored files after the removal
+	t.Run("fails and reports every reference", func(t *testing.T) {
+		dir := t.TempDir()
+		for name, content := range files {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+				t.Fatalf("failed to create file %s: %v", name, err)
+			}
+		}
+
+		err := applyRemovals(dir, parseOverrideBlocks(t, `
+override {
+  resource "azurerm_public_ip" "main" {
+    _graft {
+      remove = ["self"]
+    }
+  }
+}
+`))
+		if err == nil {
+			t.Fatal("expected error for dangling references")
+		}
+		for _, want := range []string{
+			"found 5 reference(s) to removed blocks",
+			"main.tf:5: azurerm_public_ip.main.id refers to removed azurerm_public_ip.main",
+			"main.tf:7: azurerm_public_ip.main refers to removed azurerm_public_ip.main",
+			"outputs.tf:2: azurerm_public_ip.main.ip_address refers to removed azurerm_public_ip.main",
+			"outputs.tf:6: azurerm_public_ip.main[*].id refers to removed azurerm_public_ip.main",
+			"outputs.tf:12: azurerm_public_ip.main.id refers to removed azurerm_public_ip.main",
+		} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("expected error to contain %q, got:\n%s", want, err.Error())
+			}
+		}
+	})
+
+	t.Run("cascade cleans up references", func(t *testing.T) {
+		dir := t.TempDir()
+		for name, content := range files {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+				t.Fatalf("failed to create file %s: %v", name, err)
+			}
+		}
+
+		err := applyRemovals(dir, parseOverrideBlocks(t, `
+override {
+  resource "azurerm_public_ip" "main" {
+    _graft {
+      remove  = ["self"]
+      cascade = true
+    }
+  }
+}
+`))
+		if err != nil {
+			t.Fatalf("applyRemovals failed: %v", err)
+		}
+
+		expected := map[string]string{
+			"main.tf": `resource "azurerm_network_interface" "main" {
+  name = "nic"
+  ip_configuration {
+    public_ip_address_id = null
+  }
+  depends_on = [azurerm_resource_group.main]
+}
+`,
+			"outputs.tf": `output "summary" {
+  value = {
+    nic = azurerm_network_interface.main.id
+    pip = null
+  }
+}
+`,
+		}
+		for name, want := range expected {
+			content, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatalf("failed to read file %s: %v", name, err)
+			}
+			if strings.TrimSpace(string(content)) != strings.TrimSpace(want) {
+				t.Errorf("file %s content mismatch.\nGot:\n%s\nExpected:\n%s", name, string(content), want)
+			}
+		}
+	})
+}
+
+func TestApplyRemovals_NoDanglingReferences(t *testing.T) {
+	dir := t.TempDir()
+	source := `variable "unused" {}
+
+data "azurerm_client_config" "current" {}
+
+resource "azurerm_resource_group" "main" {
+  name = "rg"
+}
+
+moved {
+  from = azurerm_resource_group.old
+  to   = azurerm_resource_group.main
+}
+`
+	if err := os.WriteFile(filepath.Join(dir, "main.tf"), []byte(source), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	err := applyRemovals(dir, parseOverrideBlocks(t, `
+override {
+  variable "unused" {
+    _graft {
+      remove = ["self"]
+    }
+  }
+  resource "azurerm_resource_group" "main" {
+    _graft {
+      remove = ["self"]
+    }
+  }
+}
+`))
+	if err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func TestApplyRemovals_OverriddenReferences(t *testing.T) {
+	dir := t.TempDir()
+	source := `resource "azurerm_public_ip" "main" {
+  name = "pip"
+}
+
+resource "azurerm_network_interface" "main" {
+  name                 = "nic"
+  public_ip_address_id = azurerm_public_ip.main.id
+}
+
+output "public_ip" {
+  value = azurerm_public_ip.main.ip_address
+}
+`
+	if err := os.WriteFile(filepath.Join(dir, "main.tf"), []byte(source), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	// Every reference is in an attribute the manifest replaces
+	err := applyRemovals(dir, parseOverrideBlocks(t, `
+override {
+  resource "azurerm_public_ip" "main" {
+    _graft {
+      remove = ["self"]
+    }
+  }
+  resource "azurerm_network_interface" "main" {
+    public_ip_address_id = null
+  }
+  output "public_ip" {
+    value = null
+  }
+}
+`))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// graft.source keeps the source value and its reference
+	if err := os.WriteFile(filepath.Join(dir, "main.tf"), []byte(source), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	err = applyRemovals(dir, parseOverrideBlocks(t, `
+override {
+  resource "azurerm_public_ip" "main" {
+    _graft {
+      remove = ["self"]
+    }
+  }
+  resource "azurerm_network_interface" "main" {
+    public_ip_address_id = graft.source
+  }
+  output "public_ip" {
+    value = null
+  }
+}
+`))
+	if err == nil || !strings.Contains(err.Error(), "found 1 reference(s) to removed blocks") {
+		t.Errorf("expected the reference kept by graft.source to be reported, got %v", err)
+	}
+}
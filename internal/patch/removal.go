@@ -1,6 +1,7 @@
 package patch
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -28,6 +29,8 @@ func applyRemovals(modulePath string, overrideBlocks []*hclwrite.Block) error {
 		return err
 	}
 
+	// Addresses of removed blocks, mapped to whether their references are cleaned up
+	removed := make(map[string]bool)
 	for _, entry := range entries {
 		if strings.HasPrefix(entry, "_graft_") {
 			continue
@@ -51,6 +54,17 @@ func applyRemovals(modulePath string, overrideBlocks []*hclwrite.Block) error {
 				continue
 			}
 
+			instances, err := parseRemoveInstances(graftBlock)
+			if err != nil {
+				return fmt.Errorf("%s: %w", blockKey(block), err)
+			}
+			if len(instances) > 0 {
+				if err := removeInstances(f.Body(), block, instances); err != nil {
+					return fmt.Errorf("%s: %w", blockKey(block), err)
+				}
+				isFileChanged = true
+			}
+
 			removals := parseRemovals(graftBlock)
 			if len(removals) == 0 {
 				continue
@@ -66,6 +80,13 @@ func applyRemovals(modulePath string, overrideBlocks []*hclwrite.Block) error {
 			}
 
 			if isSelfRemoval {
+				if address := blockAddress(block.Type(), block.Labels()); address != nil {
+					cascade, err := parseCascade(graftBlock)
+					if err != nil {
+						return fmt.Errorf("%s: %w", blockKey(block), err)
+					}
+					removed[strings.Join(address, ".")] = cascade
+				}
 				f.Body().RemoveBlock(block)
 				isFileChanged = true
 				continue
@@ -74,7 +95,11 @@ func applyRemovals(modulePath string, overrideBlocks []*hclwrite.Block) error {
 			// Handle granular removals
 			hasChanges := false
 			for _, r := range removals {
-				if removePath(block, r) {
+				removed, err := removePath(block, r)
+				if err != nil {
+					return fmt.Errorf("%s: failed to remove %s: %w", blockKey(block), r, err)
+				}
+				if removed {
 					hasChanges = true
 				}
 			}
@@ -90,15 +115,51 @@ func applyRemovals(modulePath string, overrideBlocks []*hclwrite.Block) error {
 		}
 	}
 
-	return nil
+	return checkDanglingReferences(modulePath, removed, overrideBlocks)
+}
+
+// removePath removes an attribute, nested blocks, or items of an attribute value
+// from block. See parseRemovePath for the path syntax.
+func removePath(block *hclwrite.Block, path string) (bool, error) {
+	steps, err := parseRemovePath(path)
+	if err != nil {
+		return false, err
+	}
+	return removeSteps(block, steps)
 }
 
-func removePath(body *hclwrite.Block, path string) bool {
-	if !strings.Contains(path, ".") {
+func removeSteps(body *hclwrite.Block, steps []pathStep) (bool, error) {
+	step := steps[0]
+	if len(steps) == 1 && len(step.selectors) > 0 {
+		attr := body.Body().GetAttribute(step.name)
+		if attr == nil {
+			// Select nested blocks, e.g. security_rule[name = "AllowSSH"]
+			blocks, err := selectBlocks(body.Body(), step.name, step.selectors)
+			if err != nil {
+				return false, err
+			}
+			for _, b := range blocks {
+				body.Body().RemoveBlock(b)
+			}
+			return len(blocks) > 0, nil
+		}
+		src := attr.Expr().BuildTokens(nil).Bytes()
+		newSrc, changed, err := removeFromExpression(src, step.selectors)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", step.name, err)
+		}
+		if changed {
+			body.Body().SetAttributeRaw(step.name, rawTokens(string(newSrc)))
+		}
+		return changed, nil
+	}
+
+	if len(steps) == 1 {
+		path := step.name
 		attr := body.Body().GetAttribute(path)
 		if attr != nil {
 			body.Body().RemoveAttribute(path)
-			return true
+			return true, nil
 		}
 
 		var toRemove []*hclwrite.Block
@@ -115,18 +176,22 @@ func removePath(body *hclwrite.Block, path string) bool {
 		for _, b := range toRemove {
 			body.Body().RemoveBlock(b)
 		}
-		return len(toRemove) > 0
+		return len(toRemove) > 0, nil
 	}
 
-	blockType := strings.SplitN(path, ".", 2)[0]
-	nestedPath := path[len(blockType)+1:]
+	blocks, err := selectBlocks(body.Body(), step.name, step.selectors)
+	if err != nil {
+		return false, err
+	}
 	changed := false
-	for _, b := range body.Body().Blocks() {
-		if b.Type() == blockType {
-			changed = changed || removePath(b, nestedPath)
+	for _, b := range blocks {
+		removed, err := removeSteps(b, steps[1:])
+		if err != nil {
+			return false, err
 		}
+		changed = changed || removed
 	}
-	return changed
+	return changed, nil
 }
 
 func parseRemovals(b *hclwrite.Block) []string {
@@ -167,3 +232,21 @@ func parseRemovals(b *hclwrite.Block) []string {
 	}
 	return res
 }
+
+func parseCascade(b *hclwrite.Block) (bool, error) {
+	attr := b.Body().GetAttribute("cascade")
+	if attr == nil {
+		return false, nil
+	}
+
+	exprBytes := attr.Expr().BuildTokens(nil).Bytes()
+	expr, diags := hclsyntax.ParseExpression(exprBytes, "cascade", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return false, fmt.Errorf("invalid cascade: %s", diags.Error())
+	}
+	val, diags := expr.Value(nil)
+	if diags.HasErrors() || val.Type() != cty.Bool || val.IsNull() {
+		return false, fmt.Errorf("invalid cascade: must be true or false")
+	}
+	return val.True(), nil
+}
@@ -1,9 +1,11 @@
 package patch
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/hashicorp/hcl/v2"
@@ -230,6 +232,194 @@ override {
 resource "foo" "bar" {
   name = "test"
 }
+`,
+			},
+		},
+		{
+			name: "remove map keys and list elements",
+			files: map[string]string{
+				"main.tf": `
+resource "azurerm_storage_account" "main" {
+  name = "st"
+  tags = {
+    Environment = "prod"
+    CostCenter  = "42"
+  }
+  network_rules {
+    ip_rules = ["10.0.0.0/8", "0.0.0.0/0"]
+  }
+}
+`,
+			},
+			override: `
+override {
+  resource "azurerm_storage_account" "main" {
+    _graft {
+      remove = ["tags[\"CostCenter\"]", "network_rules.ip_rules[value == \"0.0.0.0/0\"]"]
+    }
+  }
+}
+`,
+			expected: map[string]string{
+				"main.tf": `
+resource "azurerm_storage_account" "main" {
+  name = "st"
+  tags = {
+    Environment = "prod"
+  }
+  network_rules {
+    ip_rules = ["10.0.0.0/8"]
+  }
+}
+`,
+			},
+		},
+		{
+			name: "remove nested blocks by selector",
+			files: map[string]string{
+				"main.tf": `
+resource "azurerm_network_security_group" "main" {
+  name = "nsg"
+
+  security_rule {
+    name     = "AllowSSH"
+    priority = 100
+  }
+
+  security_rule {
+    name     = "AllowHTTPS"
+    priority = 110
+  }
+
+  security_rule {
+    name     = "DenyAll"
+    priority = 4096
+  }
+}
+`,
+			},
+			override: `
+override {
+  resource "azurerm_network_security_group" "main" {
+    _graft {
+      remove = ["security_rule[name=\"AllowSSH\"]", "security_rule[priority > 4000]"]
+    }
+  }
+}
+`,
+			expected: map[string]string{
+				"main.tf": `
+resource "azurerm_network_security_group" "main" {
+  name = "nsg"
+
+
+  security_rule {
+    name     = "AllowHTTPS"
+    priority = 110
+  }
+
+}
+`,
+			},
+		},
+		{
+			name: "remove attribute of a selected nested block",
+			files: map[string]string{
+				"main.tf": `
+resource "azurerm_virtual_network" "main" {
+  subnet {
+    name           = "a"
+    security_group = "nsg-a"
+  }
+  subnet {
+    name           = "b"
+    security_group = "nsg-b"
+  }
+}
+`,
+			},
+			override: `
+override {
+  resource "azurerm_virtual_network" "main" {
+    _graft {
+      remove = ["subnet[1].security_group"]
+    }
+  }
+}
+`,
+			expected: map[string]string{
+				"main.tf": `
+resource "azurerm_virtual_network" "main" {
+  subnet {
+    name           = "a"
+    security_group = "nsg-a"
+  }
+  subnet {
+    name = "b"
+  }
+}
+`,
+			},
+		},
+		{
+			name: "remove for_each instances",
+			files: map[string]string{
+				"main.tf": `
+resource "azurerm_subnet" "this" {
+  for_each = var.subnets
+  name     = each.key
+}
+`,
+			},
+			override: `
+override {
+  resource "azurerm_subnet" "this" {
+    _graft {
+      remove_instances = ["default", 1]
+    }
+  }
+}
+`,
+			expected: map[string]string{
+				"main.tf": `
+resource "azurerm_subnet" "this" {
+  for_each = { for k, v in var.subnets : k => v if !contains(["default", "1"], k) }
+  name     = each.key
+}
+`,
+			},
+		},
+		{
+			name: "remove count instances",
+			files: map[string]string{
+				"main.tf": `
+resource "azurerm_role_assignment" "this" {
+  count        = length(var.principals)
+  principal_id = var.principals[count.index]
+  description  = "assignment ${count.index}"
+}
+`,
+			},
+			override: `
+override {
+  resource "azurerm_role_assignment" "this" {
+    _graft {
+      remove_instances = [0, "2"]
+      remove           = ["description"]
+    }
+  }
+}
+`,
+			expected: map[string]string{
+				"main.tf": `
+resource "azurerm_role_assignment" "this" {
+  count        = length(local._graft_resource_azurerm_role_assignment_this_indexes)
+  principal_id = var.principals[local._graft_resource_azurerm_role_assignment_this_indexes[count.index]]
+}
+
+locals {
+  _graft_resource_azurerm_role_assignment_this_indexes = [for i in range(length(var.principals)) : i if !contains([0, 2], i)]
+}
 `,
 			},
 		},
@@ -284,3 +474,167 @@ resource "foo" "bar" {
 		})
 	}
 }
+
+func TestApplyRemovals_InstancesTwice(t *testing.T) {
+	// Root overrides edit the source files in place, so the next build sees
+	// the result of the previous one
+	tests := []struct {
+		name     string
+		source   string
+		first    string
+		second   string
+		expected string
+	}{
+		{
+			name: "for_each",
+			source: `
+resource "azurerm_subnet" "this" {
+  for_each = var.subnets
+  name     = each.key
+}
+`,
+			first:  `["default"]`,
+			second: `["default", "web"]`,
+			expected: `
+resource "azurerm_subnet" "this" {
+  for_each = { for k, v in var.subnets : k => v if !contains(["default", "web"], k) }
+  name     = each.key
+}
+`,
+		},
+		{
+			name: "count",
+			source: `
+resource "null_resource" "vm" {
+  count = 3
+  triggers = {
+    index = count.index
+  }
+}
+`,
+			first:  `[1]`,
+			second: `[1]`,
+			expected: `
+resource "null_resource" "vm" {
+  count = length(local._graft_resource_null_resource_vm_indexes)
+  triggers = {
+    index = local._graft_resource_null_resource_vm_indexes[count.index]
+  }
+}
+
+locals {
+  _graft_resource_null_resource_vm_indexes = [for i in range(3) : i if !contains([1], i)]
+}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "main.tf")
+			if err := os.WriteFile(path, []byte(tt.source), 0644); err != nil {
+				t.Fatalf("failed to create main.tf: %v", err)
+			}
+
+			for _, keys := range []string{tt.first, tt.second} {
+				override := fmt.Sprintf("resource %s {\n  _graft {\n    remove_instances = %s\n  }\n}\n", strings.Join(blockLabelsOf(tt.source), " "), keys)
+				f, diags := hclwrite.ParseConfig([]byte(override), "override.hcl", hcl.Pos{Line: 1, Column: 1})
+				if diags.HasErrors() {
+					t.Fatalf("failed to parse override: %s", diags.Error())
+				}
+				if err := applyRemovals(dir, f.Body().Blocks()); err != nil {
+					t.Fatalf("applyRemovals failed: %v", err)
+				}
+			}
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("failed to read main.tf: %v", err)
+			}
+			got := string(hclwrite.Format(content))
+			expected := string(hclwrite.Format([]byte(tt.expected)))
+			if got != expected {
+				t.Errorf("content mismatch.\nGot:\n%s\nExpected:\n%s", got, expected)
+			}
+		})
+	}
+}
+
+// blockLabelsOf returns the quoted labels of the first block in src
+func blockLabelsOf(src string) []string {
+	f, _ := hclwrite.ParseConfig([]byte(src), "main.tf", hcl.Pos{Line: 1, Column: 1})
+	var labels []string
+	for _, label := range f.Body().Blocks()[0].Labels() {
+		labels = append(labels, fmt.Sprintf("%q", label))
+	}
+	return labels
+}
+
+func TestApplyRemovals_InvalidInstances(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		override string
+		errMsg   string
+	}{
+		{
+			name:   "no for_each or count",
+			source: `resource "foo" "bar" {}`,
+			override: `
+override {
+  resource "foo" "bar" {
+    _graft {
+      remove_instances = ["a"]
+    }
+  }
+}
+`,
+			errMsg: "requires a for_each or count argument",
+		},
+		{
+			name: "count with a string key",
+			source: `resource "foo" "bar" {
+  count = 3
+}`,
+			override: `
+override {
+  resource "foo" "bar" {
+    _graft {
+      remove_instances = ["a"]
+    }
+  }
+}
+`,
+			errMsg: "must contain whole numbers",
+		},
+		{
+			name:   "not a list",
+			source: `resource "foo" "bar" {}`,
+			override: `
+override {
+  resource "foo" "bar" {
+    _graft {
+      remove_instances = "a"
+    }
+  }
+}
+`,
+			errMsg: "must be a list",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "main.tf"), []byte(tt.source), 0644); err != nil {
+				t.Fatalf("failed to create file: %v", err)
+			}
+
+			err := applyRemovals(dir, parseOverrideBlocks(t, tt.override))
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
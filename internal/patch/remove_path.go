@@ -0,0 +1,468 @@
+package patch
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// pathStep is one dot-separated step of a removal path, e.g. `tags["CostCenter"]`
+// is the step "tags" with the selector `"CostCenter"`
+type pathStep struct {
+	name      string
+	selectors []string
+}
+
+// parseRemovePath splits a removal path into steps. Dots and brackets inside
+// selectors (e.g. `ip_rules[value == "0.0.0.0/0"]`) don't split the path.
+func parseRemovePath(path string) ([]pathStep, error) {
+	var steps []pathStep
+	var current pathStep
+	var buf strings.Builder
+	depth := 0
+	inQuote := false
+
+	flushName := func() error {
+		if buf.Len() == 0 {
+			return fmt.Errorf("invalid path %q: empty step", path)
+		}
+		current.name = buf.String()
+		buf.Reset()
+		return nil
+	}
+
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case inQuote:
+			buf.WriteByte(c)
+			if c == '\\' && i+1 < len(path) {
+				i++
+				buf.WriteByte(path[i])
+			} else if c == '"' {
+				inQuote = false
+			}
+		case depth > 0:
+			switch c {
+			case '"':
+				inQuote = true
+			case '[':
+				depth++
+			case ']':
+				depth--
+				if depth == 0 {
+					current.selectors = append(current.selectors, strings.TrimSpace(buf.String()))
+					buf.Reset()
+					continue
+				}
+			}
+			buf.WriteByte(c)
+		case c == '[':
+			if current.name == "" {
+				if err := flushName(); err != nil {
+					return nil, err
+				}
+			} else if buf.Len() > 0 {
+				return nil, fmt.Errorf("invalid path %q: unexpected %q after selector", path, buf.String())
+			}
+			depth++
+		case c == '.':
+			if current.name == "" {
+				if err := flushName(); err != nil {
+					return nil, err
+				}
+			} else if buf.Len() > 0 {
+				return nil, fmt.Errorf("invalid path %q: unexpected %q after selector", path, buf.String())
+			}
+			steps = append(steps, current)
+			current = pathStep{}
+		default:
+			buf.WriteByte(c)
+		}
+	}
+
+	if depth > 0 || inQuote {
+		return nil, fmt.Errorf("invalid path %q: unterminated selector", path)
+	}
+	if current.name == "" {
+		if err := flushName(); err != nil {
+			return nil, err
+		}
+	} else if buf.Len() > 0 {
+		return nil, fmt.Errorf("invalid path %q: unexpected %q after selector", path, buf.String())
+	}
+	for _, sel := range current.selectors {
+		if sel == "" {
+			return nil, fmt.Errorf("invalid path %q: empty selector", path)
+		}
+	}
+	return append(steps, current), nil
+}
+
+// selector picks items of an object or tuple constructor. A constant string
+// selects an object key, a constant number selects a tuple index, and any other
+// expression is a condition evaluated for each item with "key" and "value" set.
+type selector struct {
+	src  string
+	expr hclsyntax.Expression
+	// constant is set for selectors without variables, e.g. "CostCenter" or 2
+	constant cty.Value
+}
+
+func parseSelector(src string) (*selector, error) {
+	expr, diags := hclsyntax.ParseExpression([]byte(src), "selector", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("invalid selector [%s]: %s", src, diags.Error())
+	}
+	s := &selector{src: src, expr: expr}
+	if len(expr.Variables()) == 0 {
+		val, diags := expr.Value(nil)
+		if diags.HasErrors() || val.IsNull() || (val.Type() != cty.String && val.Type() != cty.Number) {
+			return nil, fmt.Errorf("invalid selector [%s]: must be a key, an index or a condition", src)
+		}
+		s.constant = val
+	}
+	return s, nil
+}
+
+// matches reports whether the item with the given key and value is selected.
+// value may be cty.NilVal if the item isn't a literal.
+func (s *selector) matches(key cty.Value, value cty.Value) bool {
+	if s.constant != cty.NilVal {
+		if key.Type() == cty.Number && s.constant.Type() == cty.Number {
+			return key.Equals(s.constant).True()
+		}
+		if key.Type() == cty.String && s.constant.Type() == cty.String {
+			return key.AsString() == s.constant.AsString()
+		}
+		return false
+	}
+
+	if value == cty.NilVal {
+		return false
+	}
+	ctx := &hcl.EvalContext{Variables: map[string]cty.Value{
+		"key":   key,
+		"value": value,
+	}}
+	result, diags := s.expr.Value(ctx)
+	if diags.HasErrors() || result.Type() != cty.Bool || !result.IsKnown() || result.IsNull() {
+		return false
+	}
+	return result.True()
+}
+
+// constructorItem is an item of an object or tuple constructor
+type constructorItem struct {
+	key   cty.Value
+	value cty.Value
+	expr  hclsyntax.Expression
+	rng   hcl.Range
+}
+
+// constructorItems returns the items of an object or tuple constructor expression
+func constructorItems(expr hclsyntax.Expression) ([]constructorItem, bool) {
+	var items []constructorItem
+	switch e := expr.(type) {
+	case *hclsyntax.ObjectConsExpr:
+		for _, item := range e.Items {
+			key, diags := item.KeyExpr.Value(nil)
+			if diags.HasErrors() || !key.IsKnown() || key.IsNull() || key.Type() != cty.String {
+				continue
+			}
+			items = append(items, constructorItem{
+				key:   key,
+				value: literalValue(item.ValueExpr),
+				expr:  item.ValueExpr,
+				rng:   hcl.RangeBetween(item.KeyExpr.Range(), item.ValueExpr.Range()),
+			})
+		}
+	case *hclsyntax.TupleConsExpr:
+		for i, elem := range e.Exprs {
+			items = append(items, constructorItem{
+				key:   cty.NumberIntVal(int64(i)),
+				value: literalValue(elem),
+				expr:  elem,
+				rng:   elem.Range(),
+			})
+		}
+	default:
+		return nil, false
+	}
+	return items, true
+}
+
+func literalValue(expr hclsyntax.Expression) cty.Value {
+	val, diags := expr.Value(nil)
+	if diags.HasErrors() {
+		return cty.NilVal
+	}
+	return val
+}
+
+// removeFromExpression removes the items picked by the last selector from the
+// object or tuple constructor reached by the other selectors. src must be the
+// source of a single expression. It returns the new source and whether anything
+// was removed.
+func removeFromExpression(src []byte, selectorSources []string) ([]byte, bool, error) {
+	expr, diags := hclsyntax.ParseExpression(src, "expression", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, false, fmt.Errorf("failed to parse expression: %s", diags.Error())
+	}
+
+	var selectors []*selector
+	for _, s := range selectorSources {
+		sel, err := parseSelector(s)
+		if err != nil {
+			return nil, false, err
+		}
+		selectors = append(selectors, sel)
+	}
+
+	targets := []hclsyntax.Expression{expr}
+	for _, sel := range selectors[:len(selectors)-1] {
+		var next []hclsyntax.Expression
+		for _, target := range targets {
+			items, ok := constructorItems(target)
+			if !ok {
+				return nil, false, fmt.Errorf("[%s] can only be applied to an object or tuple literal", sel.src)
+			}
+			for _, item := range items {
+				if sel.matches(item.key, item.value) {
+					next = append(next, item.expr)
+				}
+			}
+		}
+		targets = next
+	}
+
+	last := selectors[len(selectors)-1]
+	var spans []edit
+	for _, target := range targets {
+		items, ok := constructorItems(target)
+		if !ok {
+			return nil, false, fmt.Errorf("[%s] can only be applied to an object or tuple literal", last.src)
+		}
+		for _, item := range items {
+			if last.matches(item.key, item.value) {
+				start, end := itemSpan(src, item.rng.Start.Byte, item.rng.End.Byte)
+				spans = append(spans, edit{start: start, end: end})
+			}
+		}
+	}
+	if len(spans) == 0 {
+		return src, false, nil
+	}
+
+	return applyEdits(src, mergeSpans(src, spans)), true, nil
+}
+
+// itemSpan extends the range of a constructor item to include its separator:
+// the whole line for an item on its own line, otherwise the trailing comma or,
+// for the last item, the preceding comma
+func itemSpan(src []byte, start, end int) (int, int) {
+	isSpace := func(c byte) bool { return c == ' ' || c == '\t' }
+
+	j := end
+	for j < len(src) && isSpace(src[j]) {
+		j++
+	}
+	hasComma := j < len(src) && src[j] == ','
+	if hasComma {
+		j++
+		for j < len(src) && isSpace(src[j]) {
+			j++
+		}
+	}
+	// A trailing comment belongs to the item
+	if j < len(src) && (src[j] == '#' || (src[j] == '/' && j+1 < len(src) && src[j+1] == '/')) {
+		for j < len(src) && src[j] != '\n' {
+			j++
+		}
+	}
+
+	lineStart := start
+	for lineStart > 0 && isSpace(src[lineStart-1]) {
+		lineStart--
+	}
+	if (lineStart == 0 || src[lineStart-1] == '\n') && (j >= len(src) || src[j] == '\n') {
+		if j < len(src) {
+			j++
+		}
+		return lineStart, j
+	}
+
+	if hasComma {
+		return start, j
+	}
+	k := start
+	for k > 0 && isSpace(src[k-1]) {
+		k--
+	}
+	if k > 0 && src[k-1] == ',' {
+		return k - 1, end
+	}
+	return start, end
+}
+
+// mergeSpans merges overlapping removal spans, and drops a dangling comma left
+// before a closing bracket when the last items of an inline constructor are removed
+func mergeSpans(src []byte, spans []edit) []edit {
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].start < spans[j].start
+	})
+	var merged []edit
+	for _, span := range spans {
+		if n := len(merged); n > 0 && span.start <= merged[n-1].end {
+			if span.end > merged[n-1].end {
+				merged[n-1].end = span.end
+			}
+			continue
+		}
+		merged = append(merged, span)
+	}
+
+	for i, span := range merged {
+		j := span.end
+		for j < len(src) && (src[j] == ' ' || src[j] == '\t') {
+			j++
+		}
+		if j >= len(src) || (src[j] != ']' && src[j] != '}') {
+			continue
+		}
+		k := span.start
+		for k > 0 && (src[k-1] == ' ' || src[k-1] == '\t') {
+			k--
+		}
+		if k > 0 && src[k-1] == ',' && (i == 0 || merged[i-1].end <= k-1) {
+			merged[i].start = k - 1
+		}
+	}
+	return merged
+}
+
+// blockSelector picks nested blocks of one type. It is either:
+//   - a position among the static blocks of the type, e.g. 0
+//   - attribute values to compare, e.g. name = "AllowSSH", priority = 100
+//   - a condition on the attributes, e.g. priority > 100
+//
+// Only attributes with literal values can be matched.
+type blockSelector struct {
+	src       string
+	index     int
+	attrs     map[string]cty.Value
+	condition hclsyntax.Expression
+}
+
+func parseBlockSelector(src string) (*blockSelector, error) {
+	s := &blockSelector{src: src, index: -1}
+
+	expr, diags := hclsyntax.ParseExpression([]byte(src), "selector", hcl.Pos{Line: 1, Column: 1})
+	if !diags.HasErrors() && len(expr.Variables()) == 0 {
+		val, diags := expr.Value(nil)
+		if !diags.HasErrors() && val.Type() == cty.Number && !val.IsNull() {
+			index, ok := countIndex(val)
+			if !ok {
+				return nil, fmt.Errorf("invalid selector [%s]: index must be a whole number", src)
+			}
+			s.index = int(index)
+			return s, nil
+		}
+	}
+
+	// name = "AllowSSH", priority = 100 is parsed as the object { name = "AllowSSH", priority = 100 }
+	objExpr, objDiags := hclsyntax.ParseExpression([]byte("{"+src+"}"), "selector", hcl.Pos{Line: 1, Column: 1})
+	if !objDiags.HasErrors() {
+		val, diags := objExpr.Value(nil)
+		if diags.HasErrors() || !val.Type().IsObjectType() || !val.IsWhollyKnown() {
+			return nil, fmt.Errorf("invalid selector [%s]: attribute values must be literals", src)
+		}
+		s.attrs = val.AsValueMap()
+		return s, nil
+	}
+
+	if diags.HasErrors() || len(expr.Variables()) == 0 {
+		return nil, fmt.Errorf("invalid selector [%s]: must be an index, attribute values or a condition", src)
+	}
+	s.condition = expr
+	return s, nil
+}
+
+// matches reports whether block is selected. position is the index of block
+// among the static blocks of its type.
+func (s *blockSelector) matches(block *hclwrite.Block, position int) bool {
+	if s.index >= 0 {
+		return s.index == position
+	}
+
+	values := literalAttributes(block)
+	if s.condition != nil {
+		result, diags := s.condition.Value(&hcl.EvalContext{Variables: values})
+		if diags.HasErrors() || result.Type() != cty.Bool || !result.IsKnown() || result.IsNull() {
+			return false
+		}
+		return result.True()
+	}
+
+	for name, want := range s.attrs {
+		got, ok := values[name]
+		if !ok || !got.Type().Equals(want.Type()) || !got.Equals(want).True() {
+			return false
+		}
+	}
+	return true
+}
+
+// literalAttributes returns the values of the attributes of block that are literals
+func literalAttributes(block *hclwrite.Block) map[string]cty.Value {
+	values := make(map[string]cty.Value)
+	for name, attr := range block.Body().Attributes() {
+		expr, diags := hclsyntax.ParseExpression(attr.Expr().BuildTokens(nil).Bytes(), name, hcl.Pos{Line: 1, Column: 1})
+		if diags.HasErrors() {
+			continue
+		}
+		val, diags := expr.Value(nil)
+		if diags.HasErrors() || !val.IsWhollyKnown() {
+			continue
+		}
+		values[name] = val
+	}
+	return values
+}
+
+// selectBlocks returns the static blocks of the given type in body that match all selectors
+func selectBlocks(body *hclwrite.Body, blockType string, selectorSources []string) ([]*hclwrite.Block, error) {
+	var selectors []*blockSelector
+	for _, src := range selectorSources {
+		sel, err := parseBlockSelector(src)
+		if err != nil {
+			return nil, err
+		}
+		selectors = append(selectors, sel)
+	}
+
+	var result []*hclwrite.Block
+	position := 0
+	for _, block := range body.Blocks() {
+		if block.Type() != blockType {
+			continue
+		}
+		matched := true
+		for _, sel := range selectors {
+			if !sel.matches(block, position) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			result = append(result, block)
+		}
+		position++
+	}
+	return result, nil
+}
@@ -0,0 +1,241 @@
+package patch
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+func TestParseRemovePath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected []pathStep
+		wantErr  bool
+	}{
+		{
+			path:     "description",
+			expected: []pathStep{{name: "description"}},
+		},
+		{
+			path:     "timeouts.create",
+			expected: []pathStep{{name: "timeouts"}, {name: "create"}},
+		},
+		{
+			path:     `tags["CostCenter"]`,
+			expected: []pathStep{{name: "tags", selectors: []string{`"CostCenter"`}}},
+		},
+		{
+			path:     `network_rules.ip_rules[value == "0.0.0.0/0"]`,
+			expected: []pathStep{{name: "network_rules"}, {name: "ip_rules", selectors: []string{`value == "0.0.0.0/0"`}}},
+		},
+		{
+			path:     `rules[0]["ports"]`,
+			expected: []pathStep{{name: "rules", selectors: []string{"0", `"ports"`}}},
+		},
+		{
+			path:     `tags["a]b"]`,
+			expected: []pathStep{{name: "tags", selectors: []string{`"a]b"`}}},
+		},
+		{path: "", wantErr: true},
+		{path: "a..b", wantErr: true},
+		{path: "tags[", wantErr: true},
+		{path: "tags[]", wantErr: true},
+		{path: `tags["a"]b`, wantErr: true},
+		{path: `["a"]`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := parseRemovePath(tt.path)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("parseRemovePath(%q) = %#v, expected %#v", tt.path, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRemoveFromExpression(t *testing.T) {
+	tests := []struct {
+		name      string
+		src       string
+		selectors []string
+		expected  string
+		changed   bool
+		wantErr   bool
+	}{
+		{
+			name: "remove map key on its own line",
+			src: `{
+  Environment = "prod"
+  CostCenter  = "42" # billing
+  "Owner"     = "platform"
+}`,
+			selectors: []string{`"CostCenter"`},
+			expected: `{
+  Environment = "prod"
+  "Owner"     = "platform"
+}`,
+			changed: true,
+		},
+		{
+			name:      "remove quoted map key inline",
+			src:       `{ Environment = "prod", "Cost Center" = "42" }`,
+			selectors: []string{`"Cost Center"`},
+			expected:  `{ Environment = "prod" }`,
+			changed:   true,
+		},
+		{
+			name:      "remove list element by index",
+			src:       `["10.0.0.0/8", "0.0.0.0/0", "192.168.0.0/16"]`,
+			selectors: []string{"1"},
+			expected:  `["10.0.0.0/8", "192.168.0.0/16"]`,
+			changed:   true,
+		},
+		{
+			name:      "remove list elements by value",
+			src:       `["10.0.0.0/8", "0.0.0.0/0", "0.0.0.0/0"]`,
+			selectors: []string{`value == "0.0.0.0/0"`},
+			expected:  `["10.0.0.0/8"]`,
+			changed:   true,
+		},
+		{
+			name: "remove multi-line list element",
+			src: `[
+  "10.0.0.0/8",
+  "0.0.0.0/0",
+  var.extra_cidr,
+]`,
+			selectors: []string{`value == "0.0.0.0/0"`},
+			expected: `[
+  "10.0.0.0/8",
+  var.extra_cidr,
+]`,
+			changed: true,
+		},
+		{
+			name:      "remove object from list by attribute",
+			src:       `[{ name = "a", port = 22 }, { name = "b", port = 443 }]`,
+			selectors: []string{`value.port == 22`},
+			expected:  `[{ name = "b", port = 443 }]`,
+			changed:   true,
+		},
+		{
+			name:      "remove key of a nested object",
+			src:       `[{ name = "a", port = 22 }, { name = "b", port = 443 }]`,
+			selectors: []string{"0", `"port"`},
+			expected:  `[{ name = "a" }, { name = "b", port = 443 }]`,
+			changed:   true,
+		},
+		{
+			name:      "remove every element",
+			src:       `["a", "b"]`,
+			selectors: []string{`value != ""`},
+			expected:  `[]`,
+			changed:   true,
+		},
+		{
+			name:      "no match",
+			src:       `{ a = 1 }`,
+			selectors: []string{`"b"`},
+			expected:  `{ a = 1 }`,
+		},
+		{
+			name:      "not a literal",
+			src:       `merge(var.tags, { a = 1 })`,
+			selectors: []string{`"a"`},
+			wantErr:   true,
+		},
+		{
+			name:      "invalid selector",
+			src:       `{ a = 1 }`,
+			selectors: []string{`true`},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed, err := removeFromExpression([]byte(tt.src), tt.selectors)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got %s", string(got))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if changed != tt.changed {
+				t.Errorf("changed = %v, expected %v", changed, tt.changed)
+			}
+			if string(got) != tt.expected {
+				t.Errorf("removeFromExpression() =\n%s\nexpected:\n%s", string(got), tt.expected)
+			}
+		})
+	}
+}
+
+func TestBlockSelector(t *testing.T) {
+	source := `
+rule {
+  name     = "AllowSSH"
+  priority = 100
+  port     = var.ssh_port
+}
+`
+	f, diags := hclwrite.ParseConfig([]byte(source), "main.tf", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		t.Fatalf("failed to parse source: %s", diags.Error())
+	}
+	block := f.Body().Blocks()[0]
+
+	tests := []struct {
+		selector string
+		position int
+		expected bool
+		wantErr  bool
+	}{
+		{selector: "0", position: 0, expected: true},
+		{selector: "1", position: 0, expected: false},
+		{selector: `name="AllowSSH"`, expected: true},
+		{selector: `name = "AllowSSH", priority = 100`, expected: true},
+		{selector: `name = "AllowSSH", priority = 200`, expected: false},
+		{selector: `priority = "100"`, expected: false},
+		{selector: `priority >= 100 && name != "DenyAll"`, expected: true},
+		{selector: `port == 22`, expected: false},
+		{selector: `port = 22`, expected: false},
+		{selector: "1.5", wantErr: true},
+		{selector: `"AllowSSH"`, wantErr: true},
+		{selector: `name = var.name`, wantErr: true},
+		{selector: `name ==`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			sel, err := parseBlockSelector(tt.selector)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got %#v", sel)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := sel.matches(block, tt.position); got != tt.expected {
+				t.Errorf("matches() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,327 @@
+package patch
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/gocty"
+)
+
+// rename describes a block that is renamed with _graft { rename_to = "..." }
+type rename struct {
+	blockType string
+	labels    []string
+	newName   string
+}
+
+// address returns the traversal that refers to the block with the given name,
+// e.g. ["azurerm_subnet", "name"], ["data", "azurerm_client_config", "name"] or ["module", "name"]
+func (r rename) address(name string) []string {
+	labels := append(append([]string(nil), r.labels[:len(r.labels)-1]...), name)
+	return blockAddress(r.blockType, labels)
+}
+
+func (r rename) oldName() string {
+	return r.labels[len(r.labels)-1]
+}
+
+// newLabels returns the labels of the block after the rename
+func (r rename) newLabels() []string {
+	return append(append([]string(nil), r.labels[:len(r.labels)-1]...), r.newName)
+}
+
+// edit replaces the bytes in [start, end) of a file
+type edit struct {
+	start, end int
+	text       string
+}
+
+// applyRenames renames the blocks marked with _graft { rename_to = "..." }: the block
+// labels are rewritten in the source, every reference across the module's .tf files
+// is updated, and the override block is relabeled so that later stages target the
+// new name. It returns the moved blocks that keep the state of renamed resources and
+// modules.
+func applyRenames(modulePath string, overrideBlocks []*hclwrite.Block) ([]*hclwrite.Block, error) {
+	var renames []rename
+	for _, block := range overrideBlocks {
+		graftBlock := block.Body().FirstMatchingBlock("_graft", nil)
+		if graftBlock == nil {
+			continue
+		}
+		newName, err := parseRenameTo(graftBlock)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", blockKey(block), err)
+		}
+		if newName == "" {
+			continue
+		}
+		switch block.Type() {
+		case "resource", "data", "module":
+		default:
+			return nil, fmt.Errorf("%s: rename_to is only supported for resource, data and module blocks", blockKey(block))
+		}
+		if len(block.Labels()) == 0 {
+			return nil, fmt.Errorf("%s: rename_to requires a labeled block", blockKey(block))
+		}
+		renames = append(renames, rename{blockType: block.Type(), labels: block.Labels(), newName: newName})
+	}
+	if len(renames) == 0 {
+		return nil, nil
+	}
+
+	entries, err := filepath.Glob(filepath.Join(modulePath, "*.tf"))
+	if err != nil {
+		return nil, err
+	}
+
+	type sourceFile struct {
+		path    string
+		content []byte
+		body    *hclsyntax.Body
+	}
+	var files []sourceFile
+	for _, entry := range entries {
+		if strings.HasPrefix(filepath.Base(entry), "_graft_") {
+			continue
+		}
+
+		content, err := os.ReadFile(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		f, diags := hclsyntax.ParseConfig(content, entry, hcl.Pos{Line: 1, Column: 1})
+		if diags.HasErrors() {
+			// Skip malformed files or files hclsyntax can't parse
+			continue
+		}
+		body, ok := f.Body.(*hclsyntax.Body)
+		if !ok {
+			continue
+		}
+		files = append(files, sourceFile{path: entry, content: content, body: body})
+	}
+
+	// Root overrides edit the source files in place, so a previous build may
+	// have renamed the block already. Only the other stages need the rename then.
+	var pending []rename
+	for _, r := range renames {
+		oldFound, newFound := false, false
+		for _, file := range files {
+			oldFound = oldFound || hasBlock(file.body, r.blockType, r.labels)
+			newFound = newFound || hasBlock(file.body, r.blockType, r.newLabels())
+		}
+		switch {
+		case oldFound && newFound:
+			return nil, fmt.Errorf("cannot rename %s to %s: a block with that name already exists", strings.Join(r.address(r.oldName()), "."), r.newName)
+		case oldFound:
+			pending = append(pending, r)
+		case !newFound:
+			return nil, fmt.Errorf("cannot rename %s: block not found in module", strings.Join(r.address(r.oldName()), "."))
+		}
+	}
+
+	for _, file := range files {
+		edits := renameEdits(file.body, pending)
+		if len(edits) == 0 {
+			continue
+		}
+		if err := os.WriteFile(file.path, applyEdits(file.content, edits), 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	renameOverrideReferences(overrideBlocks, renames)
+
+	var moved []*hclwrite.Block
+	for _, r := range renames {
+		for _, block := range overrideBlocks {
+			if block.Type() == r.blockType && strings.Join(block.Labels(), ".") == strings.Join(r.labels, ".") {
+				block.SetLabels(r.newLabels())
+			}
+		}
+
+		// Data sources have no state to move
+		if r.blockType == "data" {
+			continue
+		}
+		movedBlock := hclwrite.NewBlock("moved", nil)
+		movedBlock.Body().SetAttributeTraversal("from", traversalFor(r.address(r.oldName())))
+		movedBlock.Body().SetAttributeTraversal("to", traversalFor(r.address(r.newName)))
+		moved = append(moved, movedBlock)
+	}
+
+	return moved, nil
+}
+
+// hasBlock reports whether body has a block with the given type and labels
+func hasBlock(body *hclsyntax.Body, blockType string, labels []string) bool {
+	for _, block := range body.Blocks {
+		if block.Type == blockType && strings.Join(block.Labels, ".") == strings.Join(labels, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// renameOverrideReferences rewrites the references to renamed blocks in the
+// expressions of the override blocks, e.g. depends_on = [null_resource.old]
+func renameOverrideReferences(blocks []*hclwrite.Block, renames []rename) {
+	for _, block := range blocks {
+		renameBodyReferences(block.Body(), renames)
+	}
+}
+
+func renameBodyReferences(body *hclwrite.Body, renames []rename) {
+	for name, attr := range body.Attributes() {
+		tokens := attr.Expr().BuildTokens(nil)
+		changed := false
+		for _, r := range renames {
+			address := r.address(r.oldName())
+			for i := 0; i+2*len(address)-2 < len(tokens); i++ {
+				if i > 0 && tokens[i-1].Type == hclsyntax.TokenDot {
+					continue
+				}
+				if matchesAddressTokens(tokens[i:], address) {
+					last := tokens[i+2*len(address)-2]
+					copied := *last
+					copied.Bytes = []byte(r.newName)
+					tokens[i+2*len(address)-2] = &copied
+					changed = true
+				}
+			}
+		}
+		if changed {
+			body.SetAttributeRaw(name, tokens)
+		}
+	}
+	for _, nested := range body.Blocks() {
+		renameBodyReferences(nested.Body(), renames)
+	}
+}
+
+// matchesAddressTokens reports whether tokens start with the identifiers of
+// address separated by dots, not followed by another part of a longer name
+func matchesAddressTokens(tokens hclwrite.Tokens, address []string) bool {
+	for j, name := range address {
+		if j > 0 && tokens[2*j-1].Type != hclsyntax.TokenDot {
+			return false
+		}
+		token := tokens[2*j]
+		if token.Type != hclsyntax.TokenIdent || string(token.Bytes) != name {
+			return false
+		}
+	}
+	return true
+}
+
+// renameEdits returns the edits that rename the labels of the renamed blocks and
+// the references to them within body
+func renameEdits(body *hclsyntax.Body, renames []rename) []edit {
+	var edits []edit
+
+	for _, block := range body.Blocks {
+		for _, r := range renames {
+			if block.Type != r.blockType || strings.Join(block.Labels, ".") != strings.Join(r.labels, ".") {
+				continue
+			}
+			labelRange := block.LabelRanges[len(block.LabelRanges)-1]
+			edits = append(edits, edit{start: labelRange.Start.Byte, end: labelRange.End.Byte, text: fmt.Sprintf("%q", r.newName)})
+		}
+	}
+
+	// moved blocks that point from the old address describe history and are kept as-is
+	var skip []hcl.Range
+	for _, block := range body.Blocks {
+		if block.Type == "moved" {
+			if attr, ok := block.Body.Attributes["from"]; ok {
+				skip = append(skip, attr.SrcRange)
+			}
+		}
+	}
+
+	hclsyntax.VisitAll(body, func(node hclsyntax.Node) hcl.Diagnostics {
+		expr, ok := node.(*hclsyntax.ScopeTraversalExpr)
+		if !ok {
+			return nil
+		}
+		for _, rng := range skip {
+			if rng.ContainsOffset(expr.SrcRange.Start.Byte) {
+				return nil
+			}
+		}
+		for _, r := range renames {
+			if e, ok := referenceEdit(expr.Traversal, r); ok {
+				edits = append(edits, e)
+			}
+		}
+		return nil
+	})
+
+	return edits
+}
+
+// referenceEdit returns the edit that renames the reference to r in traversal, if any
+func referenceEdit(traversal hcl.Traversal, r rename) (edit, bool) {
+	address := r.address(r.oldName())
+	if !hasAddressPrefix(traversal, address) {
+		return edit{}, false
+	}
+
+	last := traversal[len(address)-1].(hcl.TraverseAttr)
+	end := last.SrcRange.End.Byte
+	return edit{start: end - len(last.Name), end: end, text: r.newName}, true
+}
+
+// applyEdits applies non-overlapping edits to content
+func applyEdits(content []byte, edits []edit) []byte {
+	sort.Slice(edits, func(i, j int) bool {
+		return edits[i].start > edits[j].start
+	})
+	result := append([]byte(nil), content...)
+	for _, e := range edits {
+		result = append(result[:e.start], append([]byte(e.text), result[e.end:]...)...)
+	}
+	return result
+}
+
+func traversalFor(names []string) hcl.Traversal {
+	traversal := hcl.Traversal{hcl.TraverseRoot{Name: names[0]}}
+	for _, name := range names[1:] {
+		traversal = append(traversal, hcl.TraverseAttr{Name: name})
+	}
+	return traversal
+}
+
+func parseRenameTo(b *hclwrite.Block) (string, error) {
+	attr := b.Body().GetAttribute("rename_to")
+	if attr == nil {
+		return "", nil
+	}
+
+	exprBytes := attr.Expr().BuildTokens(nil).Bytes()
+	expr, diags := hclsyntax.ParseExpression(exprBytes, "rename_to", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return "", fmt.Errorf("invalid rename_to: %s", diags.Error())
+	}
+	val, diags := expr.Value(nil)
+	if diags.HasErrors() || val.Type() != cty.String {
+		return "", fmt.Errorf("invalid rename_to: must be a string")
+	}
+
+	var name string
+	if err := gocty.FromCtyValue(val, &name); err != nil {
+		return "", fmt.Errorf("invalid rename_to: %w", err)
+	}
+	if !hclsyntax.ValidIdentifier(name) {
+		return "", fmt.Errorf("invalid rename_to: %q is not a valid name", name)
+	}
+	return name, nil
+}
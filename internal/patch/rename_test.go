@@ -0,0 +1,301 @@
+package patch
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ms-henglu/graft/internal/manifest"
+)
+
+func TestApplyOverrides_Rename(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		override string
+		expected map[string]string
+	}{
+		{
+			name: "rename resource and update references",
+			files: map[string]string{
+				"main.tf": `resource "azurerm_virtual_network" "main" {
+  name = "vnet"
+}
+
+resource "azurerm_subnet" "main" {
+  name                 = "${azurerm_virtual_network.main.name}-subnet"
+  virtual_network_name = azurerm_virtual_network.main.name
+  depends_on           = [azurerm_virtual_network.main]
+}
+
+moved {
+  from = azurerm_virtual_network.main
+  to   = azurerm_virtual_network.legacy
+}
+`,
+				"outputs.tf": `output "vnet_ids" {
+  value = [for v in [azurerm_virtual_network.main] : v.id]
+}
+`,
+			},
+			override: `
+override {
+  resource "azurerm_virtual_network" "main" {
+    name = "vnet-renamed"
+    _graft {
+      rename_to = "this"
+    }
+  }
+}
+`,
+			expected: map[string]string{
+				"main.tf": `resource "azurerm_virtual_network" "this" {
+  name = "vnet"
+}
+
+resource "azurerm_subnet" "main" {
+  name                 = "${azurerm_virtual_network.this.name}-subnet"
+  virtual_network_name = azurerm_virtual_network.this.name
+  depends_on           = [azurerm_virtual_network.this]
+}
+
+moved {
+  from = azurerm_virtual_network.main
+  to   = azurerm_virtual_network.legacy
+}
+`,
+				"outputs.tf": `output "vnet_ids" {
+  value = [for v in [azurerm_virtual_network.this] : v.id]
+}
+`,
+				"_graft_override.tf": `resource "azurerm_virtual_network" "this" {
+  name = "vnet-renamed"
+}
+`,
+				"_graft_add.tf": `moved {
+  from = azurerm_virtual_network.main
+  to   = azurerm_virtual_network.this
+}
+`,
+			},
+		},
+		{
+			name: "rename data source and module call",
+			files: map[string]string{
+				"main.tf": `data "azurerm_client_config" "current" {}
+
+module "naming" {
+  source = "./naming"
+}
+
+resource "azurerm_resource_group" "main" {
+  name      = module.naming.resource_group_name
+  tenant_id = data.azurerm_client_config.current.tenant_id
+  other     = azurerm_client_config.current
+}
+`,
+			},
+			override: `
+override {
+  data "azurerm_client_config" "current" {
+    _graft {
+      rename_to = "this"
+    }
+  }
+  module "naming" {
+    _graft {
+      rename_to = "names"
+    }
+  }
+}
+`,
+			expected: map[string]string{
+				"main.tf": `data "azurerm_client_config" "this" {}
+
+module "names" {
+  source = "./naming"
+}
+
+resource "azurerm_resource_group" "main" {
+  name      = module.names.resource_group_name
+  tenant_id = data.azurerm_client_config.this.tenant_id
+  other     = azurerm_client_config.current
+}
+`,
+				"_graft_add.tf": `moved {
+  from = module.naming
+  to   = module.names
+}
+`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+					t.Fatalf("failed to create file %s: %v", name, err)
+				}
+			}
+
+			if err := applyOverrides("app", manifest.Module{OverrideBlocks: parseOverrideBlocks(t, tt.override)}, dir); err != nil {
+				t.Fatalf("applyOverrides failed: %v", err)
+			}
+
+			for name, expected := range tt.expected {
+				content, err := os.ReadFile(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatalf("failed to read file %s: %v", name, err)
+				}
+				if strings.TrimSpace(string(content)) != strings.TrimSpace(expected) {
+					t.Errorf("file %s content mismatch.\nGot:\n%s\nExpected:\n%s", name, string(content), expected)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyOverrides_RenameTwice(t *testing.T) {
+	// Root overrides edit the source files in place, so the second build
+	// finds the block renamed already
+	dir := t.TempDir()
+	source := `resource "null_resource" "old" {
+}
+
+resource "null_resource" "other" {
+}
+`
+	if err := os.WriteFile(filepath.Join(dir, "main.tf"), []byte(source), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	override := `
+override {
+  resource "null_resource" "old" {
+    triggers = { id = "x" }
+    _graft {
+      rename_to = "new"
+    }
+  }
+  resource "null_resource" "other" {
+    depends_on = [null_resource.old]
+  }
+}
+`
+
+	for i := 0; i < 2; i++ {
+		if err := applyOverrides("root", manifest.Module{OverrideBlocks: parseOverrideBlocks(t, override)}, dir); err != nil {
+			t.Fatalf("build %d: applyOverrides failed: %v", i+1, err)
+		}
+	}
+
+	expected := map[string]string{
+		"main.tf": `resource "null_resource" "new" {
+}
+
+resource "null_resource" "other" {
+}`,
+		"_graft_override.tf": `resource "null_resource" "new" {
+  triggers = { id = "x" }
+}
+resource "null_resource" "other" {
+  depends_on = [null_resource.new]
+}`,
+		"_graft_add.tf": `moved {
+  from = null_resource.old
+  to   = null_resource.new
+}`,
+	}
+	for name, want := range expected {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("failed to read file %s: %v", name, err)
+		}
+		if strings.TrimSpace(string(content)) != want {
+			t.Errorf("file %s content mismatch.\nGot:\n%s\nExpected:\n%s", name, string(content), want)
+		}
+	}
+}
+
+func TestApplyRenames_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		override string
+		errMsg   string
+	}{
+		{
+			name:   "block not found",
+			source: `resource "foo" "bar" {}`,
+			override: `
+override {
+  resource "foo" "baz" {
+    _graft {
+      rename_to = "qux"
+    }
+  }
+}
+`,
+			errMsg: "cannot rename foo.baz: block not found in module",
+		},
+		{
+			name: "name already taken",
+			source: `resource "foo" "bar" {}
+resource "foo" "qux" {}`,
+			override: `
+override {
+  resource "foo" "bar" {
+    _graft {
+      rename_to = "qux"
+    }
+  }
+}
+`,
+			errMsg: "a block with that name already exists",
+		},
+		{
+			name:   "invalid name",
+			source: `resource "foo" "bar" {}`,
+			override: `
+override {
+  resource "foo" "bar" {
+    _graft {
+      rename_to = "1st"
+    }
+  }
+}
+`,
+			errMsg: "is not a valid name",
+		},
+		{
+			name:   "unsupported block type",
+			source: `variable "foo" {}`,
+			override: `
+override {
+  variable "foo" {
+    _graft {
+      rename_to = "bar"
+    }
+  }
+}
+`,
+			errMsg: "only supported for resource, data and module blocks",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "main.tf"), []byte(tt.source), 0644); err != nil {
+				t.Fatalf("failed to create file: %v", err)
+			}
+
+			_, err := applyRenames(dir, parseOverrideBlocks(t, tt.override))
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
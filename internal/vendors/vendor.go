@@ -87,6 +87,11 @@ func VendorModules(projectDir string, m *manifest.Manifest) (map[string]string,
 			Version: modVersion,
 		}
 
+		// Record the resolved source and version, e.g. for graft.module_version
+		mod.Source = modSource
+		mod.Version = modVersion
+		m.PatchedModules[modKey] = mod
+
 		if !isLocalModule(modSource) {
 			if _, hit, err := EnsureGlobalCache(modSource, modVersion); err != nil {
 				return nil, fmt.Errorf("failed to ensure cache for %s: %w", modKey, err)
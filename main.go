@@ -33,6 +33,7 @@ func main() {
 	rootCmd.AddCommand(cmd.NewCleanCmd())
 	rootCmd.AddCommand(cmd.NewScaffoldCmd())
 	rootCmd.AddCommand(cmd.NewAbsorbCmd())
+	rootCmd.AddCommand(cmd.NewFmtCmd())
 
 	if err := rootCmd.Execute(); err != nil {
 		log.Error(err.Error())